@@ -0,0 +1,242 @@
+package alarm
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"example.com/tool/models"
+)
+
+// state is the evaluation state of one rule for one device.
+type state struct {
+	active        bool
+	pendingSince  int64 // timestamp the raise condition started to hold, 0 if it does not hold
+	clearingSince int64 // timestamp the clear condition started to hold, 0 if it does not hold
+	raisedAt      int64
+	updatedAt     int64
+	value         float64
+
+	// previous sample, used by rate-of-change rules
+	hasPrev   bool
+	prevValue float64
+	prevTime  int64
+}
+
+// Engine evaluates decoded samples against the alarm rules, keeps the active-alarm table
+// and emits raise and clear events.
+type Engine struct {
+	mu     sync.RWMutex
+	rules  map[string][]models.AlarmRule
+	states map[string]*state
+	active map[string]models.ActiveAlarm
+	events chan models.AlarmEvent
+}
+
+// NewEngine validates the rules and creates an engine whose event channel buffers up to buffer events.
+func NewEngine(config models.AlarmConfig, buffer int) (*Engine, error) {
+	rules := make(map[string][]models.AlarmRule, len(config.Rules))
+	for measurement, list := range config.Rules {
+		for _, rule := range list {
+			if rule.Type == "" {
+				rule.Type = "threshold"
+			}
+			if rule.Type != "threshold" && rule.Type != "rate" {
+				return nil, fmt.Errorf("alarm rule %q on %s: unknown type %q", rule.Name, measurement, rule.Type)
+			}
+			switch rule.Condition {
+			case ">", ">=", "<", "<=":
+			default:
+				return nil, fmt.Errorf("alarm rule %q on %s: unknown condition %q", rule.Name, measurement, rule.Condition)
+			}
+			if rule.Severity == "" {
+				rule.Severity = "warning"
+			}
+			rules[measurement] = append(rules[measurement], rule)
+		}
+	}
+
+	return &Engine{
+		rules:  rules,
+		states: make(map[string]*state),
+		active: make(map[string]models.ActiveAlarm),
+		events: make(chan models.AlarmEvent, buffer),
+	}, nil
+}
+
+// Events returns the channel raise and clear events are delivered on.
+func (e *Engine) Events() <-chan models.AlarmEvent {
+	return e.events
+}
+
+// Observe evaluates every measurement of data against the rules defined for it.
+func (e *Engine) Observe(data models.SentData) {
+	name := data.Devices[strings.LastIndex(data.Devices, ".")+1:]
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, measurement := range data.MeasurementsList {
+		for index, rule := range e.rules[measurement] {
+			if !matchDevice(rule.Devices, name, data.Devices) {
+				continue
+			}
+			key := fmt.Sprintf("%s|%d|%s", measurement, index, data.Devices)
+			e.evaluate(key, measurement, name, data.Devices, rule, data.ValuesList[i], data.Timestamps)
+		}
+	}
+}
+
+// evaluate applies one rule to one value. The caller must hold mu.
+func (e *Engine) evaluate(key, measurement, name, device string, rule models.AlarmRule, value float64, timestamp int64) {
+	st, ok := e.states[key]
+	if !ok {
+		st = &state{}
+		e.states[key] = st
+	}
+
+	input := value
+	if rule.Type == "rate" {
+		hasPrev, prevValue, prevTime := st.hasPrev, st.prevValue, st.prevTime
+		st.hasPrev, st.prevValue, st.prevTime = true, value, timestamp
+		if !hasPrev || timestamp <= prevTime {
+			return
+		}
+		input = (value - prevValue) / (float64(timestamp-prevTime) / 1000)
+	}
+	st.value = input
+	st.updatedAt = timestamp
+
+	event := models.AlarmEvent{
+		Rule:        rule.Name,
+		Measurement: measurement,
+		Device:      device,
+		Name:        name,
+		Severity:    rule.Severity,
+		Value:       input,
+		Threshold:   rule.Threshold,
+		Timestamp:   timestamp,
+	}
+
+	if !st.active {
+		if !breached(rule, input) {
+			st.pendingSince = 0
+			return
+		}
+		if st.pendingSince == 0 {
+			st.pendingSince = timestamp
+		}
+		if timestamp-st.pendingSince < int64(rule.DelayOn)*1000 {
+			return
+		}
+
+		st.active, st.pendingSince, st.raisedAt = true, 0, timestamp
+		event.State = "raise"
+		e.emit(event)
+	} else {
+		if !cleared(rule, input) {
+			st.clearingSince = 0
+			e.active[key] = activeAlarm(event, st)
+			return
+		}
+		if st.clearingSince == 0 {
+			st.clearingSince = timestamp
+		}
+		if timestamp-st.clearingSince < int64(rule.DelayOff)*1000 {
+			e.active[key] = activeAlarm(event, st)
+			return
+		}
+
+		st.active, st.clearingSince = false, 0
+		delete(e.active, key)
+		event.State = "clear"
+		e.emit(event)
+		return
+	}
+
+	e.active[key] = activeAlarm(event, st)
+}
+
+// emit sends event without blocking the pipeline.
+func (e *Engine) emit(event models.AlarmEvent) {
+	select {
+	case e.events <- event:
+	default:
+		log.Printf("alarm event queue full, dropping %s event of %q on %s", event.State, event.Rule, event.Device)
+	}
+}
+
+// Active returns the active-alarm table sorted by device and rule.
+func (e *Engine) Active() []models.ActiveAlarm {
+	e.mu.RLock()
+	alarms := make([]models.ActiveAlarm, 0, len(e.active))
+	for _, alarm := range e.active {
+		alarms = append(alarms, alarm)
+	}
+	e.mu.RUnlock()
+
+	sort.Slice(alarms, func(i, j int) bool {
+		if alarms[i].Device != alarms[j].Device {
+			return alarms[i].Device < alarms[j].Device
+		}
+		return alarms[i].Rule < alarms[j].Rule
+	})
+	return alarms
+}
+
+func activeAlarm(event models.AlarmEvent, st *state) models.ActiveAlarm {
+	return models.ActiveAlarm{
+		Rule:        event.Rule,
+		Measurement: event.Measurement,
+		Device:      event.Device,
+		Name:        event.Name,
+		Severity:    event.Severity,
+		Value:       st.value,
+		Threshold:   event.Threshold,
+		RaisedAt:    st.raisedAt,
+		UpdatedAt:   st.updatedAt,
+	}
+}
+
+// breached reports whether value meets the rule's raise condition.
+func breached(rule models.AlarmRule, value float64) bool {
+	switch rule.Condition {
+	case ">":
+		return value > rule.Threshold
+	case ">=":
+		return value >= rule.Threshold
+	case "<":
+		return value < rule.Threshold
+	default:
+		return value <= rule.Threshold
+	}
+}
+
+// cleared reports whether value has moved back past the threshold by at least the deadband.
+func cleared(rule models.AlarmRule, value float64) bool {
+	switch rule.Condition {
+	case ">":
+		return value <= rule.Threshold-rule.Deadband
+	case ">=":
+		return value < rule.Threshold-rule.Deadband
+	case "<":
+		return value >= rule.Threshold+rule.Deadband
+	default:
+		return value > rule.Threshold+rule.Deadband
+	}
+}
+
+// matchDevice reports whether the glob pattern matches the equipment name or the device path.
+func matchDevice(pattern, name, device string) bool {
+	if pattern == "" {
+		return true
+	}
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	ok, _ := path.Match(pattern, device)
+	return ok
+}
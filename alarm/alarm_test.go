@@ -0,0 +1,192 @@
+package alarm
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"example.com/tool/models"
+)
+
+func newTestEngine(t *testing.T, measurement string, rules ...models.AlarmRule) *Engine {
+	t.Helper()
+	e, err := NewEngine(models.AlarmConfig{Rules: map[string][]models.AlarmRule{measurement: rules}}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// observe feeds one kw sample of device at second.
+func observe(e *Engine, device string, second int64, value float64) {
+	e.Observe(models.SentData{
+		Timestamps:       second * 1000,
+		MeasurementsList: []string{"kw"},
+		DataTypesList:    []string{"DOUBLE"},
+		ValuesList:       []float64{value},
+		IsAligned:        true,
+		Devices:          device,
+	})
+}
+
+// events returns "<state>@<second>" for every event emitted so far.
+func events(e *Engine) []string {
+	var got []string
+	for {
+		select {
+		case event := <-e.events:
+			got = append(got, fmt.Sprintf("%s@%d", event.State, event.Timestamp/1000))
+		default:
+			return got
+		}
+	}
+}
+
+func TestNewEngineValidatesRules(t *testing.T) {
+	for _, rule := range []models.AlarmRule{
+		{Name: "type", Type: "average", Condition: ">"},
+		{Name: "condition", Condition: "=="},
+	} {
+		if _, err := NewEngine(models.AlarmConfig{Rules: map[string][]models.AlarmRule{"kw": {rule}}}, 1); err == nil {
+			t.Errorf("rule %q accepted", rule.Name)
+		}
+	}
+
+	e := newTestEngine(t, "kw", models.AlarmRule{Name: "high", Condition: ">"})
+	if rule := e.rules["kw"][0]; rule.Type != "threshold" || rule.Severity != "warning" {
+		t.Errorf("defaults %+v", rule)
+	}
+}
+
+func TestThresholdDelayOnAndOff(t *testing.T) {
+	e := newTestEngine(t, "kw", models.AlarmRule{Name: "high", Condition: ">", Threshold: 100, DelayOn: 2, DelayOff: 2})
+	device := "root.test.equipment1"
+
+	observe(e, device, 0, 150) // breached, pending
+	observe(e, device, 1, 150)
+	observe(e, device, 2, 50) // back below before the delay, pending starts over
+	observe(e, device, 3, 150)
+	observe(e, device, 4, 150)
+	if got := events(e); len(got) != 0 {
+		t.Fatalf("raised before the condition held for delayOn: %v", got)
+	}
+	observe(e, device, 5, 150) // held for 2 s
+	if got := events(e); !reflect.DeepEqual(got, []string{"raise@5"}) {
+		t.Fatalf("events %v", got)
+	}
+
+	observe(e, device, 6, 50) // clearing
+	observe(e, device, 7, 150)
+	observe(e, device, 8, 50) // clearing starts over
+	observe(e, device, 9, 50)
+	if got := events(e); len(got) != 0 {
+		t.Fatalf("cleared before the clear condition held for delayOff: %v", got)
+	}
+	observe(e, device, 10, 50)
+	if got := events(e); !reflect.DeepEqual(got, []string{"clear@10"}) {
+		t.Fatalf("events %v", got)
+	}
+	if active := e.Active(); len(active) != 0 {
+		t.Errorf("active alarms after clearing %+v", active)
+	}
+}
+
+func TestThresholdDeadband(t *testing.T) {
+	for _, test := range []struct {
+		condition string
+		raise     float64
+		inBand    []float64 // back past the threshold, but not by the deadband
+		clear     float64
+	}{
+		{">", 101, []float64{100, 95.5}, 95},
+		{">=", 100, []float64{99, 95}, 94.9},
+		{"<", 9, []float64{10, 14.5}, 15},
+		{"<=", 10, []float64{11, 15}, 15.1},
+	} {
+		threshold := 100.0
+		if test.condition == "<" || test.condition == "<=" {
+			threshold = 10
+		}
+		e := newTestEngine(t, "kw", models.AlarmRule{Name: "limit", Condition: test.condition, Threshold: threshold, Deadband: 5})
+		device := "root.test.equipment1"
+
+		observe(e, device, 0, test.raise)
+		for i, value := range test.inBand {
+			observe(e, device, int64(i+1), value)
+		}
+		if got := events(e); !reflect.DeepEqual(got, []string{"raise@0"}) {
+			t.Errorf("%s: cleared within the deadband: %v", test.condition, got)
+			continue
+		}
+		observe(e, device, 5, test.clear)
+		if got := events(e); !reflect.DeepEqual(got, []string{"clear@5"}) {
+			t.Errorf("%s: not cleared past the deadband: %v", test.condition, got)
+		}
+	}
+}
+
+func TestRateRules(t *testing.T) {
+	e := newTestEngine(t, "kw", models.AlarmRule{Name: "ramp", Type: "rate", Condition: ">", Threshold: 10})
+	device := "root.test.equipment1"
+
+	// The first sample has nothing to compare with, however large
+	observe(e, device, 0, 1000)
+	if got := events(e); len(got) != 0 {
+		t.Fatalf("raised on the first sample: %v", got)
+	}
+	if active := e.Active(); len(active) != 0 {
+		t.Fatalf("active alarms after the first sample %+v", active)
+	}
+
+	observe(e, device, 1, 1005) // 5/s
+	observe(e, device, 2, 1025) // 20/s
+	if got := events(e); !reflect.DeepEqual(got, []string{"raise@2"}) {
+		t.Fatalf("events %v", got)
+	}
+	if active := e.Active(); len(active) != 1 || active[0].Value != 20 {
+		t.Fatalf("active alarms %+v", active)
+	}
+
+	// After a gap the change is spread over the whole gap: 40 in 8 s is 5/s
+	observe(e, device, 10, 1065)
+	if got := events(e); !reflect.DeepEqual(got, []string{"clear@10"}) {
+		t.Fatalf("events after a gap %v", got)
+	}
+
+	// A sample not newer than the last one only becomes the base of the next rate
+	observe(e, device, 10, 2000)
+	if got := events(e); len(got) != 0 {
+		t.Fatalf("evaluated a sample without elapsed time: %v", got)
+	}
+	observe(e, device, 11, 2005)
+	if got := events(e); len(got) != 0 {
+		t.Errorf("events %v", got)
+	}
+}
+
+func TestActiveTable(t *testing.T) {
+	e := newTestEngine(t, "kw",
+		models.AlarmRule{Name: "high", Condition: ">", Threshold: 100, Severity: "critical"},
+		models.AlarmRule{Name: "warm", Devices: "equipment2", Condition: ">", Threshold: 50},
+	)
+	observe(e, "root.test.equipment2", 1, 150)
+	observe(e, "root.test.equipment1", 1, 150)
+	observe(e, "root.test.equipment1", 2, 170)
+
+	active := e.Active()
+	want := []models.ActiveAlarm{
+		{Rule: "high", Measurement: "kw", Device: "root.test.equipment1", Name: "equipment1", Severity: "critical", Value: 170, Threshold: 100, RaisedAt: 1000, UpdatedAt: 2000},
+		{Rule: "high", Measurement: "kw", Device: "root.test.equipment2", Name: "equipment2", Severity: "critical", Value: 150, Threshold: 100, RaisedAt: 1000, UpdatedAt: 1000},
+		{Rule: "warm", Measurement: "kw", Device: "root.test.equipment2", Name: "equipment2", Severity: "warning", Value: 150, Threshold: 50, RaisedAt: 1000, UpdatedAt: 1000},
+	}
+	if !reflect.DeepEqual(active, want) {
+		t.Errorf("active alarms\n%+v\nwant\n%+v", active, want)
+	}
+
+	// Other measurements and devices the rule does not match are ignored
+	e.Observe(models.SentData{Timestamps: 3000, MeasurementsList: []string{"pf"}, ValuesList: []float64{1000}, Devices: "root.test.equipment3"})
+	observe(e, "root.test.equipment1", 3, 10)
+	if active := e.Active(); len(active) != 2 || active[0].Device != "root.test.equipment2" {
+		t.Errorf("active alarms %+v", active)
+	}
+}
@@ -0,0 +1,282 @@
+package breaker
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/tool/metrics"
+	"example.com/tool/models"
+)
+
+// Breaker states
+const (
+	Closed   = "closed"
+	Open     = "open"
+	HalfOpen = "half-open"
+)
+
+// ErrOpen is returned for requests a breaker does not let through.
+var ErrOpen = errors.New("circuit breaker open")
+
+// stateValues are the values of the breaker state gauge.
+var stateValues = map[string]float64{Closed: 0, HalfOpen: 1, Open: 2}
+
+// Breaker opens after a number of consecutive failures. While open it lets a single probe through
+// per probe interval, the first successful probe closes it again.
+// All methods are safe to call on a nil *Breaker, which never opens.
+type Breaker struct {
+	kind, name string
+	threshold  int
+	probe      time.Duration
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	lastError string
+	openedAt  time.Time
+	nextProbe time.Time
+	removed   bool // removed from its set, no longer reported to metrics
+}
+
+// Allow reports whether a request may be sent. An open breaker allows one probe per interval.
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Now().Before(b.nextProbe) {
+			return false
+		}
+		b.setState(HalfOpen)
+		return true
+	case HalfOpen:
+		return false // a probe is under way
+	}
+	return true
+}
+
+// Name returns the name of the breaker, e.g. the device it guards.
+func (b *Breaker) Name() string {
+	if b == nil {
+		return ""
+	}
+	return b.name
+}
+
+// IsOpen reports whether the breaker is open or probing.
+func (b *Breaker) IsOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != Closed
+}
+
+// Blocked reports whether Allow would refuse a request now: while open until the next probe is due,
+// and while a probe is under way.
+func (b *Breaker) Blocked() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == HalfOpen || (b.state == Open && time.Now().Before(b.nextProbe))
+}
+
+// Success records a successful request and reports whether it closed the breaker.
+func (b *Breaker) Success() (recovered bool) {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	recovered = b.state != Closed
+	b.failures = 0
+	b.lastError = ""
+	if recovered {
+		b.setState(Closed)
+	}
+	return recovered
+}
+
+// Failure records a failed request and reports whether it opened the breaker.
+func (b *Breaker) Failure(err error) (opened bool) {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if err != nil {
+		b.lastError = err.Error()
+	}
+
+	now := time.Now()
+	switch {
+	case b.state == HalfOpen:
+		// The probe failed, wait for the next one
+		b.nextProbe = now.Add(b.probe)
+		b.setState(Open)
+	case b.state == Closed && b.failures >= b.threshold:
+		b.openedAt = now
+		b.nextProbe = now.Add(b.probe)
+		b.setState(Open)
+		return true
+	}
+	return false
+}
+
+// Reset closes the breaker.
+func (b *Breaker) Reset() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.lastError = ""
+	b.setState(Closed)
+}
+
+// setState moves the breaker to state and reports it to metrics. The caller must hold mu.
+func (b *Breaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if b.removed {
+		return
+	}
+	metrics.BreakerState.WithLabelValues(b.kind, b.name).Set(stateValues[state])
+	metrics.BreakerTransitions.WithLabelValues(b.kind, state).Inc()
+}
+
+// Status returns the current state of the breaker.
+func (b *Breaker) Status() models.BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := models.BreakerStatus{Kind: b.kind, Name: b.name, State: b.state, Failures: b.failures, LastError: b.lastError}
+	if b.state != Closed {
+		openedAt, nextProbe := b.openedAt, b.nextProbe
+		status.OpenedAt, status.NextProbe = &openedAt, &nextProbe
+	}
+	return status
+}
+
+// Set holds the breakers of one kind, e.g. of every device, created on first use.
+// All methods are safe to call on a nil *Set, whose breakers never open.
+type Set struct {
+	kind      string
+	threshold int
+	probe     time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewSet creates the breakers of kind with config, defaulting to 3 failures and a 60 s probe interval.
+func NewSet(kind string, config models.BreakerConfig) *Set {
+	s := &Set{
+		kind:      kind,
+		threshold: config.FailureThreshold,
+		probe:     time.Duration(config.ProbeIntervalMs) * time.Millisecond,
+		breakers:  make(map[string]*Breaker),
+	}
+	if s.threshold <= 0 {
+		s.threshold = 3
+	}
+	if s.probe <= 0 {
+		s.probe = 60 * time.Second
+	}
+	return s
+}
+
+// Kind returns the kind of the breakers of s.
+func (s *Set) Kind() string {
+	if s == nil {
+		return ""
+	}
+	return s.kind
+}
+
+// Get returns the breaker of name, creating a closed one if needed.
+func (s *Set) Get(name string) *Breaker {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[name]
+	if !ok {
+		b = &Breaker{kind: s.kind, name: name, threshold: s.threshold, probe: s.probe, state: Closed}
+		s.breakers[name] = b
+		metrics.BreakerState.WithLabelValues(s.kind, name).Set(stateValues[Closed])
+	}
+	return b
+}
+
+// Remove drops the breaker of name and its state series, e.g. once its device is deleted.
+// A request still under way on the breaker no longer reports to metrics.
+func (s *Set) Remove(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	b, ok := s.breakers[name]
+	delete(s.breakers, name)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removed = true
+	metrics.BreakerState.DeleteLabelValues(s.kind, name)
+}
+
+// Lookup returns the breaker of name if it exists.
+func (s *Set) Lookup(name string) (*Breaker, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.breakers[name]
+	return b, ok
+}
+
+// Snapshot returns the status of every breaker, sorted by name. With openOnly, closed breakers are left out.
+func (s *Set) Snapshot(openOnly bool) []models.BreakerStatus {
+	statuses := []models.BreakerStatus{}
+	if s == nil {
+		return statuses
+	}
+	s.mu.Lock()
+	breakers := make([]*Breaker, 0, len(s.breakers))
+	for _, b := range s.breakers {
+		breakers = append(breakers, b)
+	}
+	s.mu.Unlock()
+
+	for _, b := range breakers {
+		status := b.Status()
+		if openOnly && status.State == Closed {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
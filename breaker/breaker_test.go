@@ -0,0 +1,154 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/tool/metrics"
+	"example.com/tool/models"
+)
+
+var errDown = errors.New("connection refused")
+
+func newTestSet(kind string) *Set {
+	return NewSet(kind, models.BreakerConfig{FailureThreshold: 3, ProbeIntervalMs: 50})
+}
+
+func TestBreakerOpensAtThreshold(t *testing.T) {
+	b := newTestSet("test").Get("equipment1")
+	for i := 1; i < 3; i++ {
+		if b.Failure(errDown) {
+			t.Fatalf("opened after %d failures", i)
+		}
+		if !b.Allow() {
+			t.Fatalf("refused a request after %d failures", i)
+		}
+	}
+	if !b.Failure(errDown) {
+		t.Fatal("not opened after 3 failures")
+	}
+
+	status := b.Status()
+	if status.State != Open || status.Failures != 3 || status.LastError != errDown.Error() || status.OpenedAt == nil || status.NextProbe == nil {
+		t.Errorf("status %+v", status)
+	}
+	if b.Allow() || !b.Blocked() {
+		t.Error("an open breaker let a request through before its probe was due")
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b := newTestSet("test").Get("equipment1")
+	b.Failure(errDown)
+	b.Failure(errDown)
+	if b.Success() {
+		t.Error("a closed breaker reported recovering")
+	}
+	// The count starts over, two more failures are not enough
+	b.Failure(errDown)
+	if b.Failure(errDown) || b.IsOpen() {
+		t.Error("opened after failures that were not consecutive")
+	}
+}
+
+func TestBreakerProbes(t *testing.T) {
+	b := newTestSet("test").Get("equipment1")
+	for i := 0; i < 3; i++ {
+		b.Failure(errDown)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if b.Blocked() {
+		t.Fatal("blocked once the probe was due")
+	}
+	if !b.Allow() {
+		t.Fatal("probe not let through")
+	}
+	if b.Status().State != HalfOpen {
+		t.Fatalf("state %s while probing", b.Status().State)
+	}
+	if b.Allow() || !b.Blocked() {
+		t.Fatal("a second request let through while probing")
+	}
+
+	// A failed probe opens the breaker for another interval, without counting as newly opened
+	if b.Failure(errDown) {
+		t.Error("a failed probe reported opening")
+	}
+	if b.Status().State != Open || b.Allow() {
+		t.Fatal("not open again after a failed probe")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("second probe not let through")
+	}
+	if !b.Success() {
+		t.Error("a successful probe did not report recovering")
+	}
+	status := b.Status()
+	if status.State != Closed || status.Failures != 0 || status.LastError != "" || status.OpenedAt != nil {
+		t.Errorf("status %+v after recovering", status)
+	}
+	if !b.Allow() {
+		t.Error("a closed breaker refused a request")
+	}
+}
+
+func TestBreakerReset(t *testing.T) {
+	b := newTestSet("test").Get("equipment1")
+	for i := 0; i < 3; i++ {
+		b.Failure(errDown)
+	}
+	b.Reset()
+	if b.IsOpen() || !b.Allow() || b.Status().Failures != 0 {
+		t.Errorf("status %+v after reset", b.Status())
+	}
+}
+
+func TestNilBreakerNeverOpens(t *testing.T) {
+	var s *Set
+	b := s.Get("equipment1")
+	for i := 0; i < 10; i++ {
+		b.Failure(errDown)
+	}
+	if !b.Allow() || b.IsOpen() || b.Blocked() || b.Success() {
+		t.Error("a nil breaker opened")
+	}
+	if len(s.Snapshot(false)) != 0 {
+		t.Error("a nil set has breakers")
+	}
+	s.Remove("equipment1")
+}
+
+func TestSetRemove(t *testing.T) {
+	s := newTestSet("remove-test")
+	b := s.Get("equipment1")
+	s.Get("equipment2")
+	for i := 0; i < 3; i++ {
+		b.Failure(errDown)
+	}
+
+	s.Remove("equipment1")
+	if _, ok := s.Lookup("equipment1"); ok {
+		t.Fatal("removed breaker still in the set")
+	}
+	if snapshot := s.Snapshot(false); len(snapshot) != 1 || snapshot[0].Name != "equipment2" {
+		t.Errorf("snapshot %+v", snapshot)
+	}
+	if metrics.BreakerState.DeleteLabelValues("remove-test", "equipment1") {
+		t.Error("state series of the removed breaker kept")
+	}
+
+	// A poll that was under way when the device was deleted does not bring the series back
+	time.Sleep(60 * time.Millisecond)
+	b.Allow()
+	b.Success()
+	if metrics.BreakerState.DeleteLabelValues("remove-test", "equipment1") {
+		t.Error("state series of the removed breaker reported again")
+	}
+	if !metrics.BreakerState.DeleteLabelValues("remove-test", "equipment2") {
+		t.Error("state series of the other breaker removed")
+	}
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/tool/models"
+)
+
+// Latest keeps the last decoded sample of every device in memory.
+type Latest struct {
+	mu      sync.RWMutex
+	entries map[string]models.LatestEntry
+}
+
+// NewLatest creates an empty latest-value cache.
+func NewLatest() *Latest {
+	return &Latest{entries: make(map[string]models.LatestEntry)}
+}
+
+// DeviceName returns the equipment name of a device path, e.g. "equipment1234" for
+// "root.systex.Rich19.7F.Daisy.equipment1234".
+func DeviceName(device string) string {
+	return device[strings.LastIndex(device, ".")+1:]
+}
+
+// Observe stores data as the latest sample of its device, unless a newer sample is already cached.
+func (l *Latest) Observe(data models.SentData) {
+	name := DeviceName(data.Devices)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.entries[name]; ok && current.Data.Timestamps > data.Timestamps {
+		return
+	}
+	l.entries[name] = models.LatestEntry{Name: name, Data: data, ReceivedAt: time.Now()}
+}
+
+// Get returns the latest sample of the named device.
+func (l *Latest) Get(name string) (models.LatestEntry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entry, ok := l.entries[name]
+	return entry, ok
+}
+
+// List returns the latest sample of every device whose path starts with prefix, sorted by device path.
+func (l *Latest) List(prefix string) []models.LatestEntry {
+	l.mu.RLock()
+	entries := make([]models.LatestEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		if strings.HasPrefix(entry.Data.Devices, prefix) {
+			entries = append(entries, entry)
+		}
+	}
+	l.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Data.Devices < entries[j].Data.Devices
+	})
+	return entries
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+
+	"example.com/tool/models"
+)
+
+func sample(device string, timestamp int64, value float64) models.SentData {
+	return models.SentData{
+		Timestamps:       timestamp,
+		MeasurementsList: []string{"kw"},
+		DataTypesList:    []string{"DOUBLE"},
+		ValuesList:       []float64{value},
+		IsAligned:        true,
+		Devices:          device,
+	}
+}
+
+func TestDeviceName(t *testing.T) {
+	for device, want := range map[string]string{
+		"root.systex.Rich19.7F.Daisy.equipment1234": "equipment1234",
+		"root.test.equipment1.status":               "status",
+		"equipment1":                                "equipment1",
+		"root.test.":                                "",
+	} {
+		if got := DeviceName(device); got != want {
+			t.Errorf("DeviceName(%q) = %q, want %q", device, got, want)
+		}
+	}
+}
+
+func TestObserveKeepsNewestSample(t *testing.T) {
+	latest := NewLatest()
+	latest.Observe(sample("root.test.equipment1", 2000, 2))
+	latest.Observe(sample("root.test.equipment1", 1000, 1)) // late, ignored
+	latest.Observe(sample("root.test.equipment1", 2000, 3)) // same time, replaces
+
+	entry, ok := latest.Get("equipment1")
+	if !ok || entry.Name != "equipment1" || entry.Data.Timestamps != 2000 || entry.Data.ValuesList[0] != 3 || entry.ReceivedAt.IsZero() {
+		t.Errorf("entry %+v, %v", entry, ok)
+	}
+
+	latest.Observe(sample("root.test.equipment1", 3000, 4))
+	if entry, _ := latest.Get("equipment1"); entry.Data.ValuesList[0] != 4 {
+		t.Errorf("newer sample not kept: %+v", entry)
+	}
+	if _, ok := latest.Get("equipment2"); ok {
+		t.Error("unknown device found")
+	}
+}
+
+func TestListByPrefix(t *testing.T) {
+	latest := NewLatest()
+	for _, device := range []string{"root.b.equipment3", "root.a.equipment2", "root.a.equipment1", "root.c.equipment4"} {
+		latest.Observe(sample(device, 1000, 1))
+	}
+
+	devices := func(entries []models.LatestEntry) []string {
+		paths := []string{}
+		for _, entry := range entries {
+			paths = append(paths, entry.Data.Devices)
+		}
+		return paths
+	}
+	if got := devices(latest.List("root.a.")); !reflect.DeepEqual(got, []string{"root.a.equipment1", "root.a.equipment2"}) {
+		t.Errorf("List(root.a.) = %v", got)
+	}
+	if got := devices(latest.List("")); !reflect.DeepEqual(got, []string{"root.a.equipment1", "root.a.equipment2", "root.b.equipment3", "root.c.equipment4"}) {
+		t.Errorf("List() = %v", got)
+	}
+	if got := latest.List("root.d."); len(got) != 0 {
+		t.Errorf("List(root.d.) = %v", got)
+	}
+}
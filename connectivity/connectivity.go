@@ -0,0 +1,350 @@
+package connectivity
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/tool/metrics"
+	"example.com/tool/models"
+)
+
+// Connectivity states
+const (
+	Online   = "online"
+	Degraded = "degraded"
+	Offline  = "offline"
+	Disabled = "disabled"
+)
+
+// Measurements are the measurements of the status series written by Sample.
+var Measurements = []string{"state", "consecutive_failures"}
+
+// statusSuffix ends the path of the status series of a device, see Sample.
+const statusSuffix = ".status"
+
+// stateValues are the values of the state measurement written to the sinks.
+var stateValues = map[string]float64{Online: 0, Degraded: 1, Offline: 2, Disabled: 3}
+
+// device is the connectivity of one device.
+type device struct {
+	name, path string
+	state      string
+	since      time.Time
+	failures   int
+	lastError  string
+	lastSeen   time.Time // last sample or successful poll, zero if none yet
+	tracked    time.Time // start of tracking, or of the last enabling
+	disabled   bool
+}
+
+// Tracker keeps the connectivity state of every device from its poll outcomes and the age of its
+// last sample, and emits an event on every change.
+// Success, Failure and Observe are safe to call on a nil *Tracker, which tracks nothing.
+type Tracker struct {
+	degradedAfter, offlineAfter int
+	stale, offline              time.Duration
+	registered                  func() []models.Device
+
+	mu       sync.Mutex
+	devices  map[string]*device
+	registry map[string]bool // names registered at the last sync
+	events   chan models.StatusEvent
+	closed   bool
+}
+
+// NewTracker creates a tracker whose event channel buffers up to buffer events. registered, if not nil,
+// lists the devices of the registry: disabled ones are reported as disabled, deleted ones are forgotten.
+// Missing settings default to degraded after 1 failed poll or 60 s without a sample, offline after 3
+// failed polls or 300 s without a sample.
+func NewTracker(config models.ConnectivityConfig, registered func() []models.Device, buffer int) *Tracker {
+	t := &Tracker{
+		degradedAfter: config.DegradedAfterFailures,
+		offlineAfter:  config.OfflineAfterFailures,
+		stale:         time.Duration(config.StaleSeconds) * time.Second,
+		offline:       time.Duration(config.OfflineSeconds) * time.Second,
+		registered:    registered,
+		devices:       make(map[string]*device),
+		events:        make(chan models.StatusEvent, buffer),
+	}
+	if t.degradedAfter <= 0 {
+		t.degradedAfter = 1
+	}
+	if t.offlineAfter < t.degradedAfter {
+		t.offlineAfter = max(3, t.degradedAfter)
+	}
+	if t.stale <= 0 {
+		t.stale = 60 * time.Second
+	}
+	if t.offline < t.stale {
+		t.offline = max(300*time.Second, t.stale)
+	}
+	return t
+}
+
+// Events returns the channel state changes are delivered on. It is closed by Close.
+func (t *Tracker) Events() <-chan models.StatusEvent {
+	return t.events
+}
+
+// Success records a successful poll of the device name stored under path.
+func (t *Tracker) Success(name, path string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	d := t.device(name, path, now)
+	d.failures, d.lastError, d.lastSeen = 0, "", now
+	t.evaluate(d, now)
+}
+
+// Failure records a failed poll of the device name stored under path.
+func (t *Tracker) Failure(name, path string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	d := t.device(name, path, now)
+	d.failures++
+	if err != nil {
+		d.lastError = err.Error()
+	}
+	t.evaluate(d, now)
+}
+
+// Observe records a sample of a device, polled or pushed.
+func (t *Tracker) Observe(data models.SentData) {
+	if t == nil {
+		return
+	}
+	name := data.Devices[strings.LastIndex(data.Devices, ".")+1:]
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	d := t.device(name, data.Devices, now)
+	d.lastSeen = now
+	t.evaluate(d, now)
+}
+
+// device returns the tracked device name, starting to track it if needed. The caller must hold mu.
+func (t *Tracker) device(name, path string, now time.Time) *device {
+	d, ok := t.devices[name]
+	if !ok {
+		d = &device{name: name, tracked: now}
+		t.devices[name] = d
+	}
+	if path != "" {
+		d.path = path
+	}
+	return d
+}
+
+// assess returns the state d is in at now and why.
+func (t *Tracker) assess(d *device, now time.Time) (state, reason string) {
+	if d.disabled {
+		return Disabled, "disabled in the registry"
+	}
+
+	seen := d.lastSeen
+	if seen.IsZero() {
+		seen = d.tracked
+	}
+	age := now.Sub(seen)
+	// Nothing heard yet since the device was enabled again, wait for the first poll or the stale timeout
+	if d.lastSeen.IsZero() && d.failures == 0 && age < t.stale {
+		return d.state, ""
+	}
+
+	switch {
+	case d.failures >= t.offlineAfter:
+		return Offline, fmt.Sprintf("poll failed %d times in a row: %s", d.failures, d.lastError)
+	case age >= t.offline:
+		return Offline, fmt.Sprintf("no sample for %v", age.Round(time.Second))
+	case d.failures >= t.degradedAfter:
+		return Degraded, fmt.Sprintf("poll failed %d times in a row: %s", d.failures, d.lastError)
+	case age >= t.stale:
+		return Degraded, fmt.Sprintf("no sample for %v", age.Round(time.Second))
+	}
+	return Online, ""
+}
+
+// evaluate moves d to its current state, emitting an event if it changed. The caller must hold mu.
+func (t *Tracker) evaluate(d *device, now time.Time) {
+	state, reason := t.assess(d, now)
+	if state == d.state {
+		return
+	}
+	previous := d.state
+	d.state, d.since = state, now
+	metrics.StatusTransitions.WithLabelValues(state).Inc()
+
+	// Without a device path there is no series to write the change to
+	if d.path == "" {
+		return
+	}
+	t.emit(models.StatusEvent{
+		Name:      d.name,
+		Device:    d.path,
+		State:     state,
+		Previous:  previous,
+		Failures:  d.failures,
+		Reason:    reason,
+		Timestamp: now.UnixMilli(),
+	})
+}
+
+// emit sends event without blocking the pipeline. The caller must hold mu.
+func (t *Tracker) emit(event models.StatusEvent) {
+	if t.closed {
+		return
+	}
+	select {
+	case t.events <- event:
+	default:
+		log.Printf("status event queue full, dropping %s event of %s", event.State, event.Name)
+	}
+}
+
+// Run applies the registry and the age of the last samples every second and reports the number
+// of devices in each state to metrics, until ctx is done.
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var registered []models.Device
+		if t.registered != nil {
+			registered = t.registered()
+		}
+
+		t.mu.Lock()
+		now := time.Now()
+		if t.registered != nil {
+			t.sync(registered, now)
+		}
+		counts := map[string]int{Online: 0, Degraded: 0, Offline: 0, Disabled: 0}
+		for _, d := range t.devices {
+			t.evaluate(d, now)
+			if d.state != "" {
+				counts[d.state]++
+			}
+		}
+		t.mu.Unlock()
+
+		for state, count := range counts {
+			metrics.DevicesByStatus.WithLabelValues(state).Set(float64(count))
+		}
+	}
+}
+
+// sync applies the enabled flags of the registered devices and forgets deleted ones. The caller must hold mu.
+func (t *Tracker) sync(registered []models.Device, now time.Time) {
+	names := make(map[string]bool, len(registered))
+	for _, registeredDevice := range registered {
+		names[registeredDevice.Name] = true
+		d, ok := t.devices[registeredDevice.Name]
+		switch {
+		case !registeredDevice.Enabled && !ok:
+			t.devices[registeredDevice.Name] = &device{name: registeredDevice.Name, tracked: now, disabled: true}
+		case !registeredDevice.Enabled:
+			d.disabled = true
+		case ok && d.disabled:
+			// Enabled again, start over
+			d.disabled, d.failures, d.lastError, d.lastSeen, d.tracked = false, 0, "", time.Time{}, now
+		}
+	}
+	for name := range t.registry {
+		if !names[name] {
+			delete(t.devices, name)
+		}
+	}
+	t.registry = names
+}
+
+// Snapshot returns the state of every tracked device, sorted by name. A non-empty state only returns
+// the devices in that state, e.g. Offline.
+func (t *Tracker) Snapshot(state string) []models.DeviceStatus {
+	statuses := []models.DeviceStatus{}
+	t.mu.Lock()
+	for _, d := range t.devices {
+		if d.state == "" || (state != "" && d.state != state) {
+			continue
+		}
+		statuses = append(statuses, d.status())
+	}
+	t.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Get returns the state of the named device.
+func (t *Tracker) Get(name string) (models.DeviceStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.devices[name]
+	if !ok || d.state == "" {
+		return models.DeviceStatus{}, false
+	}
+	return d.status(), true
+}
+
+func (d *device) status() models.DeviceStatus {
+	status := models.DeviceStatus{Name: d.name, Device: d.path, State: d.state, Since: d.since, Failures: d.failures, LastError: d.lastError}
+	if !d.lastSeen.IsZero() {
+		lastSeen := d.lastSeen
+		status.LastSeen = &lastSeen
+	}
+	return status
+}
+
+// Close closes the event channel. Later state changes are still tracked but no longer emitted.
+func (t *Tracker) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.events)
+	}
+}
+
+// Sample turns event into a sample of the series "<device>.status", so that the sinks keep the
+// history of the connectivity: state 0 online, 1 degraded, 2 offline, 3 disabled.
+func Sample(event models.StatusEvent) models.SentData {
+	return models.SentData{
+		Timestamps:       event.Timestamp,
+		MeasurementsList: Measurements,
+		DataTypesList:    []string{"INT32", "INT32"},
+		ValuesList:       []float64{stateValues[event.State], float64(event.Failures)},
+		IsAligned:        true,
+		Devices:          event.Device + statusSuffix,
+	}
+}
+
+// DeviceOf returns the path of the device the status series device describes, and false with device
+// unchanged for any other series.
+func DeviceOf(device string) (string, bool) {
+	if !strings.HasSuffix(device, statusSuffix) {
+		return device, false
+	}
+	return strings.TrimSuffix(device, statusSuffix), true
+}
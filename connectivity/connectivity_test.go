@@ -0,0 +1,217 @@
+package connectivity
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"example.com/tool/models"
+)
+
+var errDown = errors.New("connection refused")
+
+func newTestTracker() *Tracker {
+	return NewTracker(models.ConnectivityConfig{DegradedAfterFailures: 2, OfflineAfterFailures: 4, StaleSeconds: 10, OfflineSeconds: 30}, nil, 100)
+}
+
+// states returns the previous and new state of every event emitted so far.
+func states(t *Tracker) []string {
+	var got []string
+	for {
+		select {
+		case event := <-t.events:
+			got = append(got, event.Previous+">"+event.State)
+		default:
+			return got
+		}
+	}
+}
+
+func TestNewTrackerDefaults(t *testing.T) {
+	tracker := NewTracker(models.ConnectivityConfig{OfflineSeconds: 5}, nil, 1)
+	if tracker.degradedAfter != 1 || tracker.offlineAfter != 3 || tracker.stale != 60*time.Second || tracker.offline != 300*time.Second {
+		t.Errorf("defaults %d, %d, %v, %v", tracker.degradedAfter, tracker.offlineAfter, tracker.stale, tracker.offline)
+	}
+
+	// Offline never comes before degraded
+	tracker = NewTracker(models.ConnectivityConfig{DegradedAfterFailures: 5, OfflineAfterFailures: 2, StaleSeconds: 600, OfflineSeconds: 60}, nil, 1)
+	if tracker.offlineAfter != 5 || tracker.offline != 600*time.Second {
+		t.Errorf("offline after %d failures or %v", tracker.offlineAfter, tracker.offline)
+	}
+}
+
+func TestAssess(t *testing.T) {
+	tracker := newTestTracker()
+	now := time.Now()
+
+	for _, test := range []struct {
+		name   string
+		device device
+		want   string
+	}{
+		{"new device waits for its first poll", device{state: "", tracked: now.Add(-5 * time.Second)}, ""},
+		{"new device without samples", device{tracked: now.Add(-10 * time.Second)}, Degraded},
+		{"new device long without samples", device{tracked: now.Add(-30 * time.Second)}, Offline},
+		{"fresh sample", device{lastSeen: now.Add(-9 * time.Second)}, Online},
+		{"one failure", device{lastSeen: now, failures: 1}, Online},
+		{"failures", device{lastSeen: now, failures: 2}, Degraded},
+		{"many failures", device{lastSeen: now, failures: 4}, Offline},
+		{"stale sample", device{lastSeen: now.Add(-10 * time.Second)}, Degraded},
+		{"old sample", device{lastSeen: now.Add(-30 * time.Second)}, Offline},
+		{"old sample and a failure", device{lastSeen: now.Add(-30 * time.Second), failures: 1}, Offline},
+		{"disabled", device{lastSeen: now.Add(-time.Hour), failures: 10, disabled: true}, Disabled},
+	} {
+		state, reason := tracker.assess(&test.device, now)
+		if state != test.want {
+			t.Errorf("%s: state %q, want %q", test.name, state, test.want)
+		}
+		if (state == Degraded || state == Offline) && reason == "" {
+			t.Errorf("%s: no reason for %s", test.name, state)
+		}
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	tracker := newTestTracker()
+	path := "root.test.equipment1"
+
+	tracker.Success("equipment1", path)
+	tracker.Failure("equipment1", path, errDown)
+	tracker.Failure("equipment1", path, errDown)
+	tracker.Failure("equipment1", path, errDown)
+	tracker.Failure("equipment1", path, errDown)
+	tracker.Failure("equipment1", path, errDown)
+	tracker.Success("equipment1", path)
+
+	want := []string{">online", "online>degraded", "degraded>offline", "offline>online"}
+	if got := states(tracker); !reflect.DeepEqual(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+
+	status, ok := tracker.Get("equipment1")
+	if !ok || status.State != Online || status.Failures != 0 || status.LastError != "" || status.LastSeen == nil {
+		t.Errorf("status %+v", status)
+	}
+}
+
+func TestObserveTracksPushedDevices(t *testing.T) {
+	tracker := newTestTracker()
+	tracker.Observe(models.SentData{Devices: "root.test.equipment7"})
+
+	select {
+	case event := <-tracker.events:
+		if event.Name != "equipment7" || event.Device != "root.test.equipment7" || event.State != Online {
+			t.Errorf("event %+v", event)
+		}
+	default:
+		t.Fatal("no event for the first sample")
+	}
+	if snapshot := tracker.Snapshot(Offline); len(snapshot) != 0 {
+		t.Errorf("offline devices %+v", snapshot)
+	}
+}
+
+func TestEvaluateMovesStaleDevices(t *testing.T) {
+	tracker := newTestTracker()
+	tracker.Success("equipment1", "root.test.equipment1")
+	states(tracker)
+
+	d := tracker.devices["equipment1"]
+	for _, step := range []struct {
+		after time.Duration
+		want  string
+	}{
+		{5 * time.Second, Online},
+		{10 * time.Second, Degraded},
+		{20 * time.Second, Degraded},
+		{30 * time.Second, Offline},
+	} {
+		tracker.evaluate(d, d.lastSeen.Add(step.after))
+		if d.state != step.want {
+			t.Errorf("%v after the last sample: %s, want %s", step.after, d.state, step.want)
+		}
+	}
+	if got := states(tracker); !reflect.DeepEqual(got, []string{"online>degraded", "degraded>offline"}) {
+		t.Errorf("events %v", got)
+	}
+}
+
+func TestSyncAppliesRegistry(t *testing.T) {
+	tracker := newTestTracker()
+	now := time.Now()
+	tracker.Failure("equipment1", "root.test.equipment1", errDown)
+	tracker.Failure("equipment1", "root.test.equipment1", errDown)
+	tracker.Success("equipment2", "root.test.equipment2")
+	states(tracker)
+
+	// Disabled devices are reported as disabled, also before their first poll
+	tracker.sync([]models.Device{{Name: "equipment1"}, {Name: "equipment2", Enabled: true}, {Name: "equipment3"}}, now)
+	for _, d := range tracker.devices {
+		tracker.evaluate(d, now)
+	}
+	if got := states(tracker); !reflect.DeepEqual(got, []string{"degraded>disabled"}) {
+		t.Errorf("events %v", got)
+	}
+	if d := tracker.devices["equipment3"]; d == nil || d.state != Disabled {
+		t.Errorf("unpolled disabled device %+v", d)
+	}
+
+	// Enabled again, the device starts over and waits for its first poll
+	tracker.sync([]models.Device{{Name: "equipment1", Enabled: true}, {Name: "equipment2", Enabled: true}}, now)
+	d := tracker.devices["equipment1"]
+	if d.disabled || d.failures != 0 || !d.lastSeen.IsZero() {
+		t.Errorf("re-enabled device %+v", d)
+	}
+	if state, _ := tracker.assess(d, now.Add(time.Second)); state != Disabled {
+		t.Errorf("re-enabled device is %s before its first poll", state)
+	}
+	tracker.Success("equipment1", "")
+	if d.state != Online {
+		t.Errorf("re-enabled device is %s after a poll", d.state)
+	}
+
+	// Deleted devices are forgotten
+	if _, ok := tracker.Get("equipment3"); ok {
+		t.Error("deleted device still tracked")
+	}
+	tracker.sync([]models.Device{{Name: "equipment1", Enabled: true}}, now)
+	if _, ok := tracker.Get("equipment2"); ok {
+		t.Error("deleted device still tracked")
+	}
+}
+
+func TestCloseStopsEvents(t *testing.T) {
+	tracker := newTestTracker()
+	tracker.Close()
+	tracker.Close()
+	tracker.Failure("equipment1", "root.test.equipment1", errDown)
+	if _, ok := <-tracker.Events(); ok {
+		t.Error("event emitted after Close")
+	}
+	if status, _ := tracker.Get("equipment1"); status.State != Online {
+		t.Errorf("state %q after Close, changes are still tracked", status.State)
+	}
+
+	var nilTracker *Tracker
+	nilTracker.Success("equipment1", "")
+	nilTracker.Failure("equipment1", "", errDown)
+	nilTracker.Observe(models.SentData{Devices: "root.test.equipment1"})
+}
+
+func TestSample(t *testing.T) {
+	data := Sample(models.StatusEvent{Device: "root.test.equipment1", State: Offline, Failures: 4, Timestamp: 42})
+	if data.Devices != "root.test.equipment1.status" || data.Timestamps != 42 || !reflect.DeepEqual(data.ValuesList, []float64{2, 4}) ||
+		!reflect.DeepEqual(data.MeasurementsList, Measurements) {
+		t.Errorf("sample %+v", data)
+	}
+}
+
+func TestDeviceOf(t *testing.T) {
+	if device, ok := DeviceOf(Sample(models.StatusEvent{Device: "root.test.equipment1"}).Devices); !ok || device != "root.test.equipment1" {
+		t.Errorf("status series maps to %q, %v", device, ok)
+	}
+	if device, ok := DeviceOf("root.test.equipment1"); ok || device != "root.test.equipment1" {
+		t.Errorf("device series maps to %q, %v", device, ok)
+	}
+}
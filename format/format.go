@@ -115,7 +115,7 @@ func ProcessData(equipmentName string, response map[string]float64, settings mod
 		DataTypesList:    dataTypesList,
 		ValuesList:       valuesList,
 		IsAligned:        true,
-		Devices:          fmt.Sprintf("root.systex.Rich19.7F.Daisy.%s", equipmentName),
+		Devices:          DevicePath(equipmentName, settings),
 	}
 
 	// Print results
@@ -125,3 +125,12 @@ func ProcessData(equipmentName string, response map[string]float64, settings mod
 
 	return sentData
 }
+
+// DevicePath returns the path a device is stored under: the bind area of its profile followed by its name.
+func DevicePath(equipmentName string, settings models.ConfigPoint) string {
+	bindArea := settings.CommonSetting.BindArea
+	if bindArea == "" {
+		bindArea = "root.systex.Rich19.7F.Daisy"
+	}
+	return fmt.Sprintf("%s.%s", bindArea, equipmentName)
+}
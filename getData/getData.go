@@ -5,13 +5,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"regexp"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"example.com/tool/breaker"
+	"example.com/tool/connectivity"
 	format "example.com/tool/format"
+	"example.com/tool/health"
+	"example.com/tool/metrics"
+	"example.com/tool/modbus"
 	"example.com/tool/models"
+	"example.com/tool/pipeline"
 	"github.com/gammazero/workerpool"
 )
 
@@ -24,14 +34,47 @@ func extractEquipmentName(url string) (string, error) {
 	return "equipment" + matches[1], nil
 }
 
+// fetchClient polls the devices over HTTP, see Configure.
+var fetchClient = http.DefaultClient
+
+// deviceTimeout bounds a whole device poll, 0 leaves it to the context of the caller.
+var deviceTimeout time.Duration
+
+// newFetchClient builds the HTTP client polling the devices from config.
+func newFetchClient(config models.FetchClient) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(config.DialTimeoutMs) * time.Millisecond,
+		KeepAlive: time.Duration(config.KeepAliveMs) * time.Millisecond,
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          config.MaxIdleConns,
+			MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+			MaxConnsPerHost:       config.MaxConnsPerHost,
+			IdleConnTimeout:       time.Duration(config.IdleConnTimeoutMs) * time.Millisecond,
+			TLSHandshakeTimeout:   time.Duration(config.TLSHandshakeTimeoutMs) * time.Millisecond,
+			ResponseHeaderTimeout: time.Duration(config.ResponseHeaderTimeoutMs) * time.Millisecond,
+		},
+	}
+}
+
+// connTrace counts whether device requests reuse a pooled connection.
+var connTrace = &httptrace.ClientTrace{
+	GotConn: func(info httptrace.GotConnInfo) {
+		metrics.FetchConnections.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+	},
+}
+
 // fetchEquipmentData fetches data from a single endpoint.
 func fetchEquipmentData(ctx context.Context, url string) (map[string]float64, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, connTrace), "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request for %s: %v", url, err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := fetchClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch data from %s: %v", url, err)
 	}
@@ -75,6 +118,112 @@ func GetData(ctx context.Context, urls []string, points models.ConfigPoint) ([]m
 	return results, errors
 }
 
+// modbusPool keeps one connection per Modbus TCP server across sweeps. Its requests share the deviceTimeout of a poll.
+var modbusPool = modbus.NewPool(deviceTimeout, modbus.NewPlanner(0))
+
+// backpressure pauses the fetch schedulers while the savers fall behind, see SetBackpressure.
+var backpressure *pipeline.Backpressure
+
+// SetBackpressure makes the fetch schedulers hold back new sweeps while b asks to, nil never holds back.
+// It must not be called while fetching.
+func SetBackpressure(b *pipeline.Backpressure) {
+	backpressure = b
+}
+
+// maxSweepsInFlight caps the sweeps of a group submitted and not yet finished, 0 allows one at a time.
+var maxSweepsInFlight int
+
+// deviceBreakers slow down the polls of failing devices, see SetBreakers.
+var deviceBreakers *breaker.Set
+
+// SetBreakers polls a device only at the probe rate of its breaker in breakers once it keeps failing,
+// nil polls every device on every sweep. It must not be called while fetching.
+func SetBreakers(breakers *breaker.Set) {
+	deviceBreakers = breakers
+}
+
+// connectivityTracker tracks the outcome of every device poll, see SetConnectivity.
+var connectivityTracker *connectivity.Tracker
+
+// SetConnectivity reports the outcome of every device poll to tracker, nil reports nothing.
+// It must not be called while fetching.
+func SetConnectivity(tracker *connectivity.Tracker) {
+	connectivityTracker = tracker
+}
+
+// Configure applies the fetch settings of config. It must be called before fetching starts.
+func Configure(config models.Config) {
+	maxSweepsInFlight = config.MaxSweepsInFlight
+	fetchClient = newFetchClient(config.FetchClient)
+	deviceTimeout = time.Duration(config.FetchClient.DeviceTimeoutMs) * time.Millisecond
+	modbusPool = modbus.NewPool(deviceTimeout, modbus.NewPlanner(config.ModbusGapTolerance))
+}
+
+// fetchTarget reads the raw values of a target over its protocol.
+// Responses are recorded if a recorder is set.
+func fetchTarget(ctx context.Context, target models.Target) (map[string]float64, error) {
+	var data map[string]float64
+	var err error
+	if target.Protocol == "modbus" {
+		data, err = modbusPool.Read(ctx, target.Address, target.UnitID, target.Profile, target.Points)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", target.Name, err)
+		}
+	} else if data, err = fetchEquipmentData(ctx, target.URL); err != nil {
+		return nil, err
+	}
+
+	record(target, data)
+	return data, nil
+}
+
+// GetTargetData fetches data from a list of targets and decodes each response with the target's points.
+func GetTargetData(ctx context.Context, targets []models.Target) ([]models.SentData, []error) {
+	var results []models.SentData
+	var errors []error
+
+	for _, target := range targets {
+		data, err := fetchTarget(ctx, target)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+
+		results = append(results, format.ProcessData(target.Name, data, target.Points))
+	}
+
+	return results, errors
+}
+
+// DeviceTargets builds the targets of registered devices, decoding each device with its point profile.
+func DeviceTargets(host string, devices []models.Device, profiles map[string]models.ConfigPoint) ([]models.Target, error) {
+	targets := make([]models.Target, 0, len(devices))
+	for _, device := range devices {
+		points, ok := profiles[device.Profile]
+		if !ok {
+			return nil, fmt.Errorf("device %s uses unknown point profile %q", device.Name, device.Profile)
+		}
+		deviceHost := device.Host
+		if deviceHost == "" {
+			deviceHost = host
+		}
+		protocol := device.Protocol
+		if protocol == "" {
+			protocol = "http"
+		}
+		targets = append(targets, models.Target{
+			Name:     device.Name,
+			URL:      fmt.Sprintf("http://%s:%d/%s", deviceHost, device.Port, device.Name),
+			Protocol: protocol,
+			Address:  net.JoinHostPort(deviceHost, strconv.Itoa(device.Port)),
+			UnitID:   device.UnitID,
+			Profile:  device.Profile,
+			Points:   points,
+		})
+	}
+	return targets, nil
+}
+
 // PrepareAndFetchData prepares URLs based on given parameters and fetches data using concurrent goroutines.
 func PrepareAndFetchData(ctx context.Context, config models.Config, points models.ConfigPoint, startRange, endRange, portStart, portEnd int, messageQueue chan<- models.SentData, wp *workerpool.WorkerPool, apiRequestCount *int32) {
 	// Prepare URLs
@@ -115,15 +264,105 @@ func PrepareAndFetchData(ctx context.Context, config models.Config, points model
 	}
 }
 
-func PrepareAndFetchDataNoCount(ctx context.Context, config models.Config, points models.ConfigPoint, startRange, endRange, portStart, portEnd int, messageQueue chan<- models.SentData, wp *workerpool.WorkerPool) {
-	// Prepare URLs
-	urls := make([]string, 0)
+// PrepareAndFetchDataNoCount prepares URLs based on given parameters and fetches data using concurrent goroutines.
+// The scheduling loop and the fetch results are reported to monitor as "scheduler/<portStart>" and "fetch/<portStart>".
+func PrepareAndFetchDataNoCount(ctx context.Context, config models.Config, points models.ConfigPoint, startRange, endRange, portStart, portEnd int, messageQueue chan<- models.SentData, wp *workerpool.WorkerPool, monitor *health.Monitor) {
+	// Prepare targets
+	targets := make([]models.Target, 0)
 	host := config.GetDataApiHost
 
 	for portOffset := portStart; portOffset <= portEnd; portOffset++ {
 		for i := startRange; i <= endRange; i++ {
-			url := fmt.Sprintf("http://%s:%d/equipment%d", host, 3000+portOffset, i)
-			urls = append(urls, url)
+			targets = append(targets, models.Target{
+				Name:   fmt.Sprintf("equipment%d", i),
+				URL:    fmt.Sprintf("http://%s:%d/equipment%d", host, 3000+portOffset, i),
+				Points: points,
+			})
+		}
+	}
+
+	FetchTargets(ctx, strconv.Itoa(portStart), func() []models.Target { return targets }, messageQueue, wp, monitor)
+}
+
+// TargetSource provides the current targets of every device group, e.g. the device registry.
+type TargetSource interface {
+	Groups() []string
+	Targets(group string) []models.Target
+	Changed() <-chan struct{}
+}
+
+// FetchGroups fetches data from every group of source with a worker pool per group.
+// Groups that appear while running are started as soon as source signals a change.
+// It returns once ctx is done and all worker pools have stopped.
+func FetchGroups(ctx context.Context, source TargetSource, semaphore int, messageQueue chan<- models.SentData, monitor *health.Monitor) {
+	pools := make(map[string]*workerpool.WorkerPool)
+	var wg sync.WaitGroup
+
+	startGroups := func() {
+		for _, group := range source.Groups() {
+			if _, ok := pools[group]; ok {
+				continue
+			}
+			wp := workerpool.New(semaphore)
+			pools[group] = wp
+
+			wg.Add(1)
+			go func(group string) {
+				defer wg.Done()
+				FetchTargets(ctx, group, func() []models.Target { return source.Targets(group) }, messageQueue, wp, monitor)
+			}(group)
+		}
+	}
+
+	startGroups()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			for _, wp := range pools {
+				wp.StopWait()
+			}
+			return
+		case <-source.Changed():
+			startGroups()
+		}
+	}
+}
+
+// FetchTargets repeatedly polls the targets of a group, every device poll being a task of its own on wp.
+// targets is called before every sweep so that changes to the group take effect immediately.
+// At most wp.Size() polls are submitted and not yet finished, so polls are only submitted as fast as
+// the pool finishes them and its waiting queue stays short. Up to maxSweepsInFlight sweeps overlap,
+// a device still being polled by an earlier sweep is skipped, so a slow device never holds back the others.
+// Every sample is queued as soon as its device has been read, with the time it was read.
+// The scheduling loop and the fetch results are reported to monitor as "scheduler/<group>" and "fetch/<group>".
+func FetchTargets(ctx context.Context, group string, targets func() []models.Target, messageQueue chan<- models.SentData, wp *workerpool.WorkerPool, monitor *health.Monitor) {
+	schedulerName := "scheduler/" + group
+	fetchName := "fetch/" + group
+
+	sweepLimit := maxSweepsInFlight
+	if sweepLimit <= 0 {
+		sweepLimit = 1
+	}
+	sweeps := make(chan struct{}, sweepLimit)
+	polls := make(chan struct{}, wp.Size())
+	var polling sync.Map               // names of the devices being polled
+	finished := make(chan struct{}, 1) // signalled whenever a poll finishes
+
+	heartbeat := time.NewTicker(1 * time.Second)
+	defer heartbeat.Stop()
+
+	// acquire takes a slot of limit, beating meanwhile so a busy pool does not look stalled
+	acquire := func(limit chan struct{}) bool {
+		for {
+			select {
+			case limit <- struct{}{}:
+				return true
+			case <-heartbeat.C:
+				monitor.Beat(schedulerName)
+			case <-ctx.Done():
+				return false
+			}
 		}
 	}
 
@@ -133,22 +372,155 @@ func PrepareAndFetchDataNoCount(ctx context.Context, config models.Config, point
 		case <-ctx.Done():
 			return
 		default:
-			wp.Submit(func() {
-				data, errs := GetData(ctx, urls, points)
-				for _, err := range errs {
-					// Only log errors if the context is not done
-					if ctx.Err() == nil {
-						log.Printf("Errors occurred while fetching data: %v", err)
-					}
+			monitor.Beat(schedulerName)
+
+			// The savers are behind, let them catch up before queueing more
+			if backpressure.Paused() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+
+			if !acquire(sweeps) {
+				return
+			}
+
+			list := targets()
+			if len(list) == 0 {
+				<-sweeps
+				time.Sleep(1 * time.Second)
+				continue
+			}
+
+			metrics.SweepsInFlight.WithLabelValues(group).Inc()
+			sweep := &sweepState{started: time.Now()}
+			sweep.remaining.Store(int32(len(list)))
+			sweep.finish = func() {
+				metrics.SweepDuration.WithLabelValues(group).Observe(time.Since(sweep.started).Seconds())
+				metrics.SweepsInFlight.WithLabelValues(group).Dec()
+				if sweep.succeeded.Load() > 0 {
+					monitor.Success(fetchName)
+				} else if err := sweep.err(); err != nil && ctx.Err() == nil {
+					monitor.Failure(fetchName, err)
 				}
+				<-sweeps
+			}
 
-				for _, item := range data {
-					messageQueue <- item
+			submitted := 0
+			for _, target := range list {
+				// Devices that keep failing are only probed at the probe rate of their breaker
+				deviceBreaker := deviceBreakers.Get(target.Name)
+				if _, busy := polling.LoadOrStore(target.Name, true); busy {
+					sweep.done()
+					continue
+				}
+				if !deviceBreaker.Allow() {
+					polling.Delete(target.Name)
+					sweep.done()
+					continue
+				}
+				if !acquire(polls) {
+					return
 				}
-			})
 
-			// Add a sleep interval to prevent resource exhaustion
-			time.Sleep(1 * time.Millisecond)
+				submitted++
+				wp.Submit(func() {
+					defer func() {
+						polling.Delete(target.Name)
+						<-polls
+						sweep.done()
+						select {
+						case finished <- struct{}{}:
+						default:
+						}
+					}()
+
+					data, err := pollTarget(ctx, target)
+					if err != nil {
+						// Only log errors if the context is not done, and only until the breaker opens
+						if ctx.Err() == nil {
+							connectivityTracker.Failure(target.Name, format.DevicePath(target.Name, target.Points), err)
+							wasOpen := deviceBreaker.IsOpen()
+							if deviceBreaker.Failure(err) {
+								log.Printf("device %s keeps failing, polling it at the probe rate until it recovers: %v", target.Name, err)
+							} else if !wasOpen {
+								log.Printf("Errors occurred while fetching data: %v", err)
+							}
+							sweep.fail(err)
+						}
+						return
+					}
+					if deviceBreaker.Success() {
+						log.Printf("device %s recovered", target.Name)
+					}
+					connectivityTracker.Success(target.Name, data.Devices)
+					sweep.succeeded.Add(1)
+					messageQueue <- data
+				})
+			}
+			metrics.FetchWaitingTasks.WithLabelValues(group).Set(float64(wp.WaitingQueueSize()))
+
+			// Every device was still busy or held back by its breaker, wait for a poll to finish or
+			// a probe to come due instead of sweeping again right away
+			if submitted == 0 {
+				select {
+				case <-finished:
+				case <-time.After(idleSweepWait):
+				case <-ctx.Done():
+					return
+				}
+			}
 		}
 	}
 }
+
+// idleSweepWait is the longest FetchTargets waits after a sweep that polled no device.
+var idleSweepWait = 1 * time.Second
+
+// sweepState tracks the device polls of one sweep.
+type sweepState struct {
+	started   time.Time
+	remaining atomic.Int32
+	succeeded atomic.Int32
+	finish    func() // called once the last poll is done
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func (s *sweepState) fail(err error) {
+	s.mu.Lock()
+	s.lastErr = err
+	s.mu.Unlock()
+}
+
+func (s *sweepState) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+func (s *sweepState) done() {
+	if s.remaining.Add(-1) == 0 {
+		s.finish()
+	}
+}
+
+// pollTarget reads a single target within deviceTimeout and decodes its response,
+// timestamped with the time it was read.
+func pollTarget(ctx context.Context, target models.Target) (models.SentData, error) {
+	if deviceTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deviceTimeout)
+		defer cancel()
+	}
+
+	data, err := fetchTarget(ctx, target)
+	if err != nil {
+		return models.SentData{}, err
+	}
+	readAt := time.Now()
+
+	sample := format.ProcessData(target.Name, data, target.Points)
+	sample.Timestamps = readAt.UnixMilli()
+	return sample, nil
+}
@@ -0,0 +1,189 @@
+package getData
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/tool/breaker"
+	"example.com/tool/models"
+	"github.com/gammazero/workerpool"
+)
+
+// deviceServer answers every device poll after delay, or with 500 while failing is set.
+type deviceServer struct {
+	*httptest.Server
+	delay    time.Duration
+	failing  atomic.Bool
+	requests atomic.Int64
+}
+
+func newDeviceServer(t *testing.T, delay time.Duration) *deviceServer {
+	s := &deviceServer{delay: delay}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		select {
+		case <-time.After(s.delay):
+		case <-r.Context().Done():
+			return
+		}
+		if s.failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"Address1": 1}`)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *deviceServer) targets(n int) []models.Target {
+	points := models.ConfigPoint{ChannelSetting: map[string]models.Point{"kw": {Value: []string{"Address1"}}}}
+	targets := make([]models.Target, n)
+	for i := range targets {
+		name := fmt.Sprintf("equipment%d", i+1)
+		targets[i] = models.Target{Name: name, URL: s.URL + "/" + name, Points: points}
+	}
+	return targets
+}
+
+// setMaxSweepsInFlight sets maxSweepsInFlight for the test and restores it afterwards.
+func setMaxSweepsInFlight(t *testing.T, limit int) {
+	previous := maxSweepsInFlight
+	t.Cleanup(func() { maxSweepsInFlight = previous })
+	maxSweepsInFlight = limit
+}
+
+// setBreakers sets the device breakers for the test and restores them afterwards.
+func setBreakers(t *testing.T, breakers *breaker.Set) {
+	previous := deviceBreakers
+	t.Cleanup(func() { SetBreakers(previous) })
+	SetBreakers(breakers)
+}
+
+// runFetch runs FetchTargets over targets with a pool of size workers for d, draining the samples,
+// and returns the number of samples and of calls to targets.
+func runFetch(t *testing.T, targets []models.Target, size int, d time.Duration, observe func(wp *workerpool.WorkerPool)) (samples, sweeps int64) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	messageQueue := make(chan models.SentData)
+	var received atomic.Int64
+	go func() {
+		for range messageQueue {
+			received.Add(1)
+		}
+	}()
+
+	wp := workerpool.New(size)
+	var calls atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		FetchTargets(ctx, "test", func() []models.Target {
+			calls.Add(1)
+			return targets
+		}, messageQueue, wp, nil)
+	}()
+
+	if observe != nil {
+		for ctx.Err() == nil {
+			observe(wp)
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+	wp.StopWait()
+	close(messageQueue)
+	return received.Load(), calls.Load()
+}
+
+func TestFetchTargetsWaitingQueueStaysBounded(t *testing.T) {
+	server := newDeviceServer(t, 20*time.Millisecond)
+	targets := server.targets(200)
+
+	maxWaiting := 0
+	samples, _ := runFetch(t, targets, 4, time.Second, func(wp *workerpool.WorkerPool) {
+		maxWaiting = max(maxWaiting, wp.WaitingQueueSize())
+	})
+
+	// Polls are only submitted once a worker is free, so nothing piles up however slow the devices are
+	if maxWaiting > 4 {
+		t.Errorf("waiting queue grew to %d tasks with 4 workers", maxWaiting)
+	}
+	// 4 workers polling 20 ms devices for a second
+	if samples < 50 {
+		t.Errorf("only %d samples queued", samples)
+	}
+}
+
+func TestFetchTargetsMemoryStaysFlat(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+	server := newDeviceServer(t, 5*time.Millisecond)
+	targets := server.targets(200)
+
+	// Goroutines and live heap every 250 ms, after the first second of warming up
+	var goroutines []int
+	var heaps []uint64
+	started, last := time.Now(), time.Now()
+	runFetch(t, targets, 8, 4*time.Second, func(wp *workerpool.WorkerPool) {
+		if time.Since(started) < time.Second || time.Since(last) < 250*time.Millisecond {
+			return
+		}
+		last = time.Now()
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		goroutines = append(goroutines, runtime.NumGoroutine())
+		heaps = append(heaps, stats.HeapAlloc)
+	})
+	if len(goroutines) < 8 {
+		t.Fatalf("only %d measurements", len(goroutines))
+	}
+
+	// Thousands of polls later, the second half uses no more than the first, give or take the
+	// goroutines of a few connections
+	half := len(goroutines) / 2
+	if first, second := slices.Max(goroutines[:half]), slices.Max(goroutines[half:]); second > first+20 {
+		t.Errorf("goroutines grew from %d to %d", first, second)
+	}
+	if first, second := slices.Max(heaps[:half]), slices.Max(heaps[half:]); second > first+first/2+1<<20 {
+		t.Errorf("live heap grew from %d to %d bytes", first, second)
+	}
+}
+
+func TestFetchTargetsWaitsWhenAllDevicesBusy(t *testing.T) {
+	setMaxSweepsInFlight(t, 2)
+
+	// The only device answers after the test ends, so every further sweep finds it busy
+	server := newDeviceServer(t, 2*time.Second)
+	_, sweeps := runFetch(t, server.targets(1), 2, 300*time.Millisecond, nil)
+	if sweeps > 3 {
+		t.Errorf("swept %d times in 300 ms while the only device was busy", sweeps)
+	}
+}
+
+func TestFetchTargetsWaitsWhenAllBreakersOpen(t *testing.T) {
+	setBreakers(t, breaker.NewSet("device", models.BreakerConfig{FailureThreshold: 1, ProbeIntervalMs: 60000}))
+
+	server := newDeviceServer(t, 0)
+	server.failing.Store(true)
+	_, sweeps := runFetch(t, server.targets(1), 2, 300*time.Millisecond, nil)
+	if requests := server.requests.Load(); requests != 1 {
+		t.Errorf("polled a device with an open breaker %d times", requests)
+	}
+	if sweeps > 4 {
+		t.Errorf("swept %d times in 300 ms while every breaker was open", sweeps)
+	}
+}
@@ -0,0 +1,224 @@
+package getData
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	format "example.com/tool/format"
+	"example.com/tool/health"
+	"example.com/tool/models"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// topicMatcher maps the topics of one pattern to equipment names.
+type topicMatcher struct {
+	levels  []string // pattern levels, placeholders are kept as {name}
+	filter  string   // the pattern as MQTT subscription filter
+	name    string
+	profile string
+	points  models.ConfigPoint
+}
+
+// newTopicMatcher compiles a topic mapping.
+func newTopicMatcher(topic models.MQTTTopic, profiles map[string]models.ConfigPoint) (*topicMatcher, error) {
+	m := &topicMatcher{
+		levels:  strings.Split(topic.Pattern, "/"),
+		name:    topic.Name,
+		profile: topic.Profile,
+	}
+	if m.name == "" {
+		m.name = "{equipment}"
+	}
+	if m.profile == "" {
+		m.profile = "default"
+	}
+
+	points, ok := profiles[m.profile]
+	if !ok {
+		return nil, fmt.Errorf("mqtt topic %s uses unknown point profile %q", topic.Pattern, m.profile)
+	}
+	m.points = points
+
+	filter := make([]string, len(m.levels))
+	placeholders := make(map[string]bool)
+	for i, level := range m.levels {
+		if isPlaceholder(level) {
+			filter[i] = "+"
+			placeholders[level] = true
+		} else {
+			filter[i] = level
+		}
+	}
+	m.filter = strings.Join(filter, "/")
+
+	// Every placeholder of the name must be filled from the topic, or no message would ever match
+	for _, placeholder := range placeholderPattern.FindAllString(m.name, -1) {
+		if !placeholders[placeholder] {
+			return nil, fmt.Errorf("mqtt topic %s: name %q uses %s, which the pattern does not define", topic.Pattern, m.name, placeholder)
+		}
+	}
+
+	return m, nil
+}
+
+// placeholderPattern finds the placeholders of a name template.
+var placeholderPattern = regexp.MustCompile(`\{[^{}/]+\}`)
+
+// equipmentName returns the equipment name of topic, or false if the topic does not match the pattern.
+func (m *topicMatcher) equipmentName(topic string) (string, bool) {
+	levels := strings.Split(topic, "/")
+	name := m.name
+
+	for i, level := range m.levels {
+		if level == "#" {
+			break
+		}
+		if i >= len(levels) {
+			return "", false
+		}
+		switch {
+		case level == "+":
+		case isPlaceholder(level):
+			name = strings.ReplaceAll(name, level, levels[i])
+		case level != levels[i]:
+			return "", false
+		}
+	}
+	if len(levels) > len(m.levels) && m.levels[len(m.levels)-1] != "#" {
+		return "", false
+	}
+
+	return name, !strings.Contains(name, "{")
+}
+
+func isPlaceholder(level string) bool {
+	return len(level) > 2 && strings.HasPrefix(level, "{") && strings.HasSuffix(level, "}")
+}
+
+// CheckMQTTSource reports configuration errors of source, e.g. at startup before subscribing.
+func CheckMQTTSource(source models.MQTTSource, profiles map[string]models.ConfigPoint) error {
+	_, err := compileTopics(source, profiles)
+	return err
+}
+
+// compileTopics validates source and compiles its topic mappings.
+func compileTopics(source models.MQTTSource, profiles map[string]models.ConfigPoint) ([]*topicMatcher, error) {
+	// The session is persistent, a missing or changing client id would lose the messages queued for it
+	if source.ClientID == "" {
+		return nil, fmt.Errorf("mqttSource needs a stable clientId for its persistent session")
+	}
+	if len(source.Topics) == 0 {
+		return nil, fmt.Errorf("mqttSource has no topics")
+	}
+	matchers := make([]*topicMatcher, 0, len(source.Topics))
+	for _, topic := range source.Topics {
+		matcher, err := newTopicMatcher(topic, profiles)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// SubscribeMQTT subscribes to the configured topics with QoS 1 on a persistent session and feeds
+// the decoded payloads into messageQueue until ctx is done. Payloads are JSON objects of
+// "AddressN" values, like the responses of the HTTP device API. A message is acknowledged once its
+// sample is queued, messages not queued by then are delivered again on the next session.
+// Once it returns no further samples are written to messageQueue.
+func SubscribeMQTT(ctx context.Context, source models.MQTTSource, profiles map[string]models.ConfigPoint, messageQueue chan<- models.SentData, monitor *health.Monitor) error {
+	matchers, err := compileTopics(source, profiles)
+	if err != nil {
+		return err
+	}
+
+	// handlers tracks running message handlers so that none writes to messageQueue after return.
+	// Handlers only start while accepting is set, so none can start once SubscribeMQTT waits for them.
+	var handlers sync.WaitGroup
+	var mu sync.Mutex
+	accepting := true
+	begin := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if accepting {
+			handlers.Add(1)
+		}
+		return accepting
+	}
+
+	handle := func(matcher *topicMatcher) mqtt.MessageHandler {
+		return func(client mqtt.Client, message mqtt.Message) {
+			if !begin() {
+				return // not acknowledged, the broker delivers it again
+			}
+			defer handlers.Done()
+
+			equipmentName, ok := matcher.equipmentName(message.Topic())
+			if !ok {
+				log.Printf("unable to extract equipment name from MQTT topic: %s", message.Topic())
+				message.Ack()
+				return
+			}
+
+			var data map[string]float64
+			if err := json.Unmarshal(message.Payload(), &data); err != nil {
+				log.Printf("failed to decode MQTT payload from %s: %v", message.Topic(), err)
+				monitor.Failure("mqtt", err)
+				message.Ack()
+				return
+			}
+
+			record(models.Target{Name: equipmentName, URL: "mqtt://" + message.Topic(), Profile: matcher.profile}, data)
+
+			// Blocking here holds back the acknowledgement, so the broker keeps unprocessed messages
+			select {
+			case messageQueue <- format.ProcessData(equipmentName, data, matcher.points):
+				message.Ack()
+				monitor.Success("mqtt")
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	subscribe := func(client mqtt.Client) {
+		for _, matcher := range matchers {
+			token := client.Subscribe(matcher.filter, 1, handle(matcher))
+			if token.Wait() && token.Error() != nil {
+				log.Printf("failed to subscribe to MQTT topic %s: %v", matcher.filter, token.Error())
+				monitor.Failure("mqtt", token.Error())
+			}
+		}
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(source.Broker).
+		SetClientID(source.ClientID).
+		SetUsername(source.Username).
+		SetPassword(source.Password).
+		SetCleanSession(false).
+		SetAutoAckDisabled(true).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(subscribe).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			log.Printf("MQTT connection lost: %v", err)
+			monitor.Failure("mqtt", err)
+		})
+
+	client := mqtt.NewClient(options)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to connect to MQTT broker %s: %v", source.Broker, token.Error())
+	}
+
+	<-ctx.Done()
+	mu.Lock()
+	accepting = false
+	mu.Unlock()
+	handlers.Wait()
+	client.Disconnect(1000)
+	return nil
+}
@@ -0,0 +1,159 @@
+package getData
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"example.com/tool/models"
+	"example.com/tool/mqtttest"
+)
+
+var mqttProfiles = map[string]models.ConfigPoint{
+	"default": {
+		CommonSetting:  models.CommonSetting{BindArea: "root.test"},
+		ChannelSetting: map[string]models.Point{"kw": {Value: []string{"Address1"}}},
+	},
+}
+
+func TestTopicMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name, topic string
+		want                 string
+		ok                   bool
+	}{
+		{"gateways/+/meters/{id}/telemetry", "equipment{id}", "gateways/g1/meters/42/telemetry", "equipment42", true},
+		{"gateways/+/meters/{id}/telemetry", "equipment{id}", "gateways/g1/meters/42/status", "", false},
+		{"gateways/+/meters/{id}/telemetry", "equipment{id}", "gateways/g1/meters/42", "", false},
+		{"devices/{equipment}", "", "devices/equipment7", "equipment7", true},
+		{"site/{site}/{id}/#", "{site}-{id}", "site/north/3/a/b", "north-3", true},
+		{"site/{site}/{id}", "{site}-{id}", "site/north/3/a", "", false},
+	} {
+		m, err := newTopicMatcher(models.MQTTTopic{Pattern: tc.pattern, Name: tc.name}, mqttProfiles)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.pattern, err)
+		}
+		name, ok := m.equipmentName(tc.topic)
+		if name != tc.want || ok != tc.ok {
+			t.Errorf("%s matched %s as %q %v, want %q %v", tc.pattern, tc.topic, name, ok, tc.want, tc.ok)
+		}
+	}
+
+	m, _ := newTopicMatcher(models.MQTTTopic{Pattern: "gateways/+/meters/{id}/telemetry", Name: "equipment{id}"}, mqttProfiles)
+	if m.filter != "gateways/+/meters/+/telemetry" {
+		t.Errorf("subscribes to %s", m.filter)
+	}
+}
+
+func TestCheckMQTTSource(t *testing.T) {
+	topic := models.MQTTTopic{Pattern: "meters/{id}", Name: "equipment{id}"}
+	if err := CheckMQTTSource(models.MQTTSource{ClientID: "reader", Topics: []models.MQTTTopic{topic}}, mqttProfiles); err != nil {
+		t.Fatalf("rejected a valid source: %v", err)
+	}
+
+	for name, source := range map[string]models.MQTTSource{
+		"empty client id":      {Topics: []models.MQTTTopic{topic}},
+		"no topics":            {ClientID: "reader"},
+		"default name":         {ClientID: "reader", Topics: []models.MQTTTopic{{Pattern: "meters/{id}"}}},
+		"undefined name field": {ClientID: "reader", Topics: []models.MQTTTopic{{Pattern: "meters/{id}", Name: "{site}-{id}"}}},
+		"unknown profile":      {ClientID: "reader", Topics: []models.MQTTTopic{{Pattern: "meters/{id}", Name: "equipment{id}", Profile: "water"}}},
+	} {
+		if err := CheckMQTTSource(source, mqttProfiles); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+// subscribeTest subscribes to broker like the collector and returns a function stopping the subscriber.
+func subscribeTest(t *testing.T, broker *mqtttest.Broker, messageQueue chan models.SentData) (stop func()) {
+	t.Helper()
+	source := models.MQTTSource{
+		Broker:   broker.Addr,
+		ClientID: "reader",
+		Topics:   []models.MQTTTopic{{Pattern: "meters/{id}", Name: "equipment{id}"}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- SubscribeMQTT(ctx, source, mqttProfiles, messageQueue, nil) }()
+
+	if !broker.WaitSubscribed("meters/+", 5*time.Second) {
+		cancel()
+		t.Fatal("never subscribed")
+	}
+	return func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("subscriber failed: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("subscriber did not stop")
+		}
+	}
+}
+
+func TestSubscribeMQTT(t *testing.T) {
+	broker := mqtttest.NewBroker()
+	defer broker.Close()
+
+	messageQueue := make(chan models.SentData)
+	stop := subscribeTest(t, broker, messageQueue)
+	defer stop()
+
+	id := broker.Publish("meters/42", []byte(`{"Address1": 230}`))
+	if id == 0 {
+		t.Fatal("no subscriber for meters/42")
+	}
+
+	// Not acknowledged before the sample is queued
+	time.Sleep(100 * time.Millisecond)
+	if broker.Acked(id) {
+		t.Fatal("acknowledged a message whose sample is not queued")
+	}
+
+	select {
+	case data := <-messageQueue:
+		if data.Devices != "root.test.equipment42" || len(data.ValuesList) != 1 || data.ValuesList[0] != 230 {
+			t.Errorf("queued %+v", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no sample queued")
+	}
+	if !broker.WaitAcked(id, 5*time.Second) {
+		t.Error("queued message not acknowledged")
+	}
+
+	// A payload that can never be decoded is acknowledged and dropped
+	id = broker.Publish("meters/43", []byte(`not json`))
+	if !broker.WaitAcked(id, 5*time.Second) {
+		t.Error("undecodable message not acknowledged")
+	}
+}
+
+func TestSubscribeMQTTLeavesUnqueuedMessagesUnacknowledged(t *testing.T) {
+	broker := mqtttest.NewBroker()
+	defer broker.Close()
+
+	// Nobody reads the queue, so the handler blocks until the subscriber stops
+	messageQueue := make(chan models.SentData)
+	stop := subscribeTest(t, broker, messageQueue)
+
+	var ids []uint16
+	for i := 0; i < 5; i++ {
+		ids = append(ids, broker.Publish("meters/42", []byte(`{"Address1": 1}`)))
+	}
+	time.Sleep(100 * time.Millisecond)
+	stop()
+
+	select {
+	case data := <-messageQueue:
+		t.Fatalf("queued %+v after the subscriber stopped", data)
+	default:
+	}
+	for _, id := range ids {
+		if broker.Acked(id) {
+			t.Errorf("message %d acknowledged without being queued", id)
+		}
+	}
+}
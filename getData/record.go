@@ -0,0 +1,170 @@
+package getData
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"example.com/tool/models"
+)
+
+// recordingVersion is the version written to the header of new recordings.
+const recordingVersion = 1
+
+// recorder receives every raw response fetched while set, see SetRecorder.
+var recorder *Recorder
+
+// SetRecorder records every raw response fetched from now on with r, nil stops recording.
+// It must not be called while fetching.
+func SetRecorder(r *Recorder) {
+	recorder = r
+}
+
+// Recorder writes raw device responses to a gzip compressed JSON lines file:
+// a models.RecordingHeader followed by one models.RawRecord per response.
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	gzip    *gzip.Writer
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+// NewRecorder starts a recording named after the current time in dir. profiles are stored in
+// the header, so that the recording can later be decoded the way it was at the time.
+func NewRecorder(dir string, profiles map[string]models.ConfigPoint) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create record directory: %v", err)
+	}
+
+	now := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("raw-%s.jsonl.gz", now.UTC().Format("20060102T150405Z")))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %v", err)
+	}
+
+	r := &Recorder{file: file}
+	r.gzip = gzip.NewWriter(file)
+	r.buf = bufio.NewWriter(r.gzip)
+	r.encoder = json.NewEncoder(r.buf)
+
+	header := models.RecordingHeader{Version: recordingVersion, StartedAt: now.UnixMilli(), Profiles: profiles}
+	if err := r.encoder.Encode(header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write recording header: %v", err)
+	}
+	log.Printf("recording raw responses to %s", path)
+	return r, nil
+}
+
+// Record appends a raw response of target.
+func (r *Recorder) Record(target models.Target, data map[string]float64) error {
+	profile := target.Profile
+	if profile == "" {
+		profile = "default"
+	}
+	record := models.RawRecord{
+		Timestamp: time.Now().UnixMilli(),
+		URL:       target.URL,
+		Equipment: target.Name,
+		Profile:   profile,
+		Data:      data,
+	}
+	if target.Protocol == "modbus" {
+		record.URL = "modbus://" + target.Address
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.encoder.Encode(record); err != nil {
+		return fmt.Errorf("failed to record response of %s: %v", target.Name, err)
+	}
+	return nil
+}
+
+// Close flushes and closes the recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.buf.Flush(); err != nil {
+		r.file.Close()
+		return fmt.Errorf("failed to write recording: %v", err)
+	}
+	if err := r.gzip.Close(); err != nil {
+		r.file.Close()
+		return fmt.Errorf("failed to write recording: %v", err)
+	}
+	return r.file.Close()
+}
+
+// record hands a raw response to the recorder, if one is set.
+func record(target models.Target, data map[string]float64) {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Record(target, data); err != nil {
+		log.Print(err)
+	}
+}
+
+// Recording reads a recording written by a Recorder.
+type Recording struct {
+	Header models.RecordingHeader
+
+	file    *os.File
+	gzip    *gzip.Reader
+	decoder *json.Decoder
+}
+
+// OpenRecording opens the recording at path and reads its header.
+func OpenRecording(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %v", err)
+	}
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open recording %s: %v", path, err)
+	}
+
+	r := &Recording{file: file, gzip: zr, decoder: json.NewDecoder(bufio.NewReader(zr))}
+	if err := r.decoder.Decode(&r.Header); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to read recording header of %s: %v", path, err)
+	}
+	if r.Header.Version != recordingVersion {
+		r.Close()
+		return nil, fmt.Errorf("unsupported recording version %d in %s", r.Header.Version, path)
+	}
+	return r, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the recording.
+// A recording cut off by a crash ends with io.ErrUnexpectedEOF.
+func (r *Recording) Next() (models.RawRecord, error) {
+	var record models.RawRecord
+	err := r.decoder.Decode(&record)
+	if err == io.EOF {
+		return record, io.EOF
+	}
+	if err != nil {
+		return record, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return record, nil
+}
+
+// Close closes the recording.
+func (r *Recording) Close() error {
+	r.gzip.Close()
+	return r.file.Close()
+}
@@ -0,0 +1,143 @@
+package health
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"example.com/tool/models"
+)
+
+// component holds the last known activity of a single subsystem.
+type component struct {
+	lastBeat    time.Time
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	failures    int
+}
+
+// Monitor keeps track of heartbeats, successes and failures of the collector subsystems.
+// All methods are safe to call on a nil *Monitor, which makes monitoring optional.
+type Monitor struct {
+	mu         sync.RWMutex
+	components map[string]*component
+}
+
+// NewMonitor creates an empty Monitor.
+func NewMonitor() *Monitor {
+	return &Monitor{components: make(map[string]*component)}
+}
+
+// get returns the component with the given name, creating it if needed. The caller must hold mu.
+func (m *Monitor) get(name string) *component {
+	c, ok := m.components[name]
+	if !ok {
+		c = &component{}
+		m.components[name] = c
+	}
+	return c
+}
+
+// Beat records a heartbeat of a long-running goroutine such as a scheduler or a saver.
+func (m *Monitor) Beat(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.get(name).lastBeat = time.Now()
+	m.mu.Unlock()
+}
+
+// Success records a successful operation of the given subsystem.
+func (m *Monitor) Success(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	c := m.get(name)
+	c.lastSuccess = time.Now()
+	c.failures = 0
+	m.mu.Unlock()
+}
+
+// Failure records a failed operation of the given subsystem.
+func (m *Monitor) Failure(name string, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	c := m.get(name)
+	c.lastFailure = time.Now()
+	c.failures++
+	if err != nil {
+		c.lastError = err.Error()
+	}
+	m.mu.Unlock()
+}
+
+// Snapshot returns the status of every known subsystem.
+// A subsystem that sends heartbeats is healthy when its last beat is younger than stall;
+// any other subsystem is healthy when its last success is newer than its last failure.
+func (m *Monitor) Snapshot(stall time.Duration) map[string]models.ComponentStatus {
+	statuses := make(map[string]models.ComponentStatus)
+	if m == nil {
+		return statuses
+	}
+
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for name, c := range m.components {
+		status := models.ComponentStatus{
+			LastBeat:    timePtr(c.lastBeat),
+			LastSuccess: timePtr(c.lastSuccess),
+			LastFailure: timePtr(c.lastFailure),
+			LastError:   c.lastError,
+			Failures:    c.failures,
+		}
+		if !c.lastBeat.IsZero() {
+			status.Healthy = now.Sub(c.lastBeat) <= stall
+		} else {
+			status.Healthy = !c.lastSuccess.IsZero() && !c.lastSuccess.Before(c.lastFailure)
+		}
+		statuses[name] = status
+	}
+
+	return statuses
+}
+
+// timePtr returns nil for the zero time so that it is omitted from JSON output.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// ProbeTCP periodically dials addr and records the outcome under name until ctx is done.
+func ProbeTCP(ctx context.Context, m *Monitor, name, addr string, interval time.Duration) {
+	dialer := net.Dialer{Timeout: interval}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			if ctx.Err() == nil {
+				m.Failure(name, err)
+			}
+		} else {
+			conn.Close()
+			m.Success(name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package init
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"example.com/tool/models"
+	"example.com/tool/retry"
 )
 
 // ReadConfig reads the configuration from the config file.
@@ -25,9 +27,152 @@ func ReadConfig(filePath string) (*models.Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
 
+	applyDefaults(&config)
+
 	return &config, nil
 }
 
+// applyDefaults fills in optional settings that are missing from the config file.
+func applyDefaults(config *models.Config) {
+	if config.HttpListen == "" {
+		config.HttpListen = ":8080"
+	}
+	if config.QueueHighWater <= 0 {
+		config.QueueHighWater = config.MaxQueue * 8 / 10
+	}
+	if config.QueueLowWater <= 0 {
+		config.QueueLowWater = config.QueueHighWater * 3 / 4
+	}
+	if config.Backpressure == "" {
+		config.Backpressure = "slow"
+	}
+	if config.SpillDir == "" {
+		config.SpillDir = "./spill"
+	}
+	if config.StallSeconds <= 0 {
+		config.StallSeconds = 30
+	}
+	if config.StreamBuffer <= 0 {
+		config.StreamBuffer = 256
+	}
+	if config.MaxSweepsInFlight <= 0 {
+		config.MaxSweepsInFlight = 2
+	}
+	client := &config.FetchClient
+	if client.MaxIdleConnsPerHost <= 0 {
+		client.MaxIdleConnsPerHost = config.SemaphoreForGet
+	}
+	if client.IdleConnTimeoutMs <= 0 {
+		client.IdleConnTimeoutMs = 90000
+	}
+	if client.KeepAliveMs <= 0 {
+		client.KeepAliveMs = 30000
+	}
+	if client.DialTimeoutMs <= 0 {
+		client.DialTimeoutMs = 2000
+	}
+	if client.TLSHandshakeTimeoutMs <= 0 {
+		client.TLSHandshakeTimeoutMs = 2000
+	}
+	if client.ResponseHeaderTimeoutMs <= 0 {
+		client.ResponseHeaderTimeoutMs = 3000
+	}
+	if client.DeviceTimeoutMs <= 0 {
+		client.DeviceTimeoutMs = 5000
+	}
+	if config.DeviceBreaker.FailureThreshold <= 0 {
+		config.DeviceBreaker.FailureThreshold = 3
+	}
+	if config.DeviceBreaker.ProbeIntervalMs <= 0 {
+		config.DeviceBreaker.ProbeIntervalMs = 60000
+	}
+	if config.SinkBreaker.FailureThreshold <= 0 {
+		config.SinkBreaker.FailureThreshold = 3
+	}
+	if config.SinkBreaker.ProbeIntervalMs <= 0 {
+		config.SinkBreaker.ProbeIntervalMs = 10000
+	}
+	status := &config.Connectivity
+	if status.DegradedAfterFailures <= 0 {
+		status.DegradedAfterFailures = 1
+	}
+	if status.OfflineAfterFailures <= 0 {
+		status.OfflineAfterFailures = 3
+	}
+	if status.StaleSeconds <= 0 {
+		status.StaleSeconds = 60
+	}
+	if status.OfflineSeconds <= 0 {
+		status.OfflineSeconds = 300
+	}
+	if config.SemaphoreForSave <= 0 {
+		config.SemaphoreForSave = 2
+	}
+	if config.FlushIntervalMs <= 0 {
+		config.FlushIntervalMs = 5000
+	}
+	if config.MaxBatchBytes <= 0 {
+		config.MaxBatchBytes = 4 << 20
+	}
+	if sink := config.InfluxSink; sink != nil {
+		if sink.BatchSize <= 0 {
+			sink.BatchSize = config.BatchSize
+		}
+		if sink.Buffer <= 0 {
+			sink.Buffer = config.MaxQueue
+		}
+		if sink.MaxAttempts <= 0 {
+			sink.MaxAttempts = 3
+		}
+		if sink.BackoffMs <= 0 {
+			sink.BackoffMs = 1000
+		}
+	}
+	if sink := config.RemoteWriteSink; sink != nil {
+		if sink.Shards <= 0 {
+			sink.Shards = 4
+		}
+		if sink.MaxSamplesPerSend <= 0 {
+			sink.MaxSamplesPerSend = 500
+		}
+		if sink.BatchSize <= 0 {
+			sink.BatchSize = config.BatchSize
+		}
+		if sink.Buffer <= 0 {
+			sink.Buffer = config.MaxQueue
+		}
+		if sink.MaxAttempts <= 0 {
+			sink.MaxAttempts = 3
+		}
+		if sink.BackoffMs <= 0 {
+			sink.BackoffMs = 1000
+		}
+	}
+	for i := range config.FileSinks {
+		sink := &config.FileSinks[i]
+		if sink.Dir == "" {
+			sink.Dir = "./export"
+		}
+		if sink.RotateSeconds <= 0 {
+			sink.RotateSeconds = 3600
+		}
+		if sink.BatchSize <= 0 {
+			sink.BatchSize = config.BatchSize
+		}
+		if sink.Buffer <= 0 {
+			sink.Buffer = config.MaxQueue
+		}
+	}
+	if sink := config.MQTTSink; sink != nil {
+		if sink.BatchSize <= 0 {
+			sink.BatchSize = 1
+		}
+		if sink.Buffer <= 0 {
+			sink.Buffer = config.MaxQueue
+		}
+	}
+}
+
 // readPonit reads the configuration from the config file.
 func ReadPonit(filePath string) (*models.ConfigPoint, error) {
 	file, err := os.Open(filePath)
@@ -45,17 +190,40 @@ func ReadPonit(filePath string) (*models.ConfigPoint, error) {
 	return &config, nil
 }
 
-// makeAPIRequest makes an API request to the given URL and returns the response body as a string.
-func MakeAPIRequest(url string) (string, error) {
-	resp, err := http.Get(url)
+// ReadAlarmRules reads the alarm definitions from the alarm rules file.
+func ReadAlarmRules(filePath string) (*models.AlarmConfig, error) {
+	file, err := os.Open(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to make API request: %v", err)
+		return nil, fmt.Errorf("failed to open alarm rules file: %v", err)
 	}
-	defer resp.Body.Close()
+	defer file.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status: %v", resp.Status)
+	var config models.AlarmConfig
+	decoder := json.NewDecoder(file)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse alarm rules file: %v", err)
+	}
+	// A file with only webhooks has no rules, rules from the store are added to the map
+	if config.Rules == nil {
+		config.Rules = make(map[string][]models.AlarmRule)
+	}
+
+	return &config, nil
+}
+
+// apiClient sends the requests of MakeAPIRequest, every attempt bounded by its timeout.
+var apiClient = &http.Client{Timeout: 30 * time.Second}
+
+// makeAPIRequest makes an API request to the given URL and returns the response body as a string.
+// Failed requests are retried with policy, e.g. the retry setting of the config.
+func MakeAPIRequest(url string, policy models.RetryPolicy) (string, error) {
+	resp, err := retry.New("api", policy).Do(context.Background(), apiClient, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", url, nil)
+	})
+	if err != nil {
+		return "", fmt.Errorf("API request failed: %w", err)
 	}
+	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -0,0 +1,60 @@
+package init
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"example.com/tool/models"
+)
+
+func TestReadAlarmRulesWithoutRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms.json")
+	content := `{"notify": {"webhooks": [{"name": "facility", "url": "http://127.0.0.1:9000/alarms"}]}}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadAlarmRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Notify.Webhooks) != 1 {
+		t.Fatalf("read %d webhooks, want 1", len(config.Notify.Webhooks))
+	}
+	// Rules from the store are added to the map, it must be writable
+	config.Rules["kw"] = append(config.Rules["kw"], models.AlarmRule{Name: "overload"})
+}
+
+func TestMakeAPIRequestRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	body, err := MakeAPIRequest(server.URL, models.RetryPolicy{MaxAttempts: 2, InitialBackoffMs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "ok" || requests.Load() != 2 {
+		t.Errorf("answered %q after %d requests", body, requests.Load())
+	}
+
+	// A single configured attempt is not retried
+	requests.Store(0)
+	if _, err := MakeAPIRequest(server.URL, models.RetryPolicy{MaxAttempts: 1}); err == nil {
+		t.Error("failed request succeeded")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("sent %d requests, want 1", requests.Load())
+	}
+}
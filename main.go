@@ -4,21 +4,52 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
+	"example.com/tool/alarm"
+	"example.com/tool/breaker"
+	"example.com/tool/cache"
+	"example.com/tool/connectivity"
 	"example.com/tool/getData"
+	"example.com/tool/health"
 	initSetting "example.com/tool/init"
 	"example.com/tool/models"
+	"example.com/tool/notify"
+	"example.com/tool/pipeline"
+	"example.com/tool/registry"
 	"example.com/tool/saveData"
+	"example.com/tool/server"
+	"example.com/tool/store"
+	"example.com/tool/stream"
 	workerpool "github.com/gammazero/workerpool"
 )
 
 func main() {
+	// Debug and maintenance commands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "plan":
+			runPlan(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "reprocess":
+			runReprocess(os.Args[2:])
+			return
+		default:
+			log.Fatalf("unknown command: %s", os.Args[1])
+		}
+	}
+
 	// 1-1. Read the config
 	config, err := initSetting.ReadConfig("./config.json")
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
+	getData.Configure(*config)
+	saveData.Configure(*config)
 
 	// 1-2. Read the points
 	points, err := initSetting.ReadPonit("./points.json")
@@ -26,71 +57,318 @@ func main() {
 		log.Fatalf(err.Error())
 	}
 
+	// 1-3. Open the metadata store, seeding it with the points and the old index ranges on first use
+	var metaStore *store.Store
+	if config.StoreDialect != "" {
+		metaStore, err = store.Open(config.StoreDialect, config.StoreDSN)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+
+		profiles, err := metaStore.Profiles()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		if _, ok := profiles["default"]; !ok {
+			if err := metaStore.SaveProfile("default", *points); err != nil {
+				log.Fatalf(err.Error())
+			}
+		}
+
+		count, err := metaStore.CountDevices()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		if count == 0 {
+			for group := 1; group <= 5; group++ {
+				if err := metaStore.SeedRange(fmt.Sprint(group), 3000+group, (group-1)*1000+1, group*1000, "default"); err != nil {
+					log.Fatalf(err.Error())
+				}
+			}
+		}
+	}
+
+	// Point profiles by name, for decoding pushed and recorded responses
+	profiles := map[string]models.ConfigPoint{"default": *points}
+	if metaStore != nil {
+		stored, err := metaStore.Profiles()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		for name, profile := range stored {
+			profiles[name] = profile
+		}
+	}
+
+	// 1-4. Read the alarm rules and webhooks
+	var alarms *alarm.Engine
+	var notifier *notify.Notifier
+	if config.AlarmFile != "" || metaStore != nil {
+		rules := &models.AlarmConfig{Rules: make(map[string][]models.AlarmRule)}
+		if config.AlarmFile != "" {
+			rules, err = initSetting.ReadAlarmRules(config.AlarmFile)
+			if err != nil {
+				log.Fatalf(err.Error())
+			}
+		}
+		if metaStore != nil {
+			storedRules, err := metaStore.AlarmRules()
+			if err != nil {
+				log.Fatalf(err.Error())
+			}
+			for measurement, list := range storedRules {
+				rules.Rules[measurement] = append(rules.Rules[measurement], list...)
+			}
+		}
+
+		alarms, err = alarm.NewEngine(*rules, 1000)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		notifier, err = notify.New(rules.Notify)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+	}
+
+	monitor := health.NewMonitor()
+	monitor.Success("config")
+
 	// 2. Create a context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.StartMinute)*time.Minute)
 	defer cancel()
 
 	// 3. Make the initial API request
 	initialAPIURL := fmt.Sprintf("http://%s:3001/setInit/Daisy", config.GetDataApiHost)
-	initialResponse, err := initSetting.MakeAPIRequest(initialAPIURL)
+	initialResponse, err := initSetting.MakeAPIRequest(initialAPIURL, config.Retry)
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
 	fmt.Printf("Initial API response: %s\n", initialResponse)
 
-	// 4. Create worker pools
-	wpGet1 := workerpool.New(config.SemaphoreForGet)
-	wpGet2 := workerpool.New(config.SemaphoreForGet)
-	wpGet3 := workerpool.New(config.SemaphoreForGet)
-	wpGet4 := workerpool.New(config.SemaphoreForGet)
-	wpGet5 := workerpool.New(config.SemaphoreForGet)
-	// wpSave := workerpool.New(config.SemaphoreForSave)
+	// 3-1. Record the raw responses for replay and reprocessing
+	var rawRecorder *getData.Recorder
+	if config.RecordDir != "" {
+		rawRecorder, err = getData.NewRecorder(config.RecordDir, profiles)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		getData.SetRecorder(rawRecorder)
+	}
+
+	// 4. Create worker pools, one per device group
+	var pools []*workerpool.WorkerPool
 
 	// 5. Create queue
 	// var apiRequestCount int32
 	// var apiSaveCount int32
+	// decodedQueue only buffers between the fetchers and the dispatcher, messageQueue holds the backlog for the savers
+	decodedQueue := make(chan models.SentData, 1000)
 	messageQueue := make(chan models.SentData, config.MaxQueue)
 
+	// 5-1. Load the device registry
+	var devices *registry.Registry
+	if metaStore != nil {
+		devices, err = registry.New(metaStore, config.GetDataApiHost)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+	} else if config.DeviceSource == "store" {
+		log.Fatalf("deviceSource \"store\" requires storeDialect to be set")
+	}
+
+	// 5-2. Keep the latest sample of every device in memory, stream samples to live clients and evaluate alarms
+	latest := cache.NewLatest()
+	hub := stream.NewHub(config.StreamBuffer)
+	observers := []pipeline.Observer{latest, hub}
+	// The notifier outlives ctx, so that alarms raised while the pipeline drains are still delivered
+	notifyCtx, stopNotify := context.WithCancel(context.Background())
+	notifyDone := make(chan struct{})
+	if alarms != nil {
+		observers = append(observers, alarms)
+		go func() {
+			notifier.Run(notifyCtx)
+			close(notifyDone)
+		}()
+		go func() {
+			for event := range alarms.Events() {
+				log.Printf("alarm %s: %s on %s (%s = %.2f, threshold %.2f)", event.State, event.Rule, event.Device, event.Measurement, event.Value, event.Threshold)
+				notifier.Notify(event)
+				if metaStore != nil {
+					if err := metaStore.RecordAlarmEvent(event); err != nil {
+						log.Printf("failed to record alarm event: %v", err)
+					}
+				}
+			}
+		}()
+	}
+	// 5-2-1. Track the connectivity of every device from its polls and samples
+	var registered func() []models.Device
+	if devices != nil {
+		registered = devices.Devices
+	}
+	tracker := connectivity.NewTracker(config.Connectivity, registered, 1000)
+	getData.SetConnectivity(tracker)
+	observers = append(observers, tracker)
+	go tracker.Run(ctx)
+	// 5-2-2. Publish decoded samples to MQTT, InfluxDB, Prometheus and files next to the database
+	// Breakers stop hammering devices and sinks that keep failing, see the admin API for their state
+	deviceBreakers := breaker.NewSet("device", config.DeviceBreaker)
+	sinkBreakers := breaker.NewSet("sink", config.SinkBreaker)
+	getData.SetBreakers(deviceBreakers)
+	if devices != nil {
+		devices.OnDelete(deviceBreakers.Remove)
+	}
+	sinks, waitSinks := startSinks(ctx, config, *points, profiles, deviceGroup(config.DeviceSource, devices), monitor, sinkBreakers)
+	observers = append(observers, sinks...)
+	// 5-2-3. Apply the backpressure policy once the savers fall behind, the fetch schedulers pause with "slow"
+	backpressure, err := pipeline.NewBackpressure(messageQueue, config.Backpressure, config.QueueHighWater, config.QueueLowWater, config.SpillDir)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	// 5-2-4. Divert batches to the spill file while the database breaker is open
+	dbHost := fmt.Sprintf("%s:18080", config.SentDataApiHost)
+	dbAPIURL := fmt.Sprintf("http://%s/rest/v2/insertRecords", dbHost)
+	dbSaver := dbSink(dbAPIURL, backpressure, sinkBreakers)
+	go backpressure.Run(ctx)
+	getData.SetBackpressure(backpressure)
+	go pipeline.Dispatch(decodedQueue, backpressure, observers...)
+	// 5-2-5. Write connectivity changes as "<device>.status" series to the database and the other sinks,
+	// past the observers so they do not show up as devices of their own
+	statusDone := make(chan struct{})
+	go func() {
+		defer close(statusDone)
+		for event := range tracker.Events() {
+			if event.Previous != "" {
+				message := fmt.Sprintf("device %s is %s, was %s", event.Name, event.State, event.Previous)
+				if event.Reason != "" {
+					message += ": " + event.Reason
+				}
+				log.Print(message)
+			}
+			sample := connectivity.Sample(event)
+			// Never wait for the queue here, at shutdown the savers may be gone while Close waits for this loop
+			if !backpressure.TryPut(sample) {
+				log.Printf("message queue full, status of %s not saved to the database", event.Name)
+			}
+			for _, sink := range sinks {
+				sink.Observe(sample)
+			}
+		}
+	}()
+
+	// 5-3. Serve health, readiness, metrics, latest-value, live-stream and admin endpoints
+	srv := &server.Server{Config: *config, Monitor: monitor, Queue: messageQueue, Latest: latest, Hub: hub, Alarms: alarms, Registry: devices,
+		Breakers: []*breaker.Set{deviceBreakers, sinkBreakers}, Connectivity: tracker}
+	go srv.Run(ctx)
+	go health.ProbeTCP(ctx, monitor, "sink", dbHost, 10*time.Second)
+
 	// 6. Prepare and fetch data
 	// go getData.PrepareAndFetchData(ctx, *config, *points, 1, 1000, 1, 1, messageQueue, wpGet1, &apiRequestCount)
 	// go getData.PrepareAndFetchData(ctx, *config, *points, 1001, 2000, 2, 2, messageQueue, wpGet2, &apiRequestCount)
 	// go getData.PrepareAndFetchData(ctx, *config, *points, 2001, 3000, 3, 3, messageQueue, wpGet3, &apiRequestCount)
 	// go getData.PrepareAndFetchData(ctx, *config, *points, 3001, 4000, 4, 4, messageQueue, wpGet4, &apiRequestCount)
 	// go getData.PrepareAndFetchData(ctx, *config, *points, 4001, 5000, 5, 5, messageQueue, wpGet5, &apiRequestCount)
-	go getData.PrepareAndFetchDataNoCount(ctx, *config, *points, 1, 1000, 1, 1, messageQueue, wpGet1)
-	go getData.PrepareAndFetchDataNoCount(ctx, *config, *points, 1001, 2000, 2, 2, messageQueue, wpGet2)
-	go getData.PrepareAndFetchDataNoCount(ctx, *config, *points, 2001, 3000, 3, 3, messageQueue, wpGet3)
-	go getData.PrepareAndFetchDataNoCount(ctx, *config, *points, 3001, 4000, 4, 4, messageQueue, wpGet4)
-	go getData.PrepareAndFetchDataNoCount(ctx, *config, *points, 4001, 5000, 5, 5, messageQueue, wpGet5)
-
-	// 7. Submit task to worker pool for saving data
-	// go saveData.AggregateAndSaveData(ctx, messageQueue, fmt.Sprintf("http://%s:18080/rest/v2/insertRecords", config.SentDataApiHost), config.BatchSize, wpSave, &apiSaveCount)
-	// go saveData.AggregateAndSaveData(ctx, messageQueue, fmt.Sprintf("http://%s:18080/rest/v2/insertRecords", config.SentDataApiHost), config.BatchSize, wpSave, &apiSaveCount)
-	// go saveData.AggregateAndSaveDataByGoRoutine(ctx, messageQueue, fmt.Sprintf("http://%s:18080/rest/v2/insertRecords", config.SentDataApiHost), config.BatchSize, &apiSaveCount)
-	// go saveData.AggregateAndSaveDataByGoRoutine(ctx, messageQueue, fmt.Sprintf("http://%s:18080/rest/v2/insertRecords", config.SentDataApiHost), config.BatchSize, &apiSaveCount)
-	go saveData.AggregateAndSaveDataByGoRoutineNoCount(ctx, messageQueue, fmt.Sprintf("http://%s:18080/rest/v2/insertRecords", config.SentDataApiHost), config.BatchSize)
-	go saveData.AggregateAndSaveDataByGoRoutineNoCount(ctx, messageQueue, fmt.Sprintf("http://%s:18080/rest/v2/insertRecords", config.SentDataApiHost), config.BatchSize)
+	deviceCount := 5000
+	var fetchDone chan struct{}
+	if config.DeviceSource == "store" {
+		// Poll the devices of the registry instead of index ranges, changes made through the admin API apply on the next sweep
+		fetchDone = make(chan struct{})
+		go func() {
+			getData.FetchGroups(ctx, devices, config.SemaphoreForGet, decodedQueue, monitor)
+			close(fetchDone)
+		}()
+		deviceCount = len(devices.Devices())
+	} else {
+		wpGet1 := workerpool.New(config.SemaphoreForGet)
+		wpGet2 := workerpool.New(config.SemaphoreForGet)
+		wpGet3 := workerpool.New(config.SemaphoreForGet)
+		wpGet4 := workerpool.New(config.SemaphoreForGet)
+		wpGet5 := workerpool.New(config.SemaphoreForGet)
+		pools = append(pools, wpGet1, wpGet2, wpGet3, wpGet4, wpGet5)
+
+		go getData.PrepareAndFetchDataNoCount(ctx, *config, *points, 1, 1000, 1, 1, decodedQueue, wpGet1, monitor)
+		go getData.PrepareAndFetchDataNoCount(ctx, *config, *points, 1001, 2000, 2, 2, decodedQueue, wpGet2, monitor)
+		go getData.PrepareAndFetchDataNoCount(ctx, *config, *points, 2001, 3000, 3, 3, decodedQueue, wpGet3, monitor)
+		go getData.PrepareAndFetchDataNoCount(ctx, *config, *points, 3001, 4000, 4, 4, decodedQueue, wpGet4, monitor)
+		go getData.PrepareAndFetchDataNoCount(ctx, *config, *points, 4001, 5000, 5, 5, decodedQueue, wpGet5, monitor)
+	}
+
+	// 6-1. Subscribe to devices that push their readings over MQTT
+	var mqttDone chan struct{}
+	if config.MQTTSource != nil {
+		if err := getData.CheckMQTTSource(*config.MQTTSource, profiles); err != nil {
+			log.Fatalf("invalid MQTT source: %v", err)
+		}
+		mqttDone = make(chan struct{})
+		go func() {
+			defer close(mqttDone)
+			if err := getData.SubscribeMQTT(ctx, *config.MQTTSource, profiles, decodedQueue, monitor); err != nil {
+				log.Printf("MQTT source stopped: %v", err)
+			}
+		}()
+	}
+
+	var run *models.RunHistory
+	if metaStore != nil {
+		if run, err = metaStore.StartRun(deviceCount); err != nil {
+			log.Printf("failed to record run start: %v", err)
+		}
+	}
+
+	// 7. Save data with semaphoreForSave savers, each owning its batch and the devices routed to it
+	savePolicy := batchPolicy(config, config.BatchSize)
+	savePolicy.MaxBytes = config.MaxBatchBytes
+	saveDone := make(chan struct{})
+	go func() {
+		defer close(saveDone)
+		saveData.SaveByDevice(ctx, messageQueue, dbSaver, config.SemaphoreForSave, savePolicy, monitor)
+	}()
 
 	// Wait for the context to be done
 	<-ctx.Done()
 
 	// 8. Make the final API request before stopping
 	finalAPIURL := fmt.Sprintf("http://%s:3001/setFinal/Daisy", config.GetDataApiHost)
-	finalResponse, err := initSetting.MakeAPIRequest(finalAPIURL)
+	finalResponse, err := initSetting.MakeAPIRequest(finalAPIURL, config.Retry)
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
 
 	// Wait for all tasks to complete
-	wpGet1.StopWait()
-	wpGet2.StopWait()
-	wpGet3.StopWait()
-	wpGet4.StopWait()
-	wpGet5.StopWait()
-	// wpSave.StopWait()
-
-	// Close the messageQueue after all tasks are done
-	close(messageQueue)
+	for _, wp := range pools {
+		wp.StopWait()
+	}
+	if fetchDone != nil {
+		<-fetchDone
+	}
+	if mqttDone != nil {
+		<-mqttDone
+	}
+	if rawRecorder != nil {
+		if err := rawRecorder.Close(); err != nil {
+			log.Printf("failed to close the recording: %v", err)
+		}
+	}
+
+	if run != nil {
+		if err := metaStore.FinishRun(run, "finished"); err != nil {
+			log.Printf("failed to record run end: %v", err)
+		}
+	}
+
+	// Stop writing connectivity changes before the dispatcher closes the queues
+	tracker.Close()
+	<-statusDone
+
+	// Close the decodedQueue after all tasks are done, the dispatcher then closes the messageQueue
+	close(decodedQueue)
+	<-saveDone
+	waitSinks()
+	stopNotify()
+	if alarms != nil {
+		<-notifyDone
+	}
 
 	// totalSeconds := config.StartMinute * 60
 	// averageRequestsPerSecond := float64(apiRequestCount) / float64(totalSeconds)
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds the metrics of the collector, served on /metrics.
+var Registry = prometheus.NewRegistry()
+
+// BatchFlushes counts the batches saved by the aggregators, by sink and flush reason:
+// "size", "age", "bytes" or "shutdown".
+var BatchFlushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "collector_batch_flushes_total",
+	Help: "Batches saved by the aggregators, by sink and flush reason.",
+}, []string{"sink", "reason"})
+
+// BatchSamples observes the number of samples per saved batch.
+var BatchSamples = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "collector_batch_samples",
+	Help:    "Samples per saved batch.",
+	Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+}, []string{"sink"})
+
+// BatchBytes observes the estimated JSON size of every saved batch.
+var BatchBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "collector_batch_bytes",
+	Help:    "Estimated JSON size of saved batches in bytes.",
+	Buckets: prometheus.ExponentialBuckets(1024, 4, 8),
+}, []string{"sink"})
+
+// QueueLength is the number of samples in the message queue of the savers.
+var QueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "collector_queue_length",
+	Help: "Samples in the message queue of the savers.",
+})
+
+// QueueHighWater and QueueLowWater are the water marks of the message queue.
+var QueueHighWater = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "collector_queue_high_water",
+	Help: "Queue length from which the backpressure policy applies.",
+})
+
+var QueueLowWater = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "collector_queue_low_water",
+	Help: "Queue length at which the backpressure policy is lifted again.",
+})
+
+// QueueAboveHighWater is 1 from reaching the high-water mark until the queue is back at its low-water mark.
+var QueueAboveHighWater = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "collector_queue_above_high_water",
+	Help: "1 while the backpressure policy applies.",
+})
+
+// QueueSpilled is the number of samples spilled to disk and not yet queued again.
+var QueueSpilled = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "collector_queue_spilled",
+	Help: "Samples spilled to disk waiting to be queued again.",
+})
+
+// QueueDropped counts the samples discarded by the backpressure policy.
+var QueueDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "collector_queue_dropped_total",
+	Help: "Samples discarded by the backpressure policy.",
+}, []string{"policy"})
+
+// SweepDuration observes how long a sweep over the targets of a device group takes.
+var SweepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "collector_sweep_duration_seconds",
+	Help:    "Duration of a sweep over the targets of a device group.",
+	Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+}, []string{"group"})
+
+// SweepsInFlight is the number of sweeps of a device group submitted and not yet finished.
+var SweepsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "collector_sweeps_in_flight",
+	Help: "Sweeps of a device group submitted and not yet finished.",
+}, []string{"group"})
+
+// FetchWaitingTasks is the length of the waiting queue of the worker pool of a device group.
+var FetchWaitingTasks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "collector_fetch_waiting_tasks",
+	Help: "Tasks waiting for a worker in the pool of a device group.",
+}, []string{"group"})
+
+// FetchConnections counts the connections device requests got, by whether they were reused from the pool.
+var FetchConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "collector_fetch_connections_total",
+	Help: "Connections used by device requests, by whether they were reused.",
+}, []string{"reused"})
+
+// HTTPRetries counts the outbound requests sent again after a failed attempt, by client.
+var HTTPRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "collector_http_retries_total",
+	Help: "Outbound HTTP requests retried after a failed attempt, by client.",
+}, []string{"client"})
+
+// BreakerState is the state of a circuit breaker: 0 closed, 1 half-open, 2 open.
+var BreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "collector_breaker_state",
+	Help: "State of a circuit breaker: 0 closed, 1 half-open, 2 open.",
+}, []string{"kind", "name"})
+
+// BreakerTransitions counts the state changes of the circuit breakers, by kind and new state.
+var BreakerTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "collector_breaker_transitions_total",
+	Help: "State changes of the circuit breakers, by kind and new state.",
+}, []string{"kind", "state"})
+
+// DevicesByStatus is the number of devices in each connectivity state.
+var DevicesByStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "collector_devices",
+	Help: "Devices by connectivity state.",
+}, []string{"state"})
+
+// StatusTransitions counts the connectivity state changes of the devices, by new state.
+var StatusTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "collector_device_status_transitions_total",
+	Help: "Connectivity state changes of the devices, by new state.",
+}, []string{"state"})
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		BatchFlushes,
+		BatchSamples,
+		BatchBytes,
+		QueueLength,
+		QueueHighWater,
+		QueueLowWater,
+		QueueAboveHighWater,
+		QueueSpilled,
+		QueueDropped,
+		SweepDuration,
+		SweepsInFlight,
+		FetchWaitingTasks,
+		FetchConnections,
+		HTTPRetries,
+		BreakerState,
+		BreakerTransitions,
+		DevicesByStatus,
+		StatusTransitions,
+	)
+}
+
+// Handler serves the metrics of Registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
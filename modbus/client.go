@@ -0,0 +1,180 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Modbus function codes
+const (
+	ReadCoils            byte = 0x01
+	ReadDiscreteInputs   byte = 0x02
+	ReadHoldingRegisters byte = 0x03
+	ReadInputRegisters   byte = 0x04
+)
+
+// Protocol limits of a single read request
+const (
+	MaxRegisters = 125
+	MaxBits      = 2000
+)
+
+// Client is a Modbus TCP client that keeps one connection open to a single server.
+// Requests are serialized, as most meters and gateways answer one request at a time.
+type Client struct {
+	address string
+	timeout time.Duration
+
+	mu            sync.Mutex
+	conn          net.Conn
+	transactionID uint16
+}
+
+// NewClient creates a client for the Modbus TCP server at address (host:port). It connects lazily.
+// timeout bounds connecting and every request, 0 leaves them to the context of the caller.
+func NewClient(address string, timeout time.Duration) *Client {
+	return &Client{address: address, timeout: timeout}
+}
+
+// Close closes the connection, the next request reconnects.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked()
+}
+
+func (c *Client) closeLocked() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// ReadRegisters reads count holding (FC 03) or input (FC 04) registers starting at start.
+func (c *Client) ReadRegisters(ctx context.Context, unitID, function byte, start, count uint16) ([]uint16, error) {
+	if count == 0 || count > MaxRegisters {
+		return nil, fmt.Errorf("invalid register count: %d", count)
+	}
+
+	data, err := c.request(ctx, unitID, function, start, count)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(count)*2 {
+		return nil, fmt.Errorf("modbus %s: expected %d register bytes, got %d", c.address, count*2, len(data))
+	}
+
+	registers := make([]uint16, count)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+	return registers, nil
+}
+
+// ReadBits reads count coils (FC 01) or discrete inputs (FC 02) starting at start.
+func (c *Client) ReadBits(ctx context.Context, unitID, function byte, start, count uint16) ([]bool, error) {
+	if count == 0 || count > MaxBits {
+		return nil, fmt.Errorf("invalid bit count: %d", count)
+	}
+
+	data, err := c.request(ctx, unitID, function, start, count)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != (int(count)+7)/8 {
+		return nil, fmt.Errorf("modbus %s: expected %d bit bytes, got %d", c.address, (count+7)/8, len(data))
+	}
+
+	bits := make([]bool, count)
+	for i := range bits {
+		bits[i] = data[i/8]&(1<<(i%8)) != 0
+	}
+	return bits, nil
+}
+
+// request sends a read request and returns the data bytes of the response.
+func (c *Client) request(ctx context.Context, unitID, function byte, start, count uint16) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		dialer := net.Dialer{Timeout: c.timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", c.address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to modbus %s: %v", c.address, err)
+		}
+		c.conn = conn
+	}
+
+	var deadline time.Time // the zero time clears the deadline of the connection
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	c.conn.SetDeadline(deadline)
+
+	c.transactionID++
+	frame := make([]byte, 12)
+	binary.BigEndian.PutUint16(frame[0:], c.transactionID)
+	binary.BigEndian.PutUint16(frame[2:], 0) // protocol identifier
+	binary.BigEndian.PutUint16(frame[4:], 6) // length of unit identifier and PDU
+	frame[6] = unitID
+	frame[7] = function
+	binary.BigEndian.PutUint16(frame[8:], start)
+	binary.BigEndian.PutUint16(frame[10:], count)
+
+	if _, err := c.conn.Write(frame); err != nil {
+		c.closeLocked()
+		return nil, fmt.Errorf("failed to send modbus request to %s: %v", c.address, err)
+	}
+
+	pdu, err := c.readResponse()
+	if err != nil {
+		c.closeLocked()
+		return nil, err
+	}
+
+	if pdu[0] == function|0x80 {
+		return nil, fmt.Errorf("modbus %s: exception %d for function %d at %d", c.address, pdu[1], function, start)
+	}
+	if pdu[0] != function {
+		c.closeLocked()
+		return nil, fmt.Errorf("modbus %s: unexpected function %d in response", c.address, pdu[0])
+	}
+	if len(pdu) < 2 || int(pdu[1]) != len(pdu)-2 {
+		c.closeLocked()
+		return nil, fmt.Errorf("modbus %s: malformed response", c.address)
+	}
+	return pdu[2:], nil
+}
+
+// readResponse reads one MBAP frame matching the current transaction and returns its PDU.
+func (c *Client) readResponse() ([]byte, error) {
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			return nil, fmt.Errorf("failed to read modbus response from %s: %v", c.address, err)
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if length < 3 || length > 254 {
+			return nil, fmt.Errorf("modbus %s: invalid frame length %d", c.address, length)
+		}
+
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(c.conn, pdu); err != nil {
+			return nil, fmt.Errorf("failed to read modbus response from %s: %v", c.address, err)
+		}
+
+		// Skip late answers to earlier, timed out requests
+		if binary.BigEndian.Uint16(header[0:]) == c.transactionID {
+			return pdu, nil
+		}
+	}
+}
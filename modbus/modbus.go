@@ -0,0 +1,250 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/tool/models"
+)
+
+// Block is a single read request covering a range of addresses of one Modbus table.
+type Block struct {
+	Function byte
+	Start    uint16
+	Count    uint16
+}
+
+// Function returns the read function code of a point's register table.
+func Function(register string) (byte, error) {
+	switch register {
+	case "", "holding":
+		return ReadHoldingRegisters, nil
+	case "input":
+		return ReadInputRegisters, nil
+	case "coil":
+		return ReadCoils, nil
+	case "discrete":
+		return ReadDiscreteInputs, nil
+	default:
+		return 0, fmt.Errorf("unknown modbus register table: %s", register)
+	}
+}
+
+// ParseAddress returns the register number of a point address such as "Address12".
+func ParseAddress(name string) (uint16, error) {
+	number, err := strconv.ParseUint(strings.TrimPrefix(name, "Address"), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid modbus address: %s", name)
+	}
+	return uint16(number), nil
+}
+
+// Tables returns the read function of every address used by a profile. Values are keyed by address
+// name alone, as the HTTP device API returns them, so a profile must not use an address in two tables.
+func Tables(points models.ConfigPoint) (map[uint16]byte, error) {
+	keys := make([]string, 0, len(points.ChannelSetting))
+	for key := range points.ChannelSetting {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tables := make(map[uint16]byte)
+	for _, key := range keys {
+		point := points.ChannelSetting[key]
+		function, err := Function(point.Register)
+		if err != nil {
+			return nil, fmt.Errorf("point %s: %v", key, err)
+		}
+		for _, name := range point.Value {
+			address, err := ParseAddress(name)
+			if err != nil {
+				return nil, fmt.Errorf("point %s: %v", key, err)
+			}
+			if other, ok := tables[address]; ok && other != function {
+				return nil, fmt.Errorf("point %s: Address%d is already used in another register table", key, address)
+			}
+			tables[address] = function
+		}
+	}
+	return tables, nil
+}
+
+// NoCoalescing is the gap that makes Plan read every wanted address with a request of its own,
+// not even merging adjacent ones.
+const NoCoalescing = -1
+
+// Plan returns the read requests needed for every point of a profile.
+// Addresses of the same table are coalesced into one request as long as the request stays within
+// the protocol limit and at most gap unused addresses lie between two wanted ones.
+func Plan(points models.ConfigPoint, gap int) ([]Block, error) {
+	tables, err := Tables(points)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make(map[byte]map[uint16]bool)
+	for address, function := range tables {
+		if addresses[function] == nil {
+			addresses[function] = make(map[uint16]bool)
+		}
+		addresses[function][address] = true
+	}
+
+	var blocks []Block
+	for _, function := range []byte{ReadCoils, ReadDiscreteInputs, ReadHoldingRegisters, ReadInputRegisters} {
+		limit := MaxRegisters
+		if function == ReadCoils || function == ReadDiscreteInputs {
+			limit = MaxBits
+		}
+
+		sorted := make([]uint16, 0, len(addresses[function]))
+		for address := range addresses[function] {
+			sorted = append(sorted, address)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		for _, address := range sorted {
+			last := len(blocks) - 1
+			if last >= 0 && blocks[last].Function == function {
+				end := int(blocks[last].Start) + int(blocks[last].Count) // first address after the block
+				count := int(address) - int(blocks[last].Start) + 1
+				if int(address)-end <= gap && count <= limit {
+					blocks[last].Count = uint16(count)
+					continue
+				}
+			}
+			blocks = append(blocks, Block{Function: function, Start: address, Count: 1})
+		}
+	}
+
+	return blocks, nil
+}
+
+// plan is the planned reads of a profile together with the table of every wanted address.
+type plan struct {
+	blocks []Block
+	tables map[uint16]byte
+}
+
+// Planner plans the read requests of point profiles and caches the plan of every named profile.
+type Planner struct {
+	gap   int
+	mu    sync.Mutex
+	plans map[string]*plan
+}
+
+// NewPlanner creates a planner that bridges up to gap unused addresses between two wanted ones.
+func NewPlanner(gap int) *Planner {
+	return &Planner{gap: gap, plans: make(map[string]*plan)}
+}
+
+// Plan returns the cached plan of the named profile, planning it on first use.
+// Profiles without a name are planned on every call.
+func (p *Planner) Plan(profile string, points models.ConfigPoint) ([]Block, error) {
+	planned, err := p.plan(profile, points)
+	if err != nil {
+		return nil, err
+	}
+	return planned.blocks, nil
+}
+
+func (p *Planner) plan(profile string, points models.ConfigPoint) (*plan, error) {
+	if profile != "" {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if planned, ok := p.plans[profile]; ok {
+			return planned, nil
+		}
+	}
+
+	tables, err := Tables(points)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := Plan(points, p.gap)
+	if err != nil {
+		return nil, err
+	}
+	planned := &plan{blocks: blocks, tables: tables}
+	if profile != "" {
+		p.plans[profile] = planned
+	}
+	return planned, nil
+}
+
+// Pool reuses one client per Modbus TCP server.
+type Pool struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+	timeout time.Duration
+	planner *Planner
+}
+
+// NewPool creates an empty pool whose clients use timeout for connecting and for every request,
+// and whose reads are planned by planner.
+func NewPool(timeout time.Duration, planner *Planner) *Pool {
+	return &Pool{clients: make(map[string]*Client), timeout: timeout, planner: planner}
+}
+
+// Client returns the client of the server at address, creating it if needed.
+func (p *Pool) Client(address string) *Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, ok := p.clients[address]
+	if !ok {
+		client = NewClient(address, p.timeout)
+		p.clients[address] = client
+	}
+	return client
+}
+
+// Read polls every point of a profile from a Modbus device and returns the raw values keyed by
+// address name ("AddressN"), the same shape the HTTP device API returns, so that the result can be
+// decoded by format.ProcessData. Bits are returned as 0 or 1. Unused addresses read to bridge gaps
+// are left out.
+func (p *Pool) Read(ctx context.Context, address string, unitID uint8, profile string, points models.ConfigPoint) (map[string]float64, error) {
+	planned, err := p.planner.plan(profile, points)
+	if err != nil {
+		return nil, err
+	}
+
+	client := p.Client(address)
+	data := make(map[string]float64)
+	// set stores the value read at register of the block's table if the profile uses it there
+	set := func(block Block, register int, value float64) {
+		if function, ok := planned.tables[uint16(register)]; ok && function == block.Function {
+			data[fmt.Sprintf("Address%d", register)] = value
+		}
+	}
+	for _, block := range planned.blocks {
+		switch block.Function {
+		case ReadHoldingRegisters, ReadInputRegisters:
+			registers, err := client.ReadRegisters(ctx, unitID, block.Function, block.Start, block.Count)
+			if err != nil {
+				return nil, err
+			}
+			for i, register := range registers {
+				set(block, int(block.Start)+i, float64(register))
+			}
+		default:
+			bits, err := client.ReadBits(ctx, unitID, block.Function, block.Start, block.Count)
+			if err != nil {
+				return nil, err
+			}
+			for i, bit := range bits {
+				value := 0.0
+				if bit {
+					value = 1
+				}
+				set(block, int(block.Start)+i, value)
+			}
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,212 @@
+package modbus_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/tool/modbus"
+	"example.com/tool/modbus/modbustest"
+	"example.com/tool/models"
+)
+
+// profile builds a point profile from point name → register table and address names.
+func profile(points map[string][2]string) models.ConfigPoint {
+	settings := make(map[string]models.Point, len(points))
+	for name, point := range points {
+		settings[name] = models.Point{Register: point[0], Value: strings.Fields(point[1])}
+	}
+	return models.ConfigPoint{ChannelSetting: settings}
+}
+
+func TestPlan(t *testing.T) {
+	points := profile(map[string][2]string{
+		"kw":    {"", "Address1 Address2"},
+		"kwh":   {"holding", "Address5 Address6"},
+		"pf":    {"holding", "Address40"},
+		"temp":  {"input", "Address11"},
+		"alarm": {"coil", "Address7"},
+		"door":  {"discrete", "Address9"},
+	})
+
+	for _, tc := range []struct {
+		gap  int
+		want []modbus.Block
+	}{
+		{gap: modbus.NoCoalescing, want: []modbus.Block{
+			{Function: modbus.ReadCoils, Start: 7, Count: 1},
+			{Function: modbus.ReadDiscreteInputs, Start: 9, Count: 1},
+			{Function: modbus.ReadHoldingRegisters, Start: 1, Count: 1},
+			{Function: modbus.ReadHoldingRegisters, Start: 2, Count: 1},
+			{Function: modbus.ReadHoldingRegisters, Start: 5, Count: 1},
+			{Function: modbus.ReadHoldingRegisters, Start: 6, Count: 1},
+			{Function: modbus.ReadHoldingRegisters, Start: 40, Count: 1},
+			{Function: modbus.ReadInputRegisters, Start: 11, Count: 1},
+		}},
+		{gap: 0, want: []modbus.Block{
+			{Function: modbus.ReadCoils, Start: 7, Count: 1},
+			{Function: modbus.ReadDiscreteInputs, Start: 9, Count: 1},
+			{Function: modbus.ReadHoldingRegisters, Start: 1, Count: 2},
+			{Function: modbus.ReadHoldingRegisters, Start: 5, Count: 2},
+			{Function: modbus.ReadHoldingRegisters, Start: 40, Count: 1},
+			{Function: modbus.ReadInputRegisters, Start: 11, Count: 1},
+		}},
+		{gap: 2, want: []modbus.Block{
+			{Function: modbus.ReadCoils, Start: 7, Count: 1},
+			{Function: modbus.ReadDiscreteInputs, Start: 9, Count: 1},
+			{Function: modbus.ReadHoldingRegisters, Start: 1, Count: 6},
+			{Function: modbus.ReadHoldingRegisters, Start: 40, Count: 1},
+			{Function: modbus.ReadInputRegisters, Start: 11, Count: 1},
+		}},
+	} {
+		blocks, err := modbus.Plan(points, tc.gap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(blocks, tc.want) {
+			t.Errorf("gap %d: planned %+v, want %+v", tc.gap, blocks, tc.want)
+		}
+	}
+}
+
+func TestPlanRespectsRequestLimit(t *testing.T) {
+	points := profile(map[string][2]string{
+		"first": {"holding", "Address0"},
+		"last":  {"holding", "Address125"},
+	})
+	blocks, err := modbus.Plan(points, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Registers 0 to 125 are 126 registers, one more than a request may read
+	want := []modbus.Block{
+		{Function: modbus.ReadHoldingRegisters, Start: 0, Count: 1},
+		{Function: modbus.ReadHoldingRegisters, Start: 125, Count: 1},
+	}
+	if !reflect.DeepEqual(blocks, want) {
+		t.Errorf("planned %+v, want %+v", blocks, want)
+	}
+}
+
+func TestPlanRejectsAddressInTwoTables(t *testing.T) {
+	points := profile(map[string][2]string{
+		"kw":    {"holding", "Address1"},
+		"alarm": {"coil", "Address1"},
+	})
+	if _, err := modbus.Plan(points, 0); err == nil {
+		t.Fatal("planned a profile using Address1 as register and coil")
+	}
+	if _, err := modbus.Plan(profile(map[string][2]string{"kw": {"holding", "Register1"}}), 0); err == nil {
+		t.Fatal("planned an invalid address name")
+	}
+	if _, err := modbus.Plan(profile(map[string][2]string{"kw": {"eeprom", "Address1"}}), 0); err == nil {
+		t.Fatal("planned an unknown register table")
+	}
+}
+
+func TestPoolRead(t *testing.T) {
+	server := modbustest.NewServer()
+	defer server.Close()
+
+	server.SetRegister(modbus.ReadHoldingRegisters, 1, 0x4148)
+	server.SetRegister(modbus.ReadHoldingRegisters, 2, 0x0000)
+	server.SetRegister(modbus.ReadHoldingRegisters, 3, 999) // bridged gap, not part of the profile
+	server.SetRegister(modbus.ReadHoldingRegisters, 4, 230)
+	server.SetRegister(modbus.ReadInputRegisters, 10, 42)
+	server.SetRegister(modbus.ReadInputRegisters, 6, 777) // same address as a coil of the profile, not read
+	server.SetBit(modbus.ReadCoils, 6, true)
+	server.SetBit(modbus.ReadCoils, 5, true) // bridged gap
+	server.SetBit(modbus.ReadDiscreteInputs, 20, false)
+
+	points := profile(map[string][2]string{
+		"kw":      {"holding", "Address1 Address2"},
+		"voltage": {"holding", "Address4"},
+		"temp":    {"input", "Address10"},
+		"alarm":   {"coil", "Address6"},
+		"trip":    {"coil", "Address3"},
+		"door":    {"discrete", "Address20"},
+	})
+
+	pool := modbus.NewPool(time.Second, modbus.NewPlanner(8))
+	data, err := pool.Read(context.Background(), server.Addr, 1, "meter", points)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{
+		"Address1":  0x4148,
+		"Address2":  0,
+		"Address4":  230,
+		"Address10": 42,
+		"Address6":  1,
+		"Address3":  0,
+		"Address20": 0,
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Fatalf("read %v, want %v", data, want)
+	}
+	// Holding 1-4 coalesced, coils 3-6 coalesced, one input and one discrete request
+	if requests := server.Requests(); requests != 4 {
+		t.Errorf("sent %d requests, want 4", requests)
+	}
+
+	// The plan is cached and the connection reused
+	if _, err := pool.Read(context.Background(), server.Addr, 1, "meter", points); err != nil {
+		t.Fatal(err)
+	}
+	if requests := server.Requests(); requests != 8 {
+		t.Errorf("sent %d requests after the second read, want 8", requests)
+	}
+}
+
+func TestPoolReadUnreachable(t *testing.T) {
+	server := modbustest.NewServer()
+	addr := server.Addr
+	server.Close()
+
+	pool := modbus.NewPool(200*time.Millisecond, modbus.NewPlanner(0))
+	points := profile(map[string][2]string{"kw": {"holding", "Address1"}})
+	if _, err := pool.Read(context.Background(), addr, 1, "", points); err == nil {
+		t.Fatal("read from a closed server")
+	}
+}
+
+func TestPoolWithoutTimeoutUsesContext(t *testing.T) {
+	server := modbustest.NewServer()
+	defer server.Close()
+	server.SetRegister(modbus.ReadHoldingRegisters, 1, 42)
+
+	pool := modbus.NewPool(0, modbus.NewPlanner(0))
+	points := profile(map[string][2]string{"kw": {"holding", "Address1"}})
+	if data, err := pool.Read(context.Background(), server.Addr, 1, "", points); err != nil || data["Address1"] != 42 {
+		t.Fatalf("read %v: %v", data, err)
+	}
+
+	// A server that accepts but never answers is given up on at the deadline of the context
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := pool.Read(ctx, listener.Addr().String(), 1, "", points); err == nil {
+		t.Fatal("read from a silent server")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("gave up after %v", elapsed)
+	}
+}
@@ -0,0 +1,161 @@
+// Package modbustest provides a Modbus TCP server for tests, serving the four read functions
+// from in-memory tables.
+package modbustest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"example.com/tool/modbus"
+)
+
+// Server is a Modbus TCP server listening on a local port. Addresses that were not set read as 0.
+type Server struct {
+	Addr string // host:port the server listens on
+
+	listener net.Listener
+	requests atomic.Int64
+
+	mu     sync.Mutex
+	values map[byte]map[uint16]uint16 // function → address → value
+	conns  map[net.Conn]bool
+	wg     sync.WaitGroup
+}
+
+// NewServer starts a server on a random local port. Close it when done.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("modbustest: failed to listen: " + err.Error())
+	}
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		values:   make(map[byte]map[uint16]uint16),
+		conns:    make(map[net.Conn]bool),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// SetRegister sets a holding (modbus.ReadHoldingRegisters) or input (modbus.ReadInputRegisters) register.
+func (s *Server) SetRegister(function byte, address, value uint16) {
+	s.set(function, address, value)
+}
+
+// SetBit sets a coil (modbus.ReadCoils) or discrete input (modbus.ReadDiscreteInputs).
+func (s *Server) SetBit(function byte, address uint16, value bool) {
+	var v uint16
+	if value {
+		v = 1
+	}
+	s.set(function, address, v)
+}
+
+func (s *Server) set(function byte, address, value uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values[function] == nil {
+		s.values[function] = make(map[uint16]uint16)
+	}
+	s.values[function][address] = value
+}
+
+// Requests returns the number of read requests answered so far.
+func (s *Server) Requests() int64 {
+	return s.requests.Load()
+}
+
+// Close stops the server and closes every open connection.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// handle answers the requests of one connection until it is closed.
+func (s *Server) handle(conn net.Conn) {
+	for {
+		frame := make([]byte, 12)
+		if _, err := io.ReadFull(conn, frame); err != nil {
+			return
+		}
+		unitID, function := frame[6], frame[7]
+		start := binary.BigEndian.Uint16(frame[8:])
+		count := binary.BigEndian.Uint16(frame[10:])
+
+		pdu := s.respond(function, start, count)
+		response := make([]byte, 7, 7+len(pdu))
+		copy(response, frame[:4]) // transaction and protocol identifier
+		binary.BigEndian.PutUint16(response[4:], uint16(len(pdu)+1))
+		response[6] = unitID
+		response = append(response, pdu...)
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+		s.requests.Add(1)
+	}
+}
+
+// respond builds the PDU answering a read request, an exception for unknown functions or counts.
+func (s *Server) respond(function byte, start, count uint16) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	table := s.values[function]
+
+	switch function {
+	case modbus.ReadHoldingRegisters, modbus.ReadInputRegisters:
+		if count == 0 || count > modbus.MaxRegisters {
+			return []byte{function | 0x80, 3} // illegal data value
+		}
+		pdu := []byte{function, byte(count * 2)}
+		for i := uint16(0); i < count; i++ {
+			pdu = binary.BigEndian.AppendUint16(pdu, table[start+i])
+		}
+		return pdu
+
+	case modbus.ReadCoils, modbus.ReadDiscreteInputs:
+		if count == 0 || count > modbus.MaxBits {
+			return []byte{function | 0x80, 3}
+		}
+		bits := make([]byte, (count+7)/8)
+		for i := uint16(0); i < count; i++ {
+			if table[start+i] != 0 {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append([]byte{function, byte(len(bits))}, bits...)
+	}
+	return []byte{function | 0x80, 1} // illegal function
+}
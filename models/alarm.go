@@ -0,0 +1,46 @@
+package models
+
+// AlarmConfig holds the alarm definitions read from the alarm rules file.
+type AlarmConfig struct {
+	Rules  map[string][]AlarmRule `json:"rules"` // keyed by measurement name
+	Notify NotifyConfig           `json:"notify"`
+}
+
+// AlarmRule defines when an alarm is raised for a measurement.
+type AlarmRule struct {
+	Name      string  `json:"name"`
+	Devices   string  `json:"devices"`   // glob pattern matched against the equipment name or device path, empty matches all
+	Type      string  `json:"type"`      // "threshold" (default) compares the value, "rate" compares the change per second
+	Condition string  `json:"condition"` // one of ">", ">=", "<", "<="
+	Threshold float64 `json:"threshold"`
+	Deadband  float64 `json:"deadband"` // hysteresis, the value must move this far back past the threshold to clear
+	DelayOn   int     `json:"delayOn"`  // seconds the condition must hold before the alarm is raised
+	DelayOff  int     `json:"delayOff"` // seconds the clear condition must hold before the alarm is cleared
+	Severity  string  `json:"severity"`
+}
+
+// AlarmEvent is emitted when an alarm is raised or cleared.
+type AlarmEvent struct {
+	Rule        string  `json:"rule"`
+	Measurement string  `json:"measurement"`
+	Device      string  `json:"device"`
+	Name        string  `json:"name"`
+	Severity    string  `json:"severity"`
+	State       string  `json:"state"` // "raise" or "clear"
+	Value       float64 `json:"value"`
+	Threshold   float64 `json:"threshold"`
+	Timestamp   int64   `json:"timestamp"`
+}
+
+// ActiveAlarm is an entry of the active-alarm table.
+type ActiveAlarm struct {
+	Rule        string  `json:"rule"`
+	Measurement string  `json:"measurement"`
+	Device      string  `json:"device"`
+	Name        string  `json:"name"`
+	Severity    string  `json:"severity"`
+	Value       float64 `json:"value"`
+	Threshold   float64 `json:"threshold"`
+	RaisedAt    int64   `json:"raisedAt"`
+	UpdatedAt   int64   `json:"updatedAt"`
+}
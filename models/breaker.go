@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// BreakerConfig configures the circuit breakers of devices or sinks.
+type BreakerConfig struct {
+	FailureThreshold int `json:"failureThreshold"` // consecutive failures that open the breaker
+	ProbeIntervalMs  int `json:"probeIntervalMs"`  // while open, one request per interval probes for recovery
+}
+
+// BreakerStatus is the state of a single circuit breaker.
+type BreakerStatus struct {
+	Kind      string     `json:"kind"` // "device" or "sink"
+	Name      string     `json:"name"`
+	State     string     `json:"state"` // "closed", "open" or "half-open"
+	Failures  int        `json:"consecutiveFailures"`
+	LastError string     `json:"lastError,omitempty"`
+	OpenedAt  *time.Time `json:"openedAt,omitempty"`
+	NextProbe *time.Time `json:"nextProbe,omitempty"`
+}
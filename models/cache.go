@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// LatestEntry is the last decoded sample of a device kept in memory.
+type LatestEntry struct {
+	Name       string
+	Data       SentData
+	ReceivedAt time.Time
+}
+
+// DeviceSummary describes a device known to the latest-value cache.
+type DeviceSummary struct {
+	Name       string    `json:"name"`
+	Device     string    `json:"device"`
+	Timestamp  int64     `json:"timestamp"`
+	Time       time.Time `json:"time"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// DeviceLatest is the last decoded sample of a device with all its measurements.
+type DeviceLatest struct {
+	DeviceSummary
+	Values map[string]float64 `json:"values"`
+}
+
+// PointLatest is the last decoded value of a single measurement of a device.
+type PointLatest struct {
+	DeviceSummary
+	Measurement string  `json:"measurement"`
+	Value       float64 `json:"value"`
+}
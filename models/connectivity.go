@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// ConnectivityConfig decides when a device counts as degraded or offline.
+type ConnectivityConfig struct {
+	DegradedAfterFailures int `json:"degradedAfterFailures"` // consecutive failed polls
+	OfflineAfterFailures  int `json:"offlineAfterFailures"`
+	StaleSeconds          int `json:"staleSeconds"`   // without a sample the device is degraded
+	OfflineSeconds        int `json:"offlineSeconds"` // without a sample the device is offline
+}
+
+// DeviceStatus is the connectivity state of one device.
+type DeviceStatus struct {
+	Name      string     `json:"name"`             // e.g. equipment1234
+	Device    string     `json:"device,omitempty"` // device path, unknown for disabled devices not seen since the start
+	State     string     `json:"state"`            // "online", "degraded", "offline" or "disabled"
+	Since     time.Time  `json:"since"`
+	Failures  int        `json:"consecutiveFailures"`
+	LastError string     `json:"lastError,omitempty"`
+	LastSeen  *time.Time `json:"lastSeen,omitempty"` // time of the last sample or successful poll
+}
+
+// StatusEvent is emitted when the connectivity state of a device changes.
+type StatusEvent struct {
+	Name      string `json:"name"`
+	Device    string `json:"device"`
+	State     string `json:"state"`
+	Previous  string `json:"previous,omitempty"` // empty for the first state of a device
+	Failures  int    `json:"consecutiveFailures"`
+	Reason    string `json:"reason,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
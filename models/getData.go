@@ -38,3 +38,34 @@ type AddressData struct {
 	Address32 int `json:"Address32"`
 	Address33 int `json:"Address33"`
 }
+
+// FetchClient configures the HTTP client polling the devices. Connections are pooled per host and port.
+type FetchClient struct {
+	MaxIdleConns            int `json:"maxIdleConns"`        // idle connections kept over all hosts, 0 is unlimited
+	MaxIdleConnsPerHost     int `json:"maxIdleConnsPerHost"` // defaults to semaphoreForGet, the workers polling one device group
+	MaxConnsPerHost         int `json:"maxConnsPerHost"`     // 0 is unlimited
+	IdleConnTimeoutMs       int `json:"idleConnTimeoutMs"`
+	KeepAliveMs             int `json:"keepAliveMs"` // TCP keep-alive probe interval
+	DialTimeoutMs           int `json:"dialTimeoutMs"`
+	TLSHandshakeTimeoutMs   int `json:"tlsHandshakeTimeoutMs"`
+	ResponseHeaderTimeoutMs int `json:"responseHeaderTimeoutMs"`
+	DeviceTimeoutMs         int `json:"deviceTimeoutMs"` // budget of a whole device poll, from connecting to decoding
+}
+
+// MQTTSource configures the MQTT subscriber for devices that push their readings.
+type MQTTSource struct {
+	Broker   string      `json:"broker"` // e.g. tcp://127.0.0.1:1883
+	ClientID string      `json:"clientId"`
+	Username string      `json:"username"`
+	Password string      `json:"password"`
+	Topics   []MQTTTopic `json:"topics"`
+}
+
+// MQTTTopic maps a topic pattern to equipment names.
+// The pattern is an MQTT topic filter whose levels may be named placeholders such as {id},
+// which match a single level like + and can be used in the name template.
+type MQTTTopic struct {
+	Pattern string `json:"pattern"` // e.g. gateways/+/meters/{id}/telemetry
+	Name    string `json:"name"`    // e.g. equipment{id}, defaults to {equipment}
+	Profile string `json:"profile"` // point profile decoding the payload, defaults to "default"
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// ComponentStatus is the health report of a single collector subsystem.
+type ComponentStatus struct {
+	Healthy     bool       `json:"healthy"`
+	LastBeat    *time.Time `json:"lastBeat,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	Failures    int        `json:"consecutiveFailures"`
+}
+
+// QueueStatus reports the fill level of the message queue.
+type QueueStatus struct {
+	Length    int  `json:"length"`
+	Capacity  int  `json:"capacity"`
+	HighWater int  `json:"highWater"`
+	Healthy   bool `json:"healthy"`
+}
+
+// HealthResponse is the JSON body returned by /healthz and /readyz.
+type HealthResponse struct {
+	Status     string                     `json:"status"`
+	CheckedAt  time.Time                  `json:"checkedAt"`
+	Components map[string]ComponentStatus `json:"components"`
+	Queue      *QueueStatus               `json:"queue,omitempty"`
+}
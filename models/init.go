@@ -2,13 +2,40 @@ package models
 
 // Config struct to hold the JSON configuration
 type Config struct {
-	GetDataApiHost   string `json:"getDataApiHost"`
-	SentDataApiHost  string `json:"sentDataApiHost"`
-	BatchSize        int    `json:"BatchSize"`
-	StartMinute      int    `json:"startMinute"`
-	MaxQueue         int    `json:"maxQueue"`
-	SemaphoreForGet  int    `json:"semaphoreForGet"`
-	SemaphoreForSave int    `json:"semaphoreForSave"`
+	GetDataApiHost     string             `json:"getDataApiHost"`
+	SentDataApiHost    string             `json:"sentDataApiHost"`
+	BatchSize          int                `json:"BatchSize"`
+	StartMinute        int                `json:"startMinute"`
+	MaxQueue           int                `json:"maxQueue"`
+	SemaphoreForGet    int                `json:"semaphoreForGet"`
+	SemaphoreForSave   int                `json:"semaphoreForSave"`
+	MaxSweepsInFlight  int                `json:"maxSweepsInFlight"` // overlapping sweeps per device group
+	HttpListen         string             `json:"httpListen"`
+	QueueHighWater     int                `json:"queueHighWater"`
+	QueueLowWater      int                `json:"queueLowWater"`
+	Backpressure       string             `json:"backpressure"` // "slow" (default), "drop-oldest", "drop-newest" or "spill" above queueHighWater
+	SpillDir           string             `json:"spillDir"`
+	StallSeconds       int                `json:"stallSeconds"`
+	StreamBuffer       int                `json:"streamBuffer"`
+	AlarmFile          string             `json:"alarmFile"`
+	StoreDialect       string             `json:"storeDialect"` // "mysql" or "sqlite", empty disables the metadata store
+	StoreDSN           string             `json:"storeDsn"`
+	DeviceSource       string             `json:"deviceSource"`       // "ranges" (default) or "store"
+	AdminTokens        map[string]string  `json:"adminTokens"`        // admin API bearer token → operator name
+	ModbusGapTolerance int                `json:"modbusGapTolerance"` // unused registers bridged when coalescing Modbus reads
+	FetchClient        FetchClient        `json:"fetchClient"`        // HTTP client polling the devices
+	Retry              RetryPolicy        `json:"retry"`              // retries of the database REST API and the control API
+	DeviceBreaker      BreakerConfig      `json:"deviceBreaker"`      // slows down polling devices that keep failing
+	SinkBreaker        BreakerConfig      `json:"sinkBreaker"`        // diverts batches from sinks that keep failing
+	Connectivity       ConnectivityConfig `json:"connectivity"`       // when devices count as degraded or offline
+	MQTTSource         *MQTTSource        `json:"mqttSource"`         // nil disables the MQTT subscriber
+	MQTTSink           *MQTTSink          `json:"mqttSink"`           // nil disables publishing to MQTT
+	InfluxSink         *InfluxSink        `json:"influxSink"`         // nil disables writing to InfluxDB
+	RemoteWriteSink    *RemoteWriteSink   `json:"remoteWriteSink"`    // nil disables Prometheus remote write
+	FileSinks          []FileSink         `json:"fileSinks"`          // file exports, one per format
+	RecordDir          string             `json:"recordDir"`          // empty disables recording raw responses
+	FlushIntervalMs    int                `json:"flushIntervalMs"`    // longest a sample waits in a batch before it is saved
+	MaxBatchBytes      int                `json:"maxBatchBytes"`      // largest JSON payload sent to the database REST API
 }
 
 type ConfigPoint struct {
@@ -30,4 +57,14 @@ type Point struct {
 	Reverse    bool     `json:"reverse"`
 	FloatPoint int      `json:"floatPoint"`
 	Type       string   `json:"Type"`
+	Register   string   `json:"register,omitempty"` // Modbus table: "holding" (default, FC 03), "input" (FC 04), "coil" (FC 01) or "discrete" (FC 02)
+}
+
+// RetryPolicy configures how failed outbound HTTP requests are retried. Missing settings use the defaults of retry.New.
+type RetryPolicy struct {
+	MaxAttempts      int     `json:"maxAttempts"`
+	InitialBackoffMs int     `json:"initialBackoffMs"` // delay before the first retry
+	MaxBackoffMs     int     `json:"maxBackoffMs"`     // longest delay, also caps the wait a Retry-After asks for
+	Multiplier       float64 `json:"multiplier"`       // growth of the delay on every further retry
+	Jitter           float64 `json:"jitter"`           // fraction of every delay that is randomized
 }
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// NotifyConfig configures the delivery of alarm events to external systems.
+type NotifyConfig struct {
+	DeliveryLog string    `json:"deliveryLog"` // JSON lines file every delivery attempt is appended to
+	Webhooks    []Webhook `json:"webhooks"`
+}
+
+// Webhook is an HTTP endpoint alarm events are posted to.
+type Webhook struct {
+	Name              string            `json:"name"`
+	URL               string            `json:"url"`
+	Headers           map[string]string `json:"headers"`
+	Template          string            `json:"template"`          // text/template rendering the JSON body, see WebhookMessage
+	States            []string          `json:"states"`            // "raise" and/or "clear", empty accepts both
+	Severities        []string          `json:"severities"`        // empty accepts every severity
+	GroupSeconds      int               `json:"groupSeconds"`      // events of the same device are sent as one message per window
+	MaxPerRule        int               `json:"maxPerRule"`        // events of the same rule accepted per rule window, 0 is unlimited
+	RuleWindowSeconds int               `json:"ruleWindowSeconds"` // length of the rule rate-limit window
+	MaxAttempts       int               `json:"maxAttempts"`
+	BackoffMs         int               `json:"backoffMs"` // delay before the first retry, doubled on every further retry
+}
+
+// WebhookMessage is the data a webhook template is executed with.
+type WebhookMessage struct {
+	Webhook string       `json:"webhook"`
+	Device  string       `json:"device"`
+	Name    string       `json:"name"`
+	Events  []AlarmEvent `json:"events"`
+	SentAt  time.Time    `json:"sentAt"`
+}
+
+// Delivery is an entry of the local delivery log.
+type Delivery struct {
+	Time    time.Time `json:"time"`
+	Webhook string    `json:"webhook"`
+	Device  string    `json:"device"`
+	Events  int       `json:"events"`
+	Attempt int       `json:"attempt"`
+	Status  int       `json:"status,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Success bool      `json:"success"`
+}
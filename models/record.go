@@ -0,0 +1,17 @@
+package models
+
+// RecordingHeader is the first line of a raw response recording.
+type RecordingHeader struct {
+	Version   int                    `json:"version"`
+	StartedAt int64                  `json:"startedAt"` // ms
+	Profiles  map[string]ConfigPoint `json:"profiles"`  // point profiles in use while recording
+}
+
+// RawRecord is a raw device response as fetched, before decoding.
+type RawRecord struct {
+	Timestamp int64              `json:"ts"` // ms
+	URL       string             `json:"url"`
+	Equipment string             `json:"equipment"`
+	Profile   string             `json:"profile"`
+	Data      map[string]float64 `json:"data"`
+}
@@ -18,3 +18,56 @@ type SentDataByBatched struct {
 	IsAligned        bool        `json:"is_aligned"`
 	Devices          []string    `json:"devices"`
 }
+
+// MQTTSink configures publishing of decoded samples to an MQTT broker.
+// Topic is a template of {bindArea} (with its dots as topic levels), {equipment} and {measurement}.
+// With {measurement} in the template every value is published to its own topic, otherwise the whole sample is.
+type MQTTSink struct {
+	Broker    string `json:"broker"` // e.g. tcp://127.0.0.1:1883
+	ClientID  string `json:"clientId"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Topic     string `json:"topic"` // e.g. {bindArea}/{equipment}/{measurement}
+	QoS       byte   `json:"qos"`
+	Retained  bool   `json:"retained"`  // keep the last value of every topic on the broker
+	BatchSize int    `json:"batchSize"` // samples published at once, defaults to 1
+	Buffer    int    `json:"buffer"`    // samples held while the broker is slow, defaults to maxQueue
+}
+
+// InfluxSink configures writing of decoded samples to InfluxDB (or VictoriaMetrics) over its /api/v2/write API.
+type InfluxSink struct {
+	URL         string `json:"url"` // e.g. http://127.0.0.1:8086
+	Org         string `json:"org"`
+	Bucket      string `json:"bucket"`
+	Token       string `json:"token"`
+	Company     string `json:"company"`     // company tag, defaults to commonSetting.company of the points file
+	BatchSize   int    `json:"batchSize"`   // samples written at once, defaults to BatchSize
+	Buffer      int    `json:"buffer"`      // samples held while the database is slow, defaults to maxQueue
+	MaxAttempts int    `json:"maxAttempts"` // defaults to 3
+	BackoffMs   int    `json:"backoffMs"`   // first retry delay, doubled on every retry, defaults to 1000
+}
+
+// RemoteWriteSink configures pushing of decoded samples with the Prometheus remote-write protocol.
+type RemoteWriteSink struct {
+	URL               string            `json:"url"`               // e.g. http://127.0.0.1:9090/api/v1/write
+	Headers           map[string]string `json:"headers"`           // e.g. Authorization
+	Company           string            `json:"company"`           // company label, defaults to commonSetting.company of the points file
+	Counters          []string          `json:"counters"`          // measurements that only increase, e.g. kwh, sent as <name>_total counters
+	Shards            int               `json:"shards"`            // concurrent requests per batch, defaults to 4
+	MaxSamplesPerSend int               `json:"maxSamplesPerSend"` // defaults to 500
+	BatchSize         int               `json:"batchSize"`         // samples written at once, defaults to BatchSize
+	Buffer            int               `json:"buffer"`            // samples held while the receiver is slow, defaults to maxQueue
+	MaxAttempts       int               `json:"maxAttempts"`       // defaults to 3
+	BackoffMs         int               `json:"backoffMs"`         // first retry delay, doubled on every retry, defaults to 1000
+}
+
+// FileSink configures export of decoded samples to local files, one file per device group at a time.
+type FileSink struct {
+	Format        string `json:"format"`        // "csv", "jsonl" or "parquet"
+	Dir           string `json:"dir"`           // files are written to <dir>/<group>/
+	MaxBytes      int64  `json:"maxBytes"`      // start a new file once one grows past this size, 0 disables
+	RotateSeconds int    `json:"rotateSeconds"` // start a new file at every multiple of this many seconds of sample time, defaults to 3600
+	Compress      bool   `json:"compress"`      // gzip finished csv and jsonl files
+	BatchSize     int    `json:"batchSize"`     // samples written at once, defaults to BatchSize
+	Buffer        int    `json:"buffer"`        // samples held while the disk is slow, defaults to maxQueue
+}
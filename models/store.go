@@ -0,0 +1,102 @@
+package models
+
+import "time"
+
+// Device is a piece of equipment polled by the collector.
+type Device struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;size:128" json:"name"` // e.g. equipment1234
+	Group     string    `gorm:"index;size:64" json:"group"`       // devices of a group share a worker pool
+	Profile   string    `gorm:"size:128" json:"profile"`          // name of the point profile used to decode the device
+	Location  string    `gorm:"size:255" json:"location"`
+	Port      int       `json:"port"`                    // port of the device API or Modbus TCP server
+	Host      string    `gorm:"size:255" json:"host"`    // defaults to getDataApiHost
+	Protocol  string    `gorm:"size:16" json:"protocol"` // "http" (default) or "modbus"
+	UnitID    uint8     `json:"unitId"`                  // Modbus unit identifier
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// PointProfile is a named point definition, stored as the JSON of a ConfigPoint.
+type PointProfile struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Name       string    `gorm:"uniqueIndex;size:128" json:"name"`
+	Definition string    `gorm:"type:text" json:"definition"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// AlarmDefinition is an alarm rule stored in the database.
+type AlarmDefinition struct {
+	ID          uint    `gorm:"primaryKey" json:"id"`
+	Measurement string  `gorm:"index;size:128" json:"measurement"`
+	Name        string  `gorm:"size:128" json:"name"`
+	Devices     string  `gorm:"size:255" json:"devices"`
+	Type        string  `gorm:"size:32" json:"type"`
+	Condition   string  `gorm:"size:8" json:"condition"`
+	Threshold   float64 `json:"threshold"`
+	Deadband    float64 `json:"deadband"`
+	DelayOn     int     `json:"delayOn"`
+	DelayOff    int     `json:"delayOff"`
+	Severity    string  `gorm:"size:32" json:"severity"`
+	Enabled     bool    `json:"enabled"`
+}
+
+// AlarmEventRecord is a raise or clear event kept as alarm history.
+type AlarmEventRecord struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Rule        string    `gorm:"index;size:128" json:"rule"`
+	Measurement string    `gorm:"size:128" json:"measurement"`
+	Device      string    `gorm:"index;size:255" json:"device"`
+	Severity    string    `gorm:"size:32" json:"severity"`
+	State       string    `gorm:"size:16" json:"state"`
+	Value       float64   `json:"value"`
+	Threshold   float64   `json:"threshold"`
+	Timestamp   int64     `gorm:"index" json:"timestamp"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// TableName stores alarm history in the alarm_events table.
+func (AlarmEventRecord) TableName() string {
+	return "alarm_events"
+}
+
+// RunHistory records every run of the collector.
+type RunHistory struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+	Devices    int        `json:"devices"`
+	Status     string     `gorm:"size:32" json:"status"`
+}
+
+// Target is a single device endpoint to poll, together with the points used to decode it.
+type Target struct {
+	Name     string
+	URL      string // HTTP targets
+	Protocol string // "http" or "modbus"
+	Address  string // host:port of Modbus targets
+	UnitID   uint8
+	Profile  string // name of the point profile, empty for points not taken from the registry
+	Points   ConfigPoint
+}
+
+// AuditLog records a change made through the admin API.
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Actor     string    `gorm:"index;size:128" json:"actor"`
+	Action    string    `gorm:"size:32" json:"action"`
+	Device    string    `gorm:"index;size:128" json:"device"`
+	Detail    string    `gorm:"type:text" json:"detail"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DevicePatch holds the device fields an operator may change. Nil fields are left unchanged.
+type DevicePatch struct {
+	Group    *string `json:"group"`
+	Profile  *string `json:"profile"`
+	Location *string `json:"location"`
+	Port     *int    `json:"port"`
+	Enabled  *bool   `json:"enabled"`
+}
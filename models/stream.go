@@ -0,0 +1,17 @@
+package models
+
+// StreamFilter selects the samples delivered to a live-stream subscription.
+// Empty pattern lists match everything.
+type StreamFilter struct {
+	Devices      []string // glob patterns matched against the equipment name or the device path
+	Measurements []string // glob patterns matched against the measurement name
+}
+
+// StreamSample is a decoded sample as delivered to live-stream clients.
+type StreamSample struct {
+	Name      string             `json:"name"`
+	Device    string             `json:"device"`
+	Timestamp int64              `json:"timestamp"`
+	Values    map[string]float64 `json:"values"`
+	Dropped   uint64             `json:"dropped"`
+}
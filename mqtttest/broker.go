@@ -0,0 +1,362 @@
+// Package mqtttest provides a minimal MQTT 3.1.1 broker for tests. It accepts every client,
+// keeps subscriptions per connection, delivers messages published by the test with QoS 1 and
+// records the acknowledgements and the messages published by clients.
+package mqtttest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MQTT control packet types
+const (
+	connect     = 1
+	connack     = 2
+	publish     = 3
+	puback      = 4
+	pubrec      = 5
+	pubrel      = 6
+	pubcomp     = 7
+	subscribe   = 8
+	suback      = 9
+	unsubscribe = 10
+	unsuback    = 11
+	pingreq     = 12
+	pingresp    = 13
+	disconnect  = 14
+)
+
+// Message is a message published by a client.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retained bool
+}
+
+// Broker is an MQTT broker listening on a local port. Sessions are not kept across connections.
+type Broker struct {
+	Addr string // tcp://host:port the broker listens on
+
+	listener net.Listener
+
+	mu        sync.Mutex
+	conns     map[*conn]bool
+	nextID    uint16
+	acked     map[uint16]bool
+	published []Message
+	wg        sync.WaitGroup
+}
+
+// conn is a connected client.
+type conn struct {
+	net.Conn
+	writeMu sync.Mutex
+	filters map[string]bool // guarded by Broker.mu
+}
+
+// NewBroker starts a broker on a random local port. Close it when done.
+func NewBroker() *Broker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("mqtttest: failed to listen: " + err.Error())
+	}
+	b := &Broker{
+		Addr:     "tcp://" + listener.Addr().String(),
+		listener: listener,
+		conns:    make(map[*conn]bool),
+		acked:    make(map[uint16]bool),
+	}
+	b.wg.Add(1)
+	go b.serve()
+	return b
+}
+
+// Publish delivers payload with QoS 1 to every client subscribed to a matching filter and returns
+// the packet identifier, or 0 if no client is subscribed.
+func (b *Broker) Publish(topic string, payload []byte) uint16 {
+	b.mu.Lock()
+	var receivers []*conn
+	for c := range b.conns {
+		for filter := range c.filters {
+			if Match(filter, topic) {
+				receivers = append(receivers, c)
+				break
+			}
+		}
+	}
+	if len(receivers) == 0 {
+		b.mu.Unlock()
+		return 0
+	}
+	b.nextID++
+	if b.nextID == 0 {
+		b.nextID = 1
+	}
+	id := b.nextID
+	b.mu.Unlock()
+
+	body := appendString(nil, topic)
+	body = binary.BigEndian.AppendUint16(body, id)
+	body = append(body, payload...)
+	for _, c := range receivers {
+		c.write(publish<<4|1<<1, body)
+	}
+	return id
+}
+
+// WaitSubscribed waits until a client is subscribed to filter, false if it is not within timeout.
+func (b *Broker) WaitSubscribed(filter string, timeout time.Duration) bool {
+	return waitFor(timeout, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for c := range b.conns {
+			if c.filters[filter] {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Acked reports whether the message with packet identifier id was acknowledged.
+func (b *Broker) Acked(id uint16) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.acked[id]
+}
+
+// WaitAcked waits until the message with packet identifier id is acknowledged, false if it is not within timeout.
+func (b *Broker) WaitAcked(id uint16, timeout time.Duration) bool {
+	return waitFor(timeout, func() bool { return b.Acked(id) })
+}
+
+// Published returns the messages published by clients so far.
+func (b *Broker) Published() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.published...)
+}
+
+// Close stops the broker and closes every open connection.
+func (b *Broker) Close() {
+	b.listener.Close()
+	b.mu.Lock()
+	for c := range b.conns {
+		c.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func (b *Broker) serve() {
+	defer b.wg.Done()
+	for {
+		netConn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{Conn: netConn, filters: make(map[string]bool)}
+		b.mu.Lock()
+		b.conns[c] = true
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.handle(c)
+			b.mu.Lock()
+			delete(b.conns, c)
+			b.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+// handle answers the packets of one connection until it is closed.
+func (b *Broker) handle(c *conn) {
+	reader := bufio.NewReader(c)
+	for {
+		header, body, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case connect:
+			c.write(connack<<4, []byte{0, 0}) // no session present, accepted
+
+		case subscribe:
+			if len(body) < 2 {
+				return
+			}
+			granted := []byte{body[0], body[1]}
+			b.mu.Lock()
+			for rest := body[2:]; len(rest) > 0; {
+				filter, n, ok := readString(rest)
+				if !ok || len(rest) < n+1 {
+					b.mu.Unlock()
+					return
+				}
+				c.filters[filter] = true
+				granted = append(granted, min(rest[n], 1))
+				rest = rest[n+1:]
+			}
+			b.mu.Unlock()
+			c.write(suback<<4, granted)
+
+		case unsubscribe:
+			if len(body) < 2 {
+				return
+			}
+			b.mu.Lock()
+			for rest := body[2:]; len(rest) > 0; {
+				filter, n, ok := readString(rest)
+				if !ok {
+					b.mu.Unlock()
+					return
+				}
+				delete(c.filters, filter)
+				rest = rest[n:]
+			}
+			b.mu.Unlock()
+			c.write(unsuback<<4, body[:2])
+
+		case publish:
+			topic, n, ok := readString(body)
+			if !ok {
+				return
+			}
+			qos := header >> 1 & 3
+			var id []byte
+			if qos > 0 {
+				if len(body) < n+2 {
+					return
+				}
+				id, n = body[n:n+2], n+2
+			}
+			b.mu.Lock()
+			b.published = append(b.published, Message{Topic: topic, Payload: append([]byte(nil), body[n:]...), QoS: qos, Retained: header&1 == 1})
+			b.mu.Unlock()
+			switch qos {
+			case 1:
+				c.write(puback<<4, id)
+			case 2:
+				c.write(pubrec<<4, id)
+			}
+
+		case pubrel:
+			c.write(pubcomp<<4, body)
+
+		case puback:
+			if len(body) < 2 {
+				return
+			}
+			b.mu.Lock()
+			b.acked[binary.BigEndian.Uint16(body)] = true
+			b.mu.Unlock()
+
+		case pingreq:
+			c.write(pingresp<<4, nil)
+
+		case disconnect:
+			return
+		}
+	}
+}
+
+// write sends a packet, errors surface as the connection closing.
+func (c *conn) write(header byte, body []byte) {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.Write(packet)
+}
+
+// readPacket reads the fixed header and the body of a packet.
+func readPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+	return header, body, err
+}
+
+// readString reads a length-prefixed string and returns it with the number of bytes consumed.
+func readString(data []byte) (string, int, bool) {
+	if len(data) < 2 {
+		return "", 0, false
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return "", 0, false
+	}
+	return string(data[2 : 2+n]), 2 + n, true
+}
+
+func appendString(data []byte, s string) []byte {
+	data = binary.BigEndian.AppendUint16(data, uint16(len(s)))
+	return append(data, s...)
+}
+
+// Match reports whether topic matches the subscription filter, with + matching one level and # the rest.
+func Match(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,306 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+
+	"example.com/tool/models"
+	"example.com/tool/retry"
+)
+
+// defaultTemplate is used by webhooks that do not define their own body template.
+const defaultTemplate = `{"webhook":{{json .Webhook}},"device":{{json .Device}},"name":{{json .Name}},"sentAt":{{json .SentAt}},"events":{{json .Events}}}`
+
+var httpClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// shutdownTimeout bounds the deliveries still running or flushed when Run stops.
+var shutdownTimeout = 15 * time.Second
+
+// group collects the events of one device until its window ends.
+type group struct {
+	device   string
+	name     string
+	events   []models.AlarmEvent
+	deadline time.Time
+}
+
+// ruleWindow counts the events accepted for one rule in the current rate-limit window.
+type ruleWindow struct {
+	start time.Time
+	count int
+}
+
+// webhook is a configured webhook together with its grouping and rate-limit state.
+type webhook struct {
+	config    models.Webhook
+	template  *template.Template
+	groups    map[string]*group
+	rules     map[string]*ruleWindow
+	delivered map[string]string // rule|device → state of the last delivered event, guarded by Notifier.mu
+}
+
+// Notifier delivers alarm events to webhooks.
+type Notifier struct {
+	mu       sync.Mutex
+	webhooks []*webhook
+	logMu    sync.Mutex
+	logFile  *os.File
+	wg       sync.WaitGroup
+}
+
+// New creates a notifier for the configured webhooks.
+func New(config models.NotifyConfig) (*Notifier, error) {
+	n := &Notifier{}
+
+	for _, hook := range config.Webhooks {
+		if hook.URL == "" {
+			return nil, fmt.Errorf("webhook %q has no url", hook.Name)
+		}
+		text := hook.Template
+		if text == "" {
+			text = defaultTemplate
+		}
+		tmpl, err := template.New(hook.Name).Funcs(template.FuncMap{"json": toJSON}).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template of webhook %q: %v", hook.Name, err)
+		}
+		if hook.MaxAttempts <= 0 {
+			hook.MaxAttempts = 3
+		}
+		if hook.BackoffMs <= 0 {
+			hook.BackoffMs = 1000
+		}
+		n.webhooks = append(n.webhooks, &webhook{
+			config:    hook,
+			template:  tmpl,
+			groups:    make(map[string]*group),
+			rules:     make(map[string]*ruleWindow),
+			delivered: make(map[string]string),
+		})
+	}
+
+	if config.DeliveryLog != "" {
+		file, err := os.OpenFile(config.DeliveryLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open delivery log: %v", err)
+		}
+		n.logFile = file
+	}
+
+	return n, nil
+}
+
+// Notify queues event on every webhook that accepts it. It does not block on delivery.
+func (n *Notifier) Notify(event models.AlarmEvent) {
+	now := time.Now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, hook := range n.webhooks {
+		if !hook.accepts(event, now) {
+			continue
+		}
+
+		g, ok := hook.groups[event.Device]
+		if !ok {
+			g = &group{
+				device:   event.Device,
+				name:     event.Name,
+				deadline: now.Add(time.Duration(hook.config.GroupSeconds) * time.Second),
+			}
+			hook.groups[event.Device] = g
+		}
+		g.add(event)
+	}
+}
+
+// accepts applies the state and severity filters, de-duplication and the per-rule rate limit.
+func (w *webhook) accepts(event models.AlarmEvent, now time.Time) bool {
+	if !contains(w.config.States, event.State) || !contains(w.config.Severities, event.Severity) {
+		return false
+	}
+
+	// Skip an event that repeats the state already delivered for this rule and device
+	if w.delivered[event.Rule+"|"+event.Device] == event.State {
+		return false
+	}
+
+	if w.config.MaxPerRule > 0 {
+		window, ok := w.rules[event.Rule]
+		if !ok || now.Sub(window.start) >= time.Duration(w.config.RuleWindowSeconds)*time.Second {
+			window = &ruleWindow{start: now}
+			w.rules[event.Rule] = window
+		}
+		if window.count >= w.config.MaxPerRule {
+			log.Printf("webhook %s: rate limit of rule %q reached, dropping %s event on %s", w.config.Name, event.Rule, event.State, event.Device)
+			return false
+		}
+		window.count++
+	}
+	return true
+}
+
+// add appends event to the group, replacing a queued event of the same rule so that only the latest state is sent.
+func (g *group) add(event models.AlarmEvent) {
+	for i, queued := range g.events {
+		if queued.Rule == event.Rule && queued.Measurement == event.Measurement {
+			g.events[i] = event
+			return
+		}
+	}
+	g.events = append(g.events, event)
+}
+
+// Run delivers every group whose window has ended until ctx is done. It then delivers the groups
+// still pending and waits up to shutdownTimeout for every delivery to finish.
+// Deliveries do not run on ctx, so that they are not cut off when it is done.
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	deliveryCtx, cancelDeliveries := context.WithCancel(context.Background())
+	defer cancelDeliveries()
+
+	for {
+		select {
+		case <-ctx.Done():
+			n.flush(deliveryCtx, time.Now(), true)
+			timer := time.AfterFunc(shutdownTimeout, cancelDeliveries)
+			n.wg.Wait()
+			timer.Stop()
+			if n.logFile != nil {
+				n.logFile.Close()
+			}
+			return
+		case now := <-ticker.C:
+			n.flush(deliveryCtx, now, false)
+		}
+	}
+}
+
+// flush starts the delivery of every group whose deadline has passed, or of every group with all.
+func (n *Notifier) flush(ctx context.Context, now time.Time, all bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, hook := range n.webhooks {
+		for device, g := range hook.groups {
+			if !all && now.Before(g.deadline) {
+				continue
+			}
+			delete(hook.groups, device)
+
+			n.wg.Add(1)
+			go func(hook *webhook, g *group) {
+				defer n.wg.Done()
+				n.deliver(ctx, hook, g)
+			}(hook, g)
+		}
+	}
+}
+
+// deliver renders the group's message and posts it, retrying with exponential backoff and jitter.
+func (n *Notifier) deliver(ctx context.Context, hook *webhook, g *group) {
+	message := models.WebhookMessage{
+		Webhook: hook.config.Name,
+		Device:  g.device,
+		Name:    g.name,
+		Events:  g.events,
+		SentAt:  time.Now(),
+	}
+
+	var body bytes.Buffer
+	if err := hook.template.Execute(&body, message); err != nil {
+		n.record(hook, g, 0, 0, fmt.Errorf("failed to render template: %v", err))
+		return
+	}
+	if !json.Valid(body.Bytes()) {
+		n.record(hook, g, 0, 0, fmt.Errorf("template did not render valid JSON"))
+		return
+	}
+
+	policy := retry.New("webhook/"+hook.config.Name, models.RetryPolicy{MaxAttempts: hook.config.MaxAttempts, InitialBackoffMs: hook.config.BackoffMs})
+	policy.OnAttempt = func(attempt, status int, err error) {
+		n.record(hook, g, attempt, status, err)
+	}
+	resp, err := policy.Do(ctx, httpClient, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", hook.config.URL, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for key, value := range hook.config.Headers {
+			req.Header.Set(key, value)
+		}
+		return req, nil
+	})
+	if err != nil {
+		log.Printf("webhook %s: giving up on %d events of %s: %v", hook.config.Name, len(g.events), g.device, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Only events that reached the webhook suppress repeats of their state
+	n.mu.Lock()
+	for _, event := range g.events {
+		hook.delivered[event.Rule+"|"+event.Device] = event.State
+	}
+	n.mu.Unlock()
+}
+
+// record appends the outcome of a delivery attempt to the delivery log.
+func (n *Notifier) record(hook *webhook, g *group, attempt, status int, err error) {
+	delivery := models.Delivery{
+		Time:    time.Now(),
+		Webhook: hook.config.Name,
+		Device:  g.device,
+		Events:  len(g.events),
+		Attempt: attempt,
+		Status:  status,
+		Success: err == nil,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+		log.Printf("webhook %s: delivery for %s failed (attempt %d/%d): %v", hook.config.Name, g.device, attempt, hook.config.MaxAttempts, err)
+	}
+
+	if n.logFile == nil {
+		return
+	}
+	n.logMu.Lock()
+	defer n.logMu.Unlock()
+	if err := json.NewEncoder(n.logFile).Encode(delivery); err != nil {
+		log.Printf("failed to write delivery log: %v", err)
+	}
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// contains reports whether value is in list. An empty list contains everything.
+func contains(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
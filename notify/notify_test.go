@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/tool/models"
+)
+
+// receiver is a webhook endpoint failing the first fail requests.
+type receiver struct {
+	fail     int32
+	requests atomic.Int32
+
+	mu       sync.Mutex
+	messages []models.WebhookMessage
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.requests.Add(1) <= r.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var message models.WebhookMessage
+	if err := json.NewDecoder(req.Body).Decode(&message); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	r.messages = append(r.messages, message)
+	r.mu.Unlock()
+}
+
+func (r *receiver) received() []models.WebhookMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]models.WebhookMessage(nil), r.messages...)
+}
+
+func newNotifier(t *testing.T, url string, groupSeconds int) *Notifier {
+	t.Helper()
+	n, err := New(models.NotifyConfig{Webhooks: []models.Webhook{{
+		Name:         "test",
+		URL:          url,
+		GroupSeconds: groupSeconds,
+		MaxAttempts:  1,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func raise(device string) models.AlarmEvent {
+	return models.AlarmEvent{Rule: "overload", Measurement: "kw", Device: device, Name: device, Severity: "major", State: "raise"}
+}
+
+func TestFailedDeliveryDoesNotSuppressRepeats(t *testing.T) {
+	r := &receiver{fail: 1}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	n := newNotifier(t, srv.URL, 0)
+	ctx := context.Background()
+
+	n.Notify(raise("equipment1"))
+	n.flush(ctx, time.Now(), false)
+	n.wg.Wait()
+	if got := len(r.received()); got != 0 {
+		t.Fatalf("received %d messages from a failed delivery", got)
+	}
+
+	// The failed raise was never delivered, so the same state must go out again
+	n.Notify(raise("equipment1"))
+	n.flush(ctx, time.Now(), false)
+	n.wg.Wait()
+	if got := len(r.received()); got != 1 {
+		t.Fatalf("received %d messages after the repeat, want 1", got)
+	}
+
+	// Now that it was delivered, a repeat of the same state is suppressed
+	n.Notify(raise("equipment1"))
+	n.flush(ctx, time.Now(), false)
+	n.wg.Wait()
+	if got := len(r.received()); got != 1 {
+		t.Fatalf("received %d messages after a delivered repeat, want 1", got)
+	}
+}
+
+func TestRunFlushesPendingGroupsOnShutdown(t *testing.T) {
+	r := &receiver{}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// The window is far longer than the run, only the shutdown flush can deliver
+	n := newNotifier(t, srv.URL, 300)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+
+	n.Notify(raise("equipment1"))
+	n.Notify(raise("equipment2"))
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after shutdown")
+	}
+	if got := len(r.received()); got != 2 {
+		t.Fatalf("received %d messages on shutdown, want 2", got)
+	}
+}
+
+func TestShutdownBoundsHangingDeliveries(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	previous := shutdownTimeout
+	shutdownTimeout = 200 * time.Millisecond
+	defer func() { shutdownTimeout = previous }()
+
+	n := newNotifier(t, srv.URL, 300)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+	n.Notify(raise("equipment1"))
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run waited for a hanging delivery past the shutdown timeout")
+	}
+}
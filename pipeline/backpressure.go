@@ -0,0 +1,443 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/tool/metrics"
+	"example.com/tool/models"
+)
+
+// Backpressure policies, applied once the queue reaches its high-water mark.
+const (
+	PolicySlow       = "slow"        // block the dispatcher and pause the fetch schedulers until the queue is back at its low-water mark
+	PolicyDropOldest = "drop-oldest" // discard the oldest queued sample for every new one
+	PolicyDropNewest = "drop-newest" // discard new samples
+	PolicySpill      = "spill"       // write new samples to disk and queue them again once the queue is back at its low-water mark
+)
+
+// Queue takes the samples forwarded by Dispatch.
+type Queue interface {
+	Put(data models.SentData)
+	Close()
+}
+
+// Backpressure is the Queue in front of the message queue of the savers. It applies its policy while the
+// queue is above its high-water mark and reports the fill level to metrics.
+// Its methods are safe to call on a nil *Backpressure, which never asks to slow down.
+type Backpressure struct {
+	policy    string
+	queue     chan models.SentData
+	high, low int
+	above     atomic.Bool // reached high water and not yet back at low water
+	spillDir  string
+	hold      func() bool // set by HoldRefill
+
+	mu     sync.Mutex       // serializes Put, Divert, refills and Close around spill
+	spill  *spillFile       // opened up front for PolicySpill, on the first Divert otherwise
+	unsent *models.SentData // read back from spill but not yet queued
+	closed bool
+}
+
+// NewBackpressure creates the Backpressure of queue with the given policy and water marks.
+// Samples spilled or diverted by a previous run in spillDir are queued again before new ones.
+func NewBackpressure(queue chan models.SentData, policy string, high, low int, spillDir string) (*Backpressure, error) {
+	switch policy {
+	case PolicySlow, PolicyDropOldest, PolicyDropNewest, PolicySpill:
+	default:
+		return nil, fmt.Errorf("unknown backpressure policy: %s", policy)
+	}
+	if high <= 0 || high > cap(queue) {
+		high = cap(queue)
+	}
+	if low < 0 || low >= high {
+		low = high / 2
+	}
+
+	b := &Backpressure{policy: policy, queue: queue, high: high, low: low, spillDir: spillDir}
+	_, statErr := os.Stat(b.spillPath())
+	if policy == PolicySpill || statErr == nil {
+		spill, err := openSpillFile(b.spillPath())
+		if err != nil {
+			return nil, err
+		}
+		b.spill = spill
+		if spill.pending > 0 {
+			log.Printf("queueing %d samples spilled by the previous run", spill.pending)
+		}
+	}
+
+	metrics.QueueHighWater.Set(float64(high))
+	metrics.QueueLowWater.Set(float64(low))
+	return b, nil
+}
+
+func (b *Backpressure) spillPath() string {
+	return filepath.Join(b.spillDir, "spill.jsonl")
+}
+
+// Put queues data, applying the policy above the high-water mark.
+func (b *Backpressure) Put(data models.SentData) {
+	b.put(data, true)
+}
+
+// TryPut queues data like Put but never waits for room in the queue, e.g. for samples that must not
+// hold up shutdown once the savers have stopped. It reports whether data was queued or spilled.
+func (b *Backpressure) TryPut(data models.SentData) bool {
+	return b.put(data, false)
+}
+
+// put applies the policy to data and reports whether it was queued or spilled. With wait set it waits
+// for room in a full queue, otherwise it gives up.
+func (b *Backpressure) put(data models.SentData, wait bool) bool {
+	b.update()
+
+	switch b.policy {
+	case PolicySlow:
+		return b.send(data, wait)
+
+	case PolicyDropNewest:
+		if len(b.queue) >= b.high {
+			metrics.QueueDropped.WithLabelValues(b.policy).Inc()
+			return false
+		}
+		return b.send(data, wait)
+
+	case PolicyDropOldest:
+		for len(b.queue) >= b.high {
+			select {
+			case <-b.queue:
+				metrics.QueueDropped.WithLabelValues(b.policy).Inc()
+			default:
+			}
+		}
+		return b.send(data, wait)
+
+	case PolicySpill:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		// Once spilling, new samples queue up behind the spilled ones to keep their order
+		if b.spill.pending == 0 && len(b.queue) < b.high {
+			return b.send(data, wait)
+		}
+		if err := b.spill.push(data); err != nil {
+			log.Printf("failed to spill sample of %s, queueing it: %v", data.Devices, err)
+			return b.send(data, wait)
+		}
+		return true
+	}
+	return false
+}
+
+// send queues data, waiting for room with wait set, and reports whether it was queued.
+func (b *Backpressure) send(data models.SentData, wait bool) bool {
+	if wait {
+		b.queue <- data
+		return true
+	}
+	select {
+	case b.queue <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// update tracks the water marks: above is set at high water and cleared again at low water.
+func (b *Backpressure) update() {
+	length := len(b.queue)
+	if length >= b.high {
+		b.above.Store(true)
+	} else if length <= b.low {
+		b.above.Store(false)
+	}
+}
+
+// Divert writes the samples of a batch a sink could not take to the spill file, whatever the policy.
+// They are queued again like spilled samples, once refills are no longer held back, see HoldRefill.
+// Batches diverted after Close, by savers flushing on shutdown, are kept for the next run.
+func (b *Backpressure) Divert(batch models.SentDataByBatched) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.spill == nil || b.closed {
+		spill, err := openSpillFile(b.spillPath())
+		if err != nil {
+			return err
+		}
+		b.spill = spill
+	}
+	if b.closed {
+		defer func() {
+			if err := b.spill.close(); err != nil {
+				log.Printf("failed to close the spill file: %v", err)
+			}
+		}()
+	}
+	for i := range batch.Timestamps {
+		data := models.SentData{
+			Timestamps:       batch.Timestamps[i],
+			MeasurementsList: batch.MeasurementsList[i],
+			DataTypesList:    batch.DataTypesList[i],
+			ValuesList:       batch.ValuesList[i],
+			IsAligned:        batch.IsAligned,
+			Devices:          batch.Devices[i],
+		}
+		if err := b.spill.push(data); err != nil {
+			return fmt.Errorf("failed to divert %d samples: %v", len(batch.Timestamps)-i, err)
+		}
+	}
+	return b.spill.writer.Flush()
+}
+
+// HoldRefill keeps spilled and diverted samples on disk while hold returns true, e.g. while the
+// breaker of the sink they were diverted from is open. It must be called before Run.
+func (b *Backpressure) HoldRefill(hold func() bool) {
+	b.hold = hold
+}
+
+// Paused reports whether fetch schedulers should hold back new sweeps. That is only the case with
+// PolicySlow, from reaching the high-water mark until the queue is back at its low-water mark.
+func (b *Backpressure) Paused() bool {
+	if b == nil {
+		return false
+	}
+	return b.policy == PolicySlow && b.above.Load()
+}
+
+// Run tracks the water marks while the savers drain the queue, queues spilled samples again
+// once the queue is back at its low-water mark and reports the fill level, until ctx is done.
+func (b *Backpressure) Run(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		b.update()
+		if !b.above.Load() && (b.hold == nil || !b.hold()) {
+			b.refill()
+		}
+
+		metrics.QueueLength.Set(float64(len(b.queue)))
+		if b.above.Load() {
+			metrics.QueueAboveHighWater.Set(1)
+		} else {
+			metrics.QueueAboveHighWater.Set(0)
+		}
+		metrics.QueueSpilled.Set(float64(b.spilled()))
+	}
+}
+
+// refill moves spilled samples back to the queue up to its high-water mark.
+func (b *Backpressure) refill() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for !b.closed && b.spill != nil && len(b.queue) < b.high {
+		if b.unsent == nil {
+			if b.spill.pending == 0 {
+				return
+			}
+			data, err := b.spill.pop()
+			if err != nil {
+				log.Printf("failed to read spilled samples, dropping %d: %v", b.spill.pending, err)
+				metrics.QueueDropped.WithLabelValues(b.policy).Add(float64(b.spill.pending))
+				b.spill.reset()
+				return
+			}
+			b.unsent = &data
+		}
+		// Only PolicySpill holds mu in Put, with the others the dispatcher may fill the queue meanwhile
+		select {
+		case b.queue <- *b.unsent:
+			b.unsent = nil
+		default:
+			return
+		}
+	}
+}
+
+func (b *Backpressure) spilled() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.spill == nil {
+		return 0
+	}
+	if b.unsent != nil {
+		return b.spill.pending + 1
+	}
+	return b.spill.pending
+}
+
+// Close closes the queue once Dispatch is done. Samples still spilled stay on disk for the next run.
+func (b *Backpressure) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.spill != nil {
+		if b.unsent != nil {
+			if err := b.spill.push(*b.unsent); err != nil {
+				log.Printf("failed to keep a spilled sample of %s: %v", b.unsent.Devices, err)
+			}
+			b.unsent = nil
+		}
+		if b.spill.pending > 0 {
+			log.Printf("%d spilled samples are kept for the next run", b.spill.pending)
+		}
+		if err := b.spill.close(); err != nil {
+			log.Printf("failed to close the spill file: %v", err)
+		}
+	}
+	b.closed = true
+	close(b.queue)
+}
+
+// spillFile is a first-in first-out queue of samples in a JSON lines file.
+type spillFile struct {
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	reader  *bufio.Reader
+	offset  int64 // read position in the file
+	pending int
+}
+
+// openSpillFile opens the spill file at path, counting the samples a previous run left in it.
+func openSpillFile(path string) (*spillFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %v", err)
+	}
+	// A temporary file left by a crash while closing is incomplete, the spill file is still whole
+	stale, _ := filepath.Glob(filepath.Join(filepath.Dir(path), spillTempPattern))
+	for _, name := range stale {
+		os.Remove(name)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill file: %v", err)
+	}
+
+	s := &spillFile{path: path, file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		s.pending++
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read spill file: %v", err)
+	}
+
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read spill file: %v", err)
+	}
+	s.writer = bufio.NewWriter(io.NewOffsetWriter(file, end))
+	s.reader = bufio.NewReader(io.NewSectionReader(file, 0, 1<<62))
+	return s, nil
+}
+
+func (s *spillFile) push(data models.SentData) error {
+	line, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+func (s *spillFile) pop() (models.SentData, error) {
+	var data models.SentData
+	if err := s.writer.Flush(); err != nil {
+		return data, err
+	}
+	line, err := s.reader.ReadBytes('\n')
+	if err == io.EOF {
+		// The reader may still hold the end of file it reached before the last samples were pushed,
+		// reading again continues with them
+		var rest []byte
+		rest, err = s.reader.ReadBytes('\n')
+		line = append(line, rest...)
+	}
+	if err != nil {
+		return data, err
+	}
+	s.offset += int64(len(line))
+	if err := json.Unmarshal(line, &data); err != nil {
+		return data, err
+	}
+
+	s.pending--
+	if s.pending == 0 {
+		s.reset()
+	}
+	return data, nil
+}
+
+// reset empties the file once every spilled sample has been read.
+func (s *spillFile) reset() {
+	s.pending, s.offset = 0, 0
+	if err := s.file.Truncate(0); err != nil {
+		log.Printf("failed to truncate the spill file: %v", err)
+	}
+	s.writer.Reset(io.NewOffsetWriter(s.file, 0))
+	s.reader.Reset(io.NewSectionReader(s.file, 0, 1<<62))
+}
+
+// close keeps the unread samples for the next run. They are copied to a temporary file that then
+// replaces the spill file, so a crash while closing leaves either the old or the new file complete.
+func (s *spillFile) close() error {
+	if err := s.writer.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	if s.pending == 0 {
+		s.file.Close()
+		return os.Remove(s.path)
+	}
+	if s.offset == 0 {
+		return s.file.Close()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), spillTempPattern)
+	if err != nil {
+		s.file.Close()
+		return err
+	}
+	_, err = io.Copy(tmp, io.NewSectionReader(s.file, s.offset, 1<<62))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	s.file.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// spillTempPattern names the temporary files of spillFile.close.
+const spillTempPattern = "spill-*.tmp"
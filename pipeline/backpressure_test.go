@@ -0,0 +1,229 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/tool/models"
+)
+
+func sample(timestamp int64) models.SentData {
+	return models.SentData{Timestamps: timestamp, MeasurementsList: []string{"kw"}, DataTypesList: []string{"DOUBLE"}, ValuesList: []float64{1}, IsAligned: true, Devices: "root.test.equipment1"}
+}
+
+// drain reads up to n samples from queue without blocking and returns their timestamps.
+func drain(queue chan models.SentData, n int) []int64 {
+	var timestamps []int64
+	for len(timestamps) < n {
+		select {
+		case data := <-queue:
+			timestamps = append(timestamps, data.Timestamps)
+		default:
+			return timestamps
+		}
+	}
+	return timestamps
+}
+
+func newTestBackpressure(t *testing.T, policy, dir string) (*Backpressure, chan models.SentData) {
+	t.Helper()
+	queue := make(chan models.SentData, 10)
+	b, err := NewBackpressure(queue, policy, 4, 2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, queue
+}
+
+func TestBackpressureDropNewest(t *testing.T) {
+	b, queue := newTestBackpressure(t, PolicyDropNewest, t.TempDir())
+	for i := int64(0); i < 10; i++ {
+		b.Put(sample(i))
+	}
+	if got := drain(queue, 10); !reflect.DeepEqual(got, []int64{0, 1, 2, 3}) {
+		t.Errorf("queued %v", got)
+	}
+}
+
+func TestBackpressureDropOldest(t *testing.T) {
+	b, queue := newTestBackpressure(t, PolicyDropOldest, t.TempDir())
+	for i := int64(0); i < 10; i++ {
+		b.Put(sample(i))
+	}
+	if got := drain(queue, 10); !reflect.DeepEqual(got, []int64{6, 7, 8, 9}) {
+		t.Errorf("queued %v", got)
+	}
+}
+
+func TestBackpressureSlow(t *testing.T) {
+	b, queue := newTestBackpressure(t, PolicySlow, t.TempDir())
+	for i := int64(0); i < 4; i++ {
+		b.Put(sample(i))
+	}
+	b.Put(sample(4)) // the queue holds more than the high-water mark, nothing is dropped
+	if !b.Paused() {
+		t.Fatal("not paused at the high-water mark")
+	}
+
+	drain(queue, 2)
+	b.update()
+	if !b.Paused() {
+		t.Fatal("resumed above the low-water mark")
+	}
+	drain(queue, 1)
+	b.update()
+	if b.Paused() {
+		t.Fatal("still paused at the low-water mark")
+	}
+	if got := drain(queue, 10); !reflect.DeepEqual(got, []int64{3, 4}) {
+		t.Errorf("queued %v", got)
+	}
+
+	var nilBackpressure *Backpressure
+	if nilBackpressure.Paused() {
+		t.Error("nil backpressure paused")
+	}
+}
+
+func TestBackpressureSpillKeepsOrder(t *testing.T) {
+	b, queue := newTestBackpressure(t, PolicySpill, t.TempDir())
+	for i := int64(0); i < 10; i++ {
+		b.Put(sample(i))
+	}
+	if spilled := b.spilled(); spilled != 6 {
+		t.Fatalf("spilled %d samples, want 6", spilled)
+	}
+
+	var got []int64
+	got = append(got, drain(queue, 3)...)
+	b.refill()
+	// While samples are spilled, new ones queue up behind them
+	b.Put(sample(10))
+	for len(got) < 11 {
+		drained := drain(queue, 10)
+		if len(drained) == 0 && b.spilled() == 0 {
+			break
+		}
+		got = append(got, drained...)
+		b.refill()
+	}
+
+	want := []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %v, want %v", got, want)
+	}
+	// Emptied, new samples go straight to the queue
+	b.Put(sample(11))
+	if got := drain(queue, 10); !reflect.DeepEqual(got, []int64{11}) {
+		t.Errorf("queued %v after the spill emptied", got)
+	}
+}
+
+func TestBackpressureCloseKeepsSpilledSamples(t *testing.T) {
+	dir := t.TempDir()
+	b, queue := newTestBackpressure(t, PolicySpill, dir)
+	for i := int64(0); i < 10; i++ {
+		b.Put(sample(i))
+	}
+	// Read part of the spill back, so that close has to drop the consumed start of the file
+	drain(queue, 3)
+	b.refill()
+	if got := drain(queue, 10); !reflect.DeepEqual(got, []int64{3, 4, 5, 6}) {
+		t.Fatalf("queued %v", got)
+	}
+	b.Close()
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "spill.jsonl" {
+		t.Fatalf("spill directory holds %v", entries)
+	}
+
+	// The next run queues the rest first, whatever its policy
+	b, queue = newTestBackpressure(t, PolicyDropNewest, dir)
+	b.refill()
+	if got := drain(queue, 10); !reflect.DeepEqual(got, []int64{7, 8, 9}) {
+		t.Errorf("next run queued %v", got)
+	}
+	b.Close()
+	if _, err := os.Stat(filepath.Join(dir, "spill.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("emptied spill file kept: %v", err)
+	}
+}
+
+func TestBackpressureDivertHeldUntilReleased(t *testing.T) {
+	b, queue := newTestBackpressure(t, PolicySlow, t.TempDir())
+	var held atomic.Bool
+	held.Store(true)
+	b.HoldRefill(held.Load)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+
+	batch := models.SentDataByBatched{
+		Timestamps:       []int64{1, 2},
+		MeasurementsList: [][]string{{"kw"}, {"kw"}},
+		DataTypesList:    [][]string{{"DOUBLE"}, {"DOUBLE"}},
+		ValuesList:       [][]float64{{1}, {2}},
+		Devices:          []string{"root.test.equipment1", "root.test.equipment1"},
+	}
+	if err := b.Divert(batch); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if len(queue) != 0 {
+		t.Fatalf("queued %d diverted samples while held", len(queue))
+	}
+
+	held.Store(false)
+	var got []int64
+	for deadline := time.Now().Add(2 * time.Second); len(got) < 2 && time.Now().Before(deadline); {
+		select {
+		case data := <-queue:
+			got = append(got, data.Timestamps)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if !reflect.DeepEqual(got, []int64{1, 2}) {
+		t.Errorf("queued %v", got)
+	}
+}
+
+func TestBackpressureTryPutNeverWaits(t *testing.T) {
+	queue := make(chan models.SentData, 2)
+	b, err := NewBackpressure(queue, PolicySlow, 2, 1, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.TryPut(sample(0)) || !b.TryPut(sample(1)) {
+		t.Fatal("samples not queued with room in the queue")
+	}
+
+	// Nobody drains the queue, as after the savers stopped
+	done := make(chan bool)
+	go func() { done <- b.TryPut(sample(2)) }()
+	select {
+	case queued := <-done:
+		if queued {
+			t.Error("queued a sample into a full queue")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("TryPut waited for room in the queue")
+	}
+
+	// With spill it goes to disk instead
+	b, queue = newTestBackpressure(t, PolicySpill, t.TempDir())
+	for i := int64(0); i < 10; i++ {
+		if !b.TryPut(sample(i)) {
+			t.Fatalf("sample %d neither queued nor spilled", i)
+		}
+	}
+	if len(queue) != 4 || b.spilled() != 6 {
+		t.Errorf("queued %d and spilled %d samples", len(queue), b.spilled())
+	}
+}
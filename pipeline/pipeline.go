@@ -0,0 +1,36 @@
+package pipeline
+
+import (
+	"example.com/tool/models"
+)
+
+// Observer is notified of every decoded sample between format and saveData.
+// Observe is called from the dispatch goroutine and must not block.
+type Observer interface {
+	Observe(data models.SentData)
+}
+
+// Closer is implemented by observers that must learn when no more samples will follow.
+type Closer interface {
+	Close()
+}
+
+// Dispatch reads decoded samples from in, hands each of them to the observers and puts it in out.
+// Once in is closed and drained, Dispatch closes out and every observer implementing Closer, and returns.
+func Dispatch(in <-chan models.SentData, out Queue, observers ...Observer) {
+	defer func() {
+		out.Close()
+		for _, observer := range observers {
+			if closer, ok := observer.(Closer); ok {
+				closer.Close()
+			}
+		}
+	}()
+
+	for data := range in {
+		for _, observer := range observers {
+			observer.Observe(data)
+		}
+		out.Put(data)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	initSetting "example.com/tool/init"
+	"example.com/tool/modbus"
+	"example.com/tool/models"
+	"example.com/tool/store"
+)
+
+// functionNames names the Modbus register tables by read function code.
+var functionNames = map[byte]string{
+	modbus.ReadCoils:            "coil",
+	modbus.ReadDiscreteInputs:   "discrete",
+	modbus.ReadHoldingRegisters: "holding",
+	modbus.ReadInputRegisters:   "input",
+}
+
+// runPlan prints the Modbus read plan of a point profile.
+//
+//	go run . plan [-points ./points.json | -profile default] [-gap 8]
+func runPlan(args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	configPath := flags.String("config", "./config.json", "config file")
+	pointsPath := flags.String("points", "./points.json", "point profile file")
+	profile := flags.String("profile", "", "plan this profile of the metadata store instead of the points file")
+	gap := flags.Int("gap", -1, "gap tolerance, defaults to modbusGapTolerance of the config")
+	flags.Parse(args)
+
+	config, err := initSetting.ReadConfig(*configPath)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	if *gap < 0 {
+		*gap = config.ModbusGapTolerance
+	}
+
+	var points models.ConfigPoint
+	if *profile != "" {
+		metaStore, err := store.Open(config.StoreDialect, config.StoreDSN)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		profiles, err := metaStore.Profiles()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		var ok bool
+		if points, ok = profiles[*profile]; !ok {
+			log.Fatalf("unknown point profile: %s", *profile)
+		}
+	} else {
+		loaded, err := initSetting.ReadPonit(*pointsPath)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		points = *loaded
+	}
+
+	blocks, err := modbus.Plan(points, *gap)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	naive, err := modbus.Plan(points, modbus.NoCoalescing)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+
+	registers := 0
+	for _, block := range blocks {
+		registers += int(block.Count)
+	}
+	fmt.Printf("gap tolerance %d: %d requests reading %d addresses (%d addresses wanted)\n\n", *gap, len(blocks), registers, len(naive))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FC\tTABLE\tSTART\tCOUNT\tPOINTS")
+	for _, block := range blocks {
+		fmt.Fprintf(w, "%02d\t%s\t%d\t%d\t%s\n", block.Function, functionNames[block.Function], block.Start, block.Count, strings.Join(blockPoints(points, block), ","))
+	}
+	w.Flush()
+}
+
+// blockPoints returns the names of the points read by block.
+func blockPoints(points models.ConfigPoint, block modbus.Block) []string {
+	var names []string
+	for key, point := range points.ChannelSetting {
+		function, err := modbus.Function(point.Register)
+		if err != nil || function != block.Function {
+			continue
+		}
+		for _, name := range point.Value {
+			address, err := modbus.ParseAddress(name)
+			if err == nil && address >= block.Start && int(address) < int(block.Start)+int(block.Count) {
+				names = append(names, key)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,240 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"example.com/tool/getData"
+	"example.com/tool/models"
+	"example.com/tool/store"
+)
+
+var (
+	ErrNotFound = errors.New("device not found")
+	ErrExists   = errors.New("device already exists")
+	ErrInvalid  = errors.New("invalid device")
+)
+
+// Registry is the in-memory view of the devices in the metadata store.
+// Changes made through it are written to the store and picked up by the fetch scheduler on its next sweep.
+type Registry struct {
+	mu       sync.RWMutex
+	store    *store.Store
+	host     string
+	devices  map[string]models.Device
+	profiles map[string]models.ConfigPoint
+	targets  map[string][]models.Target // enabled targets by group, rebuilt on every change
+	changed  chan struct{}
+	deleted  []func(name string)
+}
+
+// New loads the devices and point profiles of the store.
+func New(metaStore *store.Store, host string) (*Registry, error) {
+	devices, err := metaStore.AllDevices()
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := metaStore.Profiles()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{
+		store:    metaStore,
+		host:     host,
+		devices:  make(map[string]models.Device, len(devices)),
+		profiles: profiles,
+		changed:  make(chan struct{}, 1),
+	}
+	for _, device := range devices {
+		r.devices[device.Name] = device
+	}
+	if err := r.rebuild(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Groups returns the names of every group with at least one device.
+func (r *Registry) Groups() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	groups := make([]string, 0, len(r.targets))
+	for group := range r.targets {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Targets returns the enabled targets of a group. The returned slice must not be modified.
+func (r *Registry) Targets(group string) []models.Target {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.targets[group]
+}
+
+// Changed is signalled after every change of the registry.
+func (r *Registry) Changed() <-chan struct{} {
+	return r.changed
+}
+
+// Devices returns every registered device sorted by name.
+func (r *Registry) Devices() []models.Device {
+	r.mu.RLock()
+	devices := make([]models.Device, 0, len(r.devices))
+	for _, device := range r.devices {
+		devices = append(devices, device)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices
+}
+
+// Get returns the named device.
+func (r *Registry) Get(name string) (models.Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	device, ok := r.devices[name]
+	return device, ok
+}
+
+// Create registers a new device on behalf of actor.
+func (r *Registry) Create(device models.Device, actor string) (models.Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.devices[device.Name]; ok {
+		return models.Device{}, fmt.Errorf("%w: %s", ErrExists, device.Name)
+	}
+	device.ID = 0
+	if err := r.validate(device); err != nil {
+		return models.Device{}, err
+	}
+
+	if err := r.store.SaveDevice(&device, actor, "create"); err != nil {
+		return models.Device{}, err
+	}
+	r.devices[device.Name] = device
+	return device, r.commit()
+}
+
+// Update applies patch to the named device on behalf of actor.
+func (r *Registry) Update(name string, patch models.DevicePatch, actor string) (models.Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	device, ok := r.devices[name]
+	if !ok {
+		return models.Device{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if patch.Group != nil {
+		device.Group = *patch.Group
+	}
+	if patch.Profile != nil {
+		device.Profile = *patch.Profile
+	}
+	if patch.Location != nil {
+		device.Location = *patch.Location
+	}
+	if patch.Port != nil {
+		device.Port = *patch.Port
+	}
+	if patch.Enabled != nil {
+		device.Enabled = *patch.Enabled
+	}
+	if err := r.validate(device); err != nil {
+		return models.Device{}, err
+	}
+
+	if err := r.store.SaveDevice(&device, actor, "update"); err != nil {
+		return models.Device{}, err
+	}
+	r.devices[name] = device
+	return device, r.commit()
+}
+
+// OnDelete calls fn with the name of every device deleted from now on, e.g. to drop its state.
+// fn is called with the registry locked and must not use it.
+func (r *Registry) OnDelete(fn func(name string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deleted = append(r.deleted, fn)
+}
+
+// Delete removes the named device on behalf of actor.
+func (r *Registry) Delete(name, actor string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	device, ok := r.devices[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	if err := r.store.DeleteDevice(device, actor); err != nil {
+		return err
+	}
+	delete(r.devices, name)
+	for _, fn := range r.deleted {
+		fn(name)
+	}
+	return r.commit()
+}
+
+// validate checks a device before it is written. The caller must hold mu.
+func (r *Registry) validate(device models.Device) error {
+	if device.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if device.Port <= 0 {
+		return fmt.Errorf("%w: port is required", ErrInvalid)
+	}
+	if device.Protocol != "" && device.Protocol != "http" && device.Protocol != "modbus" {
+		return fmt.Errorf("%w: unknown protocol %q", ErrInvalid, device.Protocol)
+	}
+	if _, ok := r.profiles[device.Profile]; !ok {
+		return fmt.Errorf("%w: unknown point profile %q", ErrInvalid, device.Profile)
+	}
+	return nil
+}
+
+// commit rebuilds the targets and notifies the scheduler. The caller must hold mu.
+func (r *Registry) commit() error {
+	if err := r.rebuild(); err != nil {
+		return err
+	}
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// rebuild recomputes the enabled targets of every group. The caller must hold mu or own r.
+func (r *Registry) rebuild() error {
+	groups := make(map[string][]models.Device)
+	for _, device := range r.devices {
+		if device.Enabled {
+			groups[device.Group] = append(groups[device.Group], device)
+		}
+	}
+
+	targets := make(map[string][]models.Target, len(groups))
+	for group, devices := range groups {
+		sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+		list, err := getData.DeviceTargets(r.host, devices, r.profiles)
+		if err != nil {
+			return err
+		}
+		targets[group] = list
+	}
+
+	r.targets = targets
+	return nil
+}
@@ -0,0 +1,182 @@
+package registry
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"example.com/tool/models"
+	"example.com/tool/store"
+)
+
+// newTestRegistry opens a registry over a SQLite store with the point profile "default".
+func newTestRegistry(t *testing.T) (*Registry, *store.Store) {
+	t.Helper()
+	metaStore, err := store.Open("sqlite", filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := metaStore.SaveProfile("default", models.ConfigPoint{CommonSetting: models.CommonSetting{Company: "test"}}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(metaStore, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, metaStore
+}
+
+// changed reports whether the registry signalled a change since the last call.
+func changed(r *Registry) bool {
+	select {
+	case <-r.Changed():
+		return true
+	default:
+		return false
+	}
+}
+
+func names(targets []models.Target) []string {
+	list := []string{}
+	for _, target := range targets {
+		list = append(list, target.Name)
+	}
+	return list
+}
+
+func TestCreate(t *testing.T) {
+	r, metaStore := newTestRegistry(t)
+
+	device, err := r.Create(models.Device{ID: 42, Name: "equipment1", Group: "a", Profile: "default", Port: 3001, Enabled: true}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.ID == 0 || device.ID == 42 {
+		t.Errorf("created device has ID %d, want one assigned by the store", device.ID)
+	}
+	if !changed(r) {
+		t.Error("no change signalled")
+	}
+	if targets := r.Targets("a"); len(targets) != 1 || targets[0].URL != "http://localhost:3001/equipment1" || targets[0].Protocol != "http" {
+		t.Errorf("targets %+v", targets)
+	}
+
+	for _, test := range []struct {
+		device models.Device
+		want   error
+	}{
+		{models.Device{Name: "equipment1", Profile: "default", Port: 3001}, ErrExists},
+		{models.Device{Profile: "default", Port: 3001}, ErrInvalid},
+		{models.Device{Name: "equipment2", Profile: "default"}, ErrInvalid},
+		{models.Device{Name: "equipment2", Profile: "default", Port: 3001, Protocol: "snmp"}, ErrInvalid},
+		{models.Device{Name: "equipment2", Profile: "unknown", Port: 3001}, ErrInvalid},
+	} {
+		if _, err := r.Create(test.device, "alice"); !errors.Is(err, test.want) {
+			t.Errorf("create %+v: %v, want %v", test.device, err, test.want)
+		}
+	}
+	if changed(r) {
+		t.Error("change signalled for a refused device")
+	}
+
+	// A new registry over the same store loads what was written
+	reloaded, err := New(metaStore, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := reloaded.Get("equipment1"); !ok || got.ID != device.ID || got.Port != 3001 {
+		t.Errorf("reloaded device %+v, %v", got, ok)
+	}
+	if devices := reloaded.Devices(); len(devices) != 1 {
+		t.Errorf("reloaded devices %+v", devices)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	r, metaStore := newTestRegistry(t)
+	for _, name := range []string{"equipment2", "equipment1"} {
+		if _, err := r.Create(models.Device{Name: name, Group: "a", Profile: "default", Port: 3001, Enabled: true}, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changed(r)
+
+	group, disabled, port := "b", false, 3002
+	device, err := r.Update("equipment1", models.DevicePatch{Group: &group, Port: &port}, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Group != "b" || device.Port != 3002 || device.Profile != "default" || !device.Enabled {
+		t.Errorf("updated device %+v", device)
+	}
+	if !changed(r) {
+		t.Error("no change signalled")
+	}
+	if got := r.Groups(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("groups %v", got)
+	}
+
+	// Disabled devices stay registered but are no longer fetched
+	if _, err := r.Update("equipment2", models.DevicePatch{Enabled: &disabled}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Groups(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("groups with a disabled device %v", got)
+	}
+	if got := names(r.Targets("b")); !reflect.DeepEqual(got, []string{"equipment1"}) {
+		t.Errorf("targets %v", got)
+	}
+	if enabled, err := metaStore.Devices(); err != nil || len(enabled) != 1 || enabled[0].Name != "equipment1" {
+		t.Errorf("stored enabled devices %+v: %v", enabled, err)
+	}
+
+	unknown, zero := "unknown", 0
+	if _, err := r.Update("equipment3", models.DevicePatch{Port: &port}, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update of an unknown device: %v", err)
+	}
+	if _, err := r.Update("equipment1", models.DevicePatch{Profile: &unknown}, "bob"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("update to an unknown profile: %v", err)
+	}
+	if _, err := r.Update("equipment1", models.DevicePatch{Port: &zero}, "bob"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("update to port 0: %v", err)
+	}
+	if got, _ := r.Get("equipment1"); got.Port != 3002 || got.Profile != "default" {
+		t.Errorf("refused update changed the device to %+v", got)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	r, metaStore := newTestRegistry(t)
+	for _, name := range []string{"equipment1", "equipment2"} {
+		if _, err := r.Create(models.Device{Name: name, Group: "a", Profile: "default", Port: 3001, Enabled: true}, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changed(r)
+
+	var deleted []string
+	r.OnDelete(func(name string) { deleted = append(deleted, name) })
+
+	if err := r.Delete("equipment1", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete("equipment1", "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete: %v", err)
+	}
+	if !reflect.DeepEqual(deleted, []string{"equipment1"}) {
+		t.Errorf("OnDelete called with %v", deleted)
+	}
+	if !changed(r) {
+		t.Error("no change signalled")
+	}
+	if _, ok := r.Get("equipment1"); ok {
+		t.Error("deleted device still registered")
+	}
+	if got := names(r.Targets("a")); !reflect.DeepEqual(got, []string{"equipment2"}) {
+		t.Errorf("targets %v", got)
+	}
+	if all, err := metaStore.AllDevices(); err != nil || len(all) != 1 || all[0].Name != "equipment2" {
+		t.Errorf("stored devices %+v: %v", all, err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"example.com/tool/breaker"
+	"example.com/tool/format"
+	"example.com/tool/getData"
+	"example.com/tool/health"
+	initSetting "example.com/tool/init"
+	"example.com/tool/models"
+	"example.com/tool/pipeline"
+	"example.com/tool/registry"
+	"example.com/tool/saveData"
+	"example.com/tool/store"
+)
+
+// runReplay feeds recorded raw responses through decoding and the sinks again.
+//
+//	go run . replay [-speed 1] [-decode current|recorded] [-db=false] recording.jsonl.gz...
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configPath := flags.String("config", "./config.json", "config file")
+	pointsPath := flags.String("points", "./points.json", "point profile file")
+	speed := flags.Float64("speed", 1, "1 replays at the original speed, 10 ten times faster, 0 as fast as possible")
+	decode := flags.String("decode", "current", `decode with the "current" point profiles or the ones "recorded" with the responses`)
+	toDB := flags.Bool("db", true, "save to the database REST API as well")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		log.Fatalf("replay: no recording given")
+	}
+	if *decode != "current" && *decode != "recorded" {
+		log.Fatalf("replay: unknown decode mode: %s", *decode)
+	}
+
+	config, err := initSetting.ReadConfig(*configPath)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	saveData.Configure(*config)
+	points, err := initSetting.ReadPonit(*pointsPath)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+
+	profiles := map[string]models.ConfigPoint{"default": *points}
+	var devices *registry.Registry
+	if config.StoreDialect != "" {
+		metaStore, err := store.Open(config.StoreDialect, config.StoreDSN)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		stored, err := metaStore.Profiles()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		for name, profile := range stored {
+			profiles[name] = profile
+		}
+		if devices, err = registry.New(metaStore, config.GetDataApiHost); err != nil {
+			log.Fatalf(err.Error())
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Replay keeps spill files of its own, it must neither take nor empty those of a collector
+	config.SpillDir = filepath.Join(config.SpillDir, "replay")
+
+	monitor := health.NewMonitor()
+	decodedQueue := make(chan models.SentData, 1000)
+	sinkBreakers := breaker.NewSet("sink", config.SinkBreaker)
+	observers, waitSinks := startSinks(ctx, config, *points, profiles, deviceGroup(config.DeviceSource, devices), monitor, sinkBreakers)
+
+	// Without the database the samples only go to the sinks, there is nothing to spill
+	var queue pipeline.Queue = discard{}
+	var savers sync.WaitGroup
+	if *toDB {
+		messageQueue := make(chan models.SentData, config.MaxQueue)
+		backpressure, err := pipeline.NewBackpressure(messageQueue, config.Backpressure, config.QueueHighWater, config.QueueLowWater, config.SpillDir)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		dbSaver := dbSink(fmt.Sprintf("http://%s:18080/rest/v2/insertRecords", config.SentDataApiHost), backpressure, sinkBreakers)
+		go backpressure.Run(ctx)
+		queue = backpressure
+
+		savePolicy := batchPolicy(config, config.BatchSize)
+		savePolicy.MaxBytes = config.MaxBatchBytes
+		savers.Add(1)
+		go func() {
+			defer savers.Done()
+			saveData.SaveByDevice(ctx, messageQueue, dbSaver, config.SemaphoreForSave, savePolicy, monitor)
+		}()
+	}
+	go pipeline.Dispatch(decodedQueue, queue, observers...)
+
+	total := 0
+	for _, path := range flags.Args() {
+		count, err := replayRecording(ctx, path, *decode, profiles, *speed, decodedQueue)
+		total += count
+		if err != nil {
+			log.Printf("replay of %s stopped after %d responses: %v", path, count, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		fmt.Printf("replayed %d responses of %s\n", count, path)
+	}
+
+	close(decodedQueue)
+	savers.Wait()
+	waitSinks()
+	fmt.Printf("replayed %d responses\n", total)
+}
+
+// discard is the queue of a replay without the database.
+type discard struct{}
+
+func (discard) Put(data models.SentData) {}
+
+func (discard) Close() {}
+
+// replayRecording decodes the responses of one recording into queue, keeping their recorded timestamps.
+// With speed > 0 the responses are paced by their recorded timestamps, speed times faster.
+func replayRecording(ctx context.Context, path, decode string, current map[string]models.ConfigPoint, speed float64, queue chan<- models.SentData) (int, error) {
+	recording, err := getData.OpenRecording(path)
+	if err != nil {
+		return 0, err
+	}
+	defer recording.Close()
+
+	profiles := current
+	if decode == "recorded" {
+		profiles = recording.Header.Profiles
+	}
+
+	var first int64
+	var started time.Time
+	count := 0
+	for {
+		record, err := recording.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		points, ok := profiles[record.Profile]
+		if !ok {
+			log.Printf("skipping response of %s: unknown point profile %q", record.Equipment, record.Profile)
+			continue
+		}
+
+		if speed > 0 {
+			if count == 0 {
+				first, started = record.Timestamp, time.Now()
+			}
+			due := started.Add(time.Duration(float64(record.Timestamp-first) / speed * float64(time.Millisecond)))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return count, ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		}
+
+		data := format.ProcessData(record.Equipment, record.Data, points)
+		data.Timestamps = record.Timestamp
+
+		select {
+		case queue <- data:
+			count++
+		case <-ctx.Done():
+			return count, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"example.com/tool/getData"
+	"example.com/tool/models"
+)
+
+// writeReplayConfig writes a config and a points file for runReplay into dir and returns their paths.
+func writeReplayConfig(t *testing.T, dir string, config map[string]interface{}) (configPath, pointsPath string) {
+	t.Helper()
+	configPath, pointsPath = filepath.Join(dir, "config.json"), filepath.Join(dir, "points.json")
+	for path, value := range map[string]interface{}{configPath: config, pointsPath: currentProfile} {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, encoded, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return configPath, pointsPath
+}
+
+func TestReplayWithoutDatabaseKeepsSpillFile(t *testing.T) {
+	dir := t.TempDir()
+	spillDir := filepath.Join(dir, "spill")
+	if err := os.MkdirAll(spillDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Spilled by a collector sharing the spill directory
+	spilled := `{"timestamps":1,"measurements_list":["kw"],"data_types_list":["DOUBLE"],"values_list":[1],"is_aligned":true,"devices":"root.test.equipment1"}` + "\n"
+	spillPath := filepath.Join(spillDir, "spill.jsonl")
+	if err := os.WriteFile(spillPath, []byte(spilled), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath, pointsPath := writeReplayConfig(t, dir, map[string]interface{}{"maxQueue": 10, "spillDir": spillDir})
+	// Replayed at the recorded pace, long enough for the spill to be refilled if replay used it
+	runReplay([]string{"-config", configPath, "-points", pointsPath, "-speed", "1", "-db=false", writeRecording(t, 1000, 1300)})
+
+	content, err := os.ReadFile(spillPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != spilled {
+		t.Errorf("spill file of the collector changed to %q", content)
+	}
+}
+
+// values returns the type and value of every measurement of data, whatever their order.
+func values(data models.SentData) map[string]string {
+	byMeasurement := make(map[string]string, len(data.MeasurementsList))
+	for i, measurement := range data.MeasurementsList {
+		byMeasurement[measurement] = fmt.Sprintf("%s %g", data.DataTypesList[i], data.ValuesList[i])
+	}
+	return byMeasurement
+}
+
+func TestRecordAndReplayRoundTrip(t *testing.T) {
+	// Every device answers with values of its own
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index := strings.TrimPrefix(r.URL.Path, "/equipment")
+		fmt.Fprintf(w, `{"Address3": %s.5, "Address4": -%s}`, index, index)
+	}))
+	defer server.Close()
+	var targets []models.Target
+	for i := 1; i <= 3; i++ {
+		name := fmt.Sprintf("equipment%d", i)
+		targets = append(targets, models.Target{Name: name, URL: server.URL + "/" + name, Points: currentProfile})
+	}
+
+	dir := t.TempDir()
+	recorder, err := getData.NewRecorder(filepath.Join(dir, "recordings"), map[string]models.ConfigPoint{"default": currentProfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	getData.SetRecorder(recorder)
+	t.Cleanup(func() { getData.SetRecorder(nil) })
+	originals, errs := getData.GetTargetData(context.Background(), targets)
+	if len(errs) > 0 || len(originals) != 3 || len(originals[0].ValuesList) != 2 {
+		t.Fatalf("fetched %+v: %v", originals, errs)
+	}
+	getData.SetRecorder(nil)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	recordings, _ := filepath.Glob(filepath.Join(dir, "recordings", "*.jsonl.gz"))
+	if len(recordings) != 1 {
+		t.Fatalf("recordings %v", recordings)
+	}
+
+	// Replay into a jsonl export with the profiles of the recording
+	exportDir := filepath.Join(dir, "export")
+	configPath, pointsPath := writeReplayConfig(t, dir, map[string]interface{}{
+		"maxQueue":  100,
+		"spillDir":  filepath.Join(dir, "spill"),
+		"fileSinks": []map[string]interface{}{{"format": "jsonl", "dir": exportDir}},
+	})
+	runReplay([]string{"-config", configPath, "-points", pointsPath, "-speed", "0", "-decode", "recorded", "-db=false", recordings[0]})
+
+	recording, err := getData.OpenRecording(recordings[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recording.Close()
+	recorded := make(map[string]int64)
+	for {
+		record, err := recording.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded[record.Equipment] = record.Timestamp
+	}
+
+	exported, _ := filepath.Glob(filepath.Join(exportDir, "*", "*.jsonl"))
+	replayed := make(map[string]models.SentData)
+	for _, path := range exported {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		for decoder.More() {
+			var data models.SentData
+			if err := decoder.Decode(&data); err != nil {
+				t.Fatal(err)
+			}
+			replayed[data.Devices] = data
+		}
+	}
+
+	if len(replayed) != len(originals) {
+		t.Fatalf("replayed %d devices, want %d", len(replayed), len(originals))
+	}
+	for _, original := range originals {
+		data, ok := replayed[original.Devices]
+		if !ok {
+			t.Errorf("%s not replayed", original.Devices)
+			continue
+		}
+		// Replayed samples keep the time their response was recorded
+		name := original.Devices[strings.LastIndex(original.Devices, ".")+1:]
+		if data.Timestamps != recorded[name] {
+			t.Errorf("%s replayed at %d, recorded at %d", original.Devices, data.Timestamps, recorded[name])
+		}
+		if !reflect.DeepEqual(values(data), values(original)) || data.IsAligned != original.IsAligned {
+			t.Errorf("replayed %+v\nwant %+v", data, original)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"example.com/tool/cache"
+	"example.com/tool/format"
+	"example.com/tool/getData"
+	initSetting "example.com/tool/init"
+	"example.com/tool/models"
+	"example.com/tool/saveData"
+	"example.com/tool/store"
+)
+
+// seriesKey identifies a stored series by the device path it was written to and its measurement.
+type seriesKey struct {
+	device      string
+	measurement string
+}
+
+// seriesDiff summarizes how re-decoding changes one series.
+type seriesDiff struct {
+	newDevice        string // device path of the re-decoded values
+	samples, changed int
+	maxDiff          float64
+	oldValue         float64 // first changed value, as stored
+	newValue         float64 // first changed value, re-decoded
+	from, to         int64   // time span of the recorded samples, ms
+	stale            []int64 // recorded timestamps whose re-decoded sample no longer writes this series
+}
+
+// reprocessFilter selects the records to reprocess.
+type reprocessFilter struct {
+	from, to int64 // ms, to is exclusive, 0 is unbounded
+	devices  string
+}
+
+func (f reprocessFilter) match(record models.RawRecord) bool {
+	if f.from != 0 && record.Timestamp < f.from {
+		return false
+	}
+	if f.to != 0 && record.Timestamp >= f.to {
+		return false
+	}
+	if f.devices == "" {
+		return true
+	}
+	ok, _ := path.Match(f.devices, record.Equipment)
+	return ok
+}
+
+// runReprocess re-decodes recorded raw responses with the current point profiles, reports the
+// series whose stored values would change and, with -apply, rewrites them in the database.
+//
+//	go run . reprocess -from 2026-10-01T00:00:00Z -to 2026-10-02T00:00:00Z -devices 'equipment1*' [-apply] [-mode replace|versioned] recording.jsonl.gz...
+func runReprocess(args []string) {
+	flags := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	configPath := flags.String("config", "./config.json", "config file")
+	pointsPath := flags.String("points", "./points.json", "point profile file")
+	fromFlag := flags.String("from", "", "start of the time range (RFC 3339), inclusive")
+	toFlag := flags.String("to", "", "end of the time range (RFC 3339), exclusive")
+	devices := flags.String("devices", "", "glob pattern on the equipment name, e.g. equipment1*")
+	apply := flags.Bool("apply", false, "rewrite the changed series, otherwise only report them")
+	mode := flags.String("mode", "replace", `"replace" overwrites the changed series, "versioned" writes them below <bindArea>.<version>`)
+	version := flags.String("version", "", "path level of the versioned series, defaults to v<unix time>")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		log.Fatalf("reprocess: no recording given")
+	}
+	if *mode != "replace" && *mode != "versioned" {
+		log.Fatalf("reprocess: unknown mode: %s", *mode)
+	}
+	if *version == "" {
+		*version = fmt.Sprintf("v%d", time.Now().Unix())
+	}
+
+	filter := reprocessFilter{devices: *devices}
+	for _, bound := range []struct {
+		value  string
+		target *int64
+	}{{*fromFlag, &filter.from}, {*toFlag, &filter.to}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			log.Fatalf("reprocess: invalid time %q: %v", bound.value, err)
+		}
+		*bound.target = t.UnixMilli()
+	}
+
+	config, err := initSetting.ReadConfig(*configPath)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	saveData.Configure(*config)
+	points, err := initSetting.ReadPonit(*pointsPath)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	current := map[string]models.ConfigPoint{"default": *points}
+	if config.StoreDialect != "" {
+		metaStore, err := store.Open(config.StoreDialect, config.StoreDSN)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		stored, err := metaStore.Profiles()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		for name, profile := range stored {
+			current[name] = profile
+		}
+	}
+
+	// 1. Compare the stored values with the re-decoded ones
+	diffs := make(map[seriesKey]*seriesDiff)
+	err = reprocessRecordings(flags.Args(), filter, current, func(old, updated models.SentData) {
+		compareSamples(diffs, old, updated)
+	})
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	printDiffReport(os.Stdout, diffs)
+
+	if !*apply {
+		fmt.Println("dry run, rerun with -apply to rewrite the changed series")
+		return
+	}
+
+	// 2. Write the re-decoded values, then delete the series they no longer write
+	dbHost := fmt.Sprintf("http://%s:18080/rest/v2", config.SentDataApiHost)
+	result, err := applyReprocess(flags.Args(), filter, current, diffs, *mode, *version, config.BatchSize, restSeries{host: dbHost})
+	fmt.Printf("rewrote %d samples, deleted %d values of dropped series (%s)\n", result.written, result.deleted, *mode)
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	if result.failed > 0 {
+		log.Fatalf("reprocess: %d requests failed, see above; rerunning with the same arguments is safe", result.failed)
+	}
+}
+
+// seriesWriter rewrites stored series.
+type seriesWriter interface {
+	Save(batch models.SentDataByBatched) error
+	Delete(key seriesKey, timestamp int64) error
+}
+
+// restSeries rewrites series through the database REST API at host.
+type restSeries struct {
+	host string
+}
+
+func (r restSeries) Save(batch models.SentDataByBatched) error {
+	return saveData.SaveData(batch, r.host+"/insertRecords")
+}
+
+func (r restSeries) Delete(key seriesKey, timestamp int64) error {
+	sql := fmt.Sprintf("DELETE FROM %s.%s WHERE time = %d", key.device, key.measurement, timestamp)
+	return saveData.ExecuteNonQuery(r.host+"/nonQuery", sql)
+}
+
+// reprocessResult counts what applyReprocess did.
+type reprocessResult struct {
+	written, deleted, failed int
+}
+
+// applyReprocess writes the re-decoded values of the changed series to db in batches of batchSize.
+// In replace mode they overwrite the stored values of the same timestamps; afterwards the series the
+// re-decoded samples no longer write, e.g. measurements dropped from the profile, are deleted at their
+// recorded timestamps only. Deleting only starts once every write succeeded, so a failure never loses
+// values. Failed requests are logged and counted, the rest is still applied.
+func applyReprocess(paths []string, filter reprocessFilter, current map[string]models.ConfigPoint, diffs map[seriesKey]*seriesDiff, mode, version string, batchSize int, db seriesWriter) (reprocessResult, error) {
+	var result reprocessResult
+	var batch models.SentDataByBatched
+	flush := func() {
+		if len(batch.Timestamps) == 0 {
+			return
+		}
+		if err := db.Save(batch); err != nil {
+			log.Printf("reprocess: failed to write %d samples: %v", len(batch.Timestamps), err)
+			result.failed++
+		} else {
+			result.written += len(batch.Timestamps)
+		}
+		batch = models.SentDataByBatched{}
+	}
+	err := reprocessRecordings(paths, filter, current, func(old, updated models.SentData) {
+		rewrite := changedMeasurements(diffs, old, updated)
+		if len(rewrite.MeasurementsList) == 0 {
+			return
+		}
+		if mode == "versioned" {
+			rewrite.Devices = versionedDevice(rewrite.Devices, version)
+		}
+
+		batch.Timestamps = append(batch.Timestamps, rewrite.Timestamps)
+		batch.MeasurementsList = append(batch.MeasurementsList, rewrite.MeasurementsList)
+		batch.DataTypesList = append(batch.DataTypesList, rewrite.DataTypesList)
+		batch.ValuesList = append(batch.ValuesList, rewrite.ValuesList)
+		batch.IsAligned = rewrite.IsAligned
+		batch.Devices = append(batch.Devices, rewrite.Devices)
+		if len(batch.Timestamps) >= batchSize {
+			flush()
+		}
+	})
+	flush()
+	if err != nil {
+		return result, err
+	}
+	if mode != "replace" || result.failed > 0 {
+		return result, nil
+	}
+
+	keys := make([]seriesKey, 0, len(diffs))
+	for key, diff := range diffs {
+		if len(diff.stale) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].device != keys[j].device {
+			return keys[i].device < keys[j].device
+		}
+		return keys[i].measurement < keys[j].measurement
+	})
+	for _, key := range keys {
+		for _, timestamp := range diffs[key].stale {
+			if err := db.Delete(key, timestamp); err != nil {
+				log.Printf("reprocess: %v", err)
+				result.failed++
+				continue
+			}
+			result.deleted++
+		}
+	}
+	return result, nil
+}
+
+// reprocessRecordings decodes every matching record of the recordings with the profile recorded with it
+// and with the current one, and hands both samples to visit. Both keep the recorded timestamp.
+func reprocessRecordings(paths []string, filter reprocessFilter, current map[string]models.ConfigPoint, visit func(old, updated models.SentData)) error {
+	for _, recordingPath := range paths {
+		recording, err := getData.OpenRecording(recordingPath)
+		if err != nil {
+			return err
+		}
+
+		for {
+			record, err := recording.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				recording.Close()
+				return fmt.Errorf("%s: %v", recordingPath, err)
+			}
+			if !filter.match(record) {
+				continue
+			}
+
+			oldPoints, okOld := recording.Header.Profiles[record.Profile]
+			newPoints, okNew := current[record.Profile]
+			if !okOld || !okNew {
+				log.Printf("skipping response of %s: point profile %q is missing", record.Equipment, record.Profile)
+				continue
+			}
+
+			old := format.ProcessData(record.Equipment, record.Data, oldPoints)
+			old.Timestamps = record.Timestamp
+			updated := format.ProcessData(record.Equipment, record.Data, newPoints)
+			updated.Timestamps = record.Timestamp
+			visit(old, updated)
+		}
+		recording.Close()
+	}
+	return nil
+}
+
+// compareSamples adds the differences between a stored and a re-decoded sample to diffs.
+func compareSamples(diffs map[seriesKey]*seriesDiff, old, updated models.SentData) {
+	oldValues := make(map[string]float64, len(old.MeasurementsList))
+	for i, measurement := range old.MeasurementsList {
+		oldValues[measurement] = old.ValuesList[i]
+	}
+	newValues := make(map[string]float64, len(updated.MeasurementsList))
+	for i, measurement := range updated.MeasurementsList {
+		newValues[measurement] = updated.ValuesList[i]
+	}
+
+	// Measurements added to or removed from the profile count as changed, with NaN on the missing side
+	measurements := append([]string{}, old.MeasurementsList...)
+	for _, measurement := range updated.MeasurementsList {
+		if _, ok := oldValues[measurement]; !ok {
+			measurements = append(measurements, measurement)
+		}
+	}
+
+	for _, measurement := range measurements {
+		key := seriesKey{device: old.Devices, measurement: measurement}
+		diff, ok := diffs[key]
+		if !ok {
+			diff = &seriesDiff{newDevice: updated.Devices, from: old.Timestamps, to: old.Timestamps}
+			diffs[key] = diff
+		}
+		diff.samples++
+		if old.Timestamps < diff.from {
+			diff.from = old.Timestamps
+		}
+		if old.Timestamps > diff.to {
+			diff.to = old.Timestamps
+		}
+
+		oldValue, ok := oldValues[measurement]
+		if !ok {
+			oldValue = math.NaN()
+		}
+		newValue, written := newValues[measurement]
+		if !written {
+			newValue = math.NaN()
+		}
+		// A stored value the re-decoded sample does not overwrite has to be deleted
+		if _, stored := oldValues[measurement]; stored && (!written || old.Devices != updated.Devices) {
+			diff.stale = append(diff.stale, old.Timestamps)
+		}
+		if old.Devices == updated.Devices && sameValue(oldValue, newValue) {
+			continue
+		}
+
+		if diff.changed == 0 {
+			diff.oldValue, diff.newValue = oldValue, newValue
+		}
+		diff.changed++
+		if delta := math.Abs(newValue - oldValue); delta > diff.maxDiff || math.IsNaN(delta) {
+			diff.maxDiff = delta
+		}
+	}
+}
+
+func sameValue(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+// changedMeasurements returns the re-decoded values of the series of old that changed somewhere in the range.
+func changedMeasurements(diffs map[seriesKey]*seriesDiff, old, updated models.SentData) models.SentData {
+	changed := make(map[string]bool)
+	for _, measurement := range updated.MeasurementsList {
+		if diff, ok := diffs[seriesKey{device: old.Devices, measurement: measurement}]; ok && diff.changed > 0 {
+			changed[measurement] = true
+		}
+	}
+
+	rewrite := models.SentData{Timestamps: updated.Timestamps, IsAligned: updated.IsAligned, Devices: updated.Devices}
+	for i, measurement := range updated.MeasurementsList {
+		if !changed[measurement] {
+			continue
+		}
+		rewrite.MeasurementsList = append(rewrite.MeasurementsList, measurement)
+		rewrite.DataTypesList = append(rewrite.DataTypesList, updated.DataTypesList[i])
+		rewrite.ValuesList = append(rewrite.ValuesList, updated.ValuesList[i])
+	}
+	return rewrite
+}
+
+// versionedDevice inserts the version level between the bind area and the equipment of a device path.
+func versionedDevice(device, version string) string {
+	name := cache.DeviceName(device)
+	return strings.TrimSuffix(device, name) + version + "." + name
+}
+
+// printDiffReport prints the series that change, sorted by device and measurement.
+func printDiffReport(out io.Writer, diffs map[seriesKey]*seriesDiff) {
+	keys := make([]seriesKey, 0, len(diffs))
+	samples := 0
+	for key, diff := range diffs {
+		samples += diff.samples
+		if diff.changed > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].device != keys[j].device {
+			return keys[i].device < keys[j].device
+		}
+		return keys[i].measurement < keys[j].measurement
+	})
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tMEASUREMENT\tCHANGED\tSAMPLES\tMAX DIFF\tEXAMPLE")
+	for _, key := range keys {
+		diff := diffs[key]
+		example := fmt.Sprintf("%g -> %g", diff.oldValue, diff.newValue)
+		if diff.newDevice != key.device {
+			example += " at " + diff.newDevice
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.6g\t%s\n", key.device, key.measurement, diff.changed, diff.samples, diff.maxDiff, example)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "%d of %d series change, %d samples compared\n", len(keys), len(diffs), samples)
+}
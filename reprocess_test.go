@@ -0,0 +1,179 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"example.com/tool/models"
+)
+
+func profileOf(addresses map[string]string) models.ConfigPoint {
+	settings := make(map[string]models.Point, len(addresses))
+	for measurement, address := range addresses {
+		settings[measurement] = models.Point{Value: []string{address}}
+	}
+	return models.ConfigPoint{CommonSetting: models.CommonSetting{BindArea: "root.test"}, ChannelSetting: settings}
+}
+
+// Recorded with kw at the wrong address and pf, which the current profile drops for temp
+var (
+	recordedProfile = profileOf(map[string]string{"kw": "Address1", "pf": "Address2"})
+	currentProfile  = profileOf(map[string]string{"kw": "Address3", "temp": "Address4"})
+)
+
+// writeRecording writes a recording of equipment1 with one response per timestamp.
+func writeRecording(t *testing.T, timestamps ...int64) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "raw.jsonl.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(file)
+	encoder := json.NewEncoder(zw)
+	encoder.Encode(models.RecordingHeader{Version: 1, Profiles: map[string]models.ConfigPoint{"default": recordedProfile}})
+	for i, timestamp := range timestamps {
+		encoder.Encode(models.RawRecord{
+			Timestamp: timestamp,
+			Equipment: "equipment1",
+			Profile:   "default",
+			Data:      map[string]float64{"Address1": 1, "Address2": 2, "Address3": float64(30 + i), "Address4": 40},
+		})
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	return path
+}
+
+// fakeSeries records the requests of applyReprocess in order.
+type fakeSeries struct {
+	requests []string
+	failSave bool
+}
+
+func (f *fakeSeries) Save(batch models.SentDataByBatched) error {
+	if f.failSave {
+		return errors.New("unavailable")
+	}
+	for i, device := range batch.Devices {
+		for j, measurement := range batch.MeasurementsList[i] {
+			f.requests = append(f.requests, fmt.Sprintf("write %s.%s@%d=%g", device, measurement, batch.Timestamps[i], batch.ValuesList[i][j]))
+		}
+	}
+	return nil
+}
+
+func (f *fakeSeries) Delete(key seriesKey, timestamp int64) error {
+	f.requests = append(f.requests, fmt.Sprintf("delete %s.%s@%d", key.device, key.measurement, timestamp))
+	return nil
+}
+
+func compare(t *testing.T, path string) map[seriesKey]*seriesDiff {
+	t.Helper()
+	diffs := make(map[seriesKey]*seriesDiff)
+	current := map[string]models.ConfigPoint{"default": currentProfile}
+	if err := reprocessRecordings([]string{path}, reprocessFilter{}, current, func(old, updated models.SentData) {
+		compareSamples(diffs, old, updated)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return diffs
+}
+
+func TestChangedMeasurements(t *testing.T) {
+	diffs := compare(t, writeRecording(t, 1000, 2000))
+
+	kw := diffs[seriesKey{"root.test.equipment1", "kw"}]
+	if kw.changed != 2 || kw.samples != 2 || kw.from != 1000 || kw.to != 2000 || len(kw.stale) != 0 {
+		t.Errorf("kw diff %+v", kw)
+	}
+	pf := diffs[seriesKey{"root.test.equipment1", "pf"}]
+	if pf.changed != 2 || !reflect.DeepEqual(pf.stale, []int64{1000, 2000}) {
+		t.Errorf("pf diff %+v", pf)
+	}
+
+	old := models.SentData{Timestamps: 1000, MeasurementsList: []string{"kw", "pf"}, DataTypesList: []string{"DOUBLE", "DOUBLE"}, ValuesList: []float64{1, 2}, Devices: "root.test.equipment1"}
+	updated := models.SentData{Timestamps: 1000, MeasurementsList: []string{"temp", "kw"}, DataTypesList: []string{"DOUBLE", "DOUBLE"}, ValuesList: []float64{40, 30}, Devices: "root.test.equipment1"}
+	rewrite := changedMeasurements(diffs, old, updated)
+	if !reflect.DeepEqual(rewrite.MeasurementsList, []string{"temp", "kw"}) || !reflect.DeepEqual(rewrite.ValuesList, []float64{40, 30}) {
+		t.Errorf("rewrites %+v", rewrite)
+	}
+
+	// An unchanged series is not rewritten
+	delete(diffs, seriesKey{"root.test.equipment1", "temp"})
+	if rewrite := changedMeasurements(diffs, old, updated); !reflect.DeepEqual(rewrite.MeasurementsList, []string{"kw"}) {
+		t.Errorf("rewrites %v", rewrite.MeasurementsList)
+	}
+}
+
+func TestApplyReprocessReplace(t *testing.T) {
+	path := writeRecording(t, 1000, 2000)
+	diffs := compare(t, path)
+	current := map[string]models.ConfigPoint{"default": currentProfile}
+
+	db := &fakeSeries{}
+	result, err := applyReprocess([]string{path}, reprocessFilter{}, current, diffs, "replace", "", 1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (reprocessResult{written: 2, deleted: 2}) {
+		t.Errorf("result %+v", result)
+	}
+
+	// Writes come first and overwrite the same timestamps, only the dropped series is deleted
+	writes := db.requests[:4]
+	sort.Strings(writes)
+	want := []string{
+		"write root.test.equipment1.kw@1000=30",
+		"write root.test.equipment1.kw@2000=31",
+		"write root.test.equipment1.temp@1000=40",
+		"write root.test.equipment1.temp@2000=40",
+	}
+	if !reflect.DeepEqual(writes, want) {
+		t.Errorf("writes %v, want %v", writes, want)
+	}
+	if deletes := db.requests[4:]; !reflect.DeepEqual(deletes, []string{"delete root.test.equipment1.pf@1000", "delete root.test.equipment1.pf@2000"}) {
+		t.Errorf("deletes %v", deletes)
+	}
+}
+
+func TestApplyReprocessKeepsValuesWhenWritesFail(t *testing.T) {
+	path := writeRecording(t, 1000, 2000)
+	diffs := compare(t, path)
+	current := map[string]models.ConfigPoint{"default": currentProfile}
+
+	db := &fakeSeries{failSave: true}
+	result, err := applyReprocess([]string{path}, reprocessFilter{}, current, diffs, "replace", "", 1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.failed != 2 || result.written != 0 || len(db.requests) != 0 {
+		t.Errorf("result %+v, requests %v", result, db.requests)
+	}
+}
+
+func TestApplyReprocessVersioned(t *testing.T) {
+	path := writeRecording(t, 1000)
+	diffs := compare(t, path)
+	current := map[string]models.ConfigPoint{"default": currentProfile}
+
+	db := &fakeSeries{}
+	result, err := applyReprocess([]string{path}, reprocessFilter{}, current, diffs, "versioned", "v2", 100, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(db.requests)
+	want := []string{"write root.test.v2.equipment1.kw@1000=30", "write root.test.v2.equipment1.temp@1000=40"}
+	if result.written != 1 || result.deleted != 0 || !reflect.DeepEqual(db.requests, want) {
+		t.Errorf("result %+v, requests %v", result, db.requests)
+	}
+}
@@ -0,0 +1,194 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/tool/metrics"
+	"example.com/tool/models"
+)
+
+// Policy retries outbound HTTP requests with exponential backoff and jitter.
+// Only network errors and retryable status codes are retried, see RetryableStatus.
+type Policy struct {
+	Name           string // names the client in logs and metrics, e.g. "saveData"
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration // also caps the wait a Retry-After asks for
+	Multiplier     float64
+	Jitter         float64 // fraction of every backoff that is randomized, 0 to 1
+
+	// OnAttempt, if set, is called after every attempt with its outcome; status is 0 without a response.
+	OnAttempt func(attempt, status int, err error)
+}
+
+// New builds the policy of config for the client named name, filling in defaults for missing settings:
+// 3 attempts, 500 ms initial backoff doubled up to 30 s, half of it randomized.
+func New(name string, config models.RetryPolicy) Policy {
+	p := Policy{
+		Name:           name,
+		MaxAttempts:    config.MaxAttempts,
+		InitialBackoff: time.Duration(config.InitialBackoffMs) * time.Millisecond,
+		MaxBackoff:     time.Duration(config.MaxBackoffMs) * time.Millisecond,
+		Multiplier:     config.Multiplier,
+		Jitter:         config.Jitter,
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 500 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 30 * time.Second
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = 0.5
+	}
+	return p
+}
+
+// StatusError is the error of a response with a status outside 2xx.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string // start of the response body
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return "status: " + e.Status
+	}
+	return fmt.Sprintf("status: %s %s", e.Status, e.Body)
+}
+
+// RetryableStatus reports whether a request failing with status may succeed when sent again:
+// timeouts, rate limits and server errors. Any other 4xx means the request itself is wrong.
+func RetryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return status >= 500
+}
+
+// Rejected reports whether err is a response with a status that is not retried.
+func Rejected(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && !RetryableStatus(statusErr.StatusCode)
+}
+
+// Do sends the request built by newRequest with client until it gets a 2xx response, fails for good or
+// runs out of attempts. newRequest is called for every attempt, so that the body is sent from the start.
+// The response of the successful attempt is returned with its body unread, the caller must close it.
+// Otherwise the error of the last attempt is returned, a *StatusError if the server responded.
+func (p Policy) Do(ctx context.Context, client *http.Client, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	backoff := p.InitialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var resp *http.Response
+		var status int
+		resp, status, err = p.attempt(ctx, client, newRequest)
+		if p.OnAttempt != nil {
+			p.OnAttempt(attempt, status, err)
+		}
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if status != 0 && !RetryableStatus(status) {
+			return nil, err
+		}
+		if attempt >= p.MaxAttempts {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		wait := p.jitter(backoff)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			wait = min(statusErr.RetryAfter, p.MaxBackoff)
+		}
+		log.Printf("%s: attempt %d/%d failed, retrying in %v: %v", p.Name, attempt, p.MaxAttempts, wait.Round(time.Millisecond), err)
+		metrics.HTTPRetries.WithLabelValues(p.Name).Inc()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w, last attempt: %v", ctx.Err(), err)
+		case <-timer.C:
+		}
+
+		backoff = time.Duration(float64(backoff) * p.Multiplier)
+		if backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// attempt sends one request and returns the response if its status is 2xx.
+func (p Policy) attempt(ctx context.Context, client *http.Client, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, int, error) {
+	req, err := newRequest(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create new request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, resp.StatusCode, nil
+	}
+
+	// Read the start of the body for the error and drain the rest, so the connection can be reused
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, resp.StatusCode, &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       strings.TrimSpace(string(message)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// jitter randomizes the last Jitter fraction of backoff.
+func (p Policy) jitter(backoff time.Duration) time.Duration {
+	random := time.Duration(rand.Float64() * p.Jitter * float64(backoff))
+	return backoff - random
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date, 0 if absent or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
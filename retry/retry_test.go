@@ -0,0 +1,172 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/tool/models"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":                              0,
+		"5":                             5 * time.Second,
+		"0":                             0,
+		"-3":                            0,
+		"soon":                          0,
+		"1.5":                           0,
+		"Wed, 21 Oct 2015 07:28:00 GMT": 0, // in the past
+	} {
+		if got := parseRetryAfter(value); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+
+	at := time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(at); got < 80*time.Second || got > 90*time.Second {
+		t.Errorf("parseRetryAfter(%q) = %v, want about 90s", at, got)
+	}
+}
+
+func TestJitterBounds(t *testing.T) {
+	p := New("test", models.RetryPolicy{Jitter: 0.25})
+	for i := 0; i < 1000; i++ {
+		wait := p.jitter(time.Second)
+		if wait < 750*time.Millisecond || wait > time.Second {
+			t.Fatalf("jitter of 1s with 0.25 gave %v", wait)
+		}
+	}
+
+	// Out of range jitter falls back to half the backoff
+	p = New("test", models.RetryPolicy{Jitter: 3})
+	for i := 0; i < 1000; i++ {
+		if wait := p.jitter(time.Second); wait < 500*time.Millisecond || wait > time.Second {
+			t.Fatalf("default jitter of 1s gave %v", wait)
+		}
+	}
+}
+
+// replayServer answers with statuses in turn, the last one repeated, and keeps every request body.
+type replayServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	header   http.Header
+}
+
+func newReplayServer(t *testing.T, header http.Header, statuses ...int) *replayServer {
+	s := &replayServer{statuses: statuses, header: header}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		status := s.statuses[min(len(s.bodies), len(s.statuses))-1]
+		s.mu.Unlock()
+
+		for name, values := range s.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, "answer")
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *replayServer) post(p Policy) (*http.Response, error) {
+	payload := []byte(`{"values":[1,2,3]}`)
+	return p.Do(context.Background(), http.DefaultClient, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "POST", s.URL, bytes.NewReader(payload))
+	})
+}
+
+func TestDoReplaysBodyOnEveryAttempt(t *testing.T) {
+	server := newReplayServer(t, nil, http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK)
+
+	var attempts []int
+	p := New("test", models.RetryPolicy{MaxAttempts: 5, InitialBackoffMs: 1})
+	p.OnAttempt = func(attempt, status int, err error) {
+		attempts = append(attempts, status)
+	}
+	resp, err := server.post(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "answer" {
+		t.Errorf("response body %q", body)
+	}
+
+	if len(server.bodies) != 3 {
+		t.Fatalf("sent %d attempts, want 3", len(server.bodies))
+	}
+	for i, body := range server.bodies {
+		if body != `{"values":[1,2,3]}` {
+			t.Errorf("attempt %d sent %q", i+1, body)
+		}
+	}
+	if want := []int{503, 502, 200}; len(attempts) != 3 || attempts[0] != want[0] || attempts[1] != want[1] || attempts[2] != want[2] {
+		t.Errorf("reported attempts %v, want %v", attempts, want)
+	}
+}
+
+func TestDoStopsOnRejectedRequests(t *testing.T) {
+	server := newReplayServer(t, nil, http.StatusBadRequest)
+	_, err := server.post(New("test", models.RetryPolicy{MaxAttempts: 5, InitialBackoffMs: 1}))
+	if !Rejected(err) {
+		t.Fatalf("error %v is not a rejection", err)
+	}
+	if len(server.bodies) != 1 {
+		t.Errorf("retried a rejected request %d times", len(server.bodies)-1)
+	}
+}
+
+func TestDoGivesUpAfterMaxAttempts(t *testing.T) {
+	server := newReplayServer(t, nil, http.StatusInternalServerError)
+	_, err := server.post(New("test", models.RetryPolicy{MaxAttempts: 3, InitialBackoffMs: 1}))
+	if err == nil || Rejected(err) {
+		t.Fatalf("error %v", err)
+	}
+	if len(server.bodies) != 3 {
+		t.Errorf("sent %d attempts, want 3", len(server.bodies))
+	}
+}
+
+func TestDoCapsRetryAfter(t *testing.T) {
+	// The server asks for an hour, the policy waits at most 50 ms
+	server := newReplayServer(t, http.Header{"Retry-After": {"3600"}}, http.StatusTooManyRequests, http.StatusNoContent)
+	started := time.Now()
+	resp, err := server.post(New("test", models.RetryPolicy{MaxAttempts: 2, InitialBackoffMs: 1, MaxBackoffMs: 50}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("retried after %v, want 50ms", elapsed)
+	}
+}
+
+func TestDoStopsWhenCancelled(t *testing.T) {
+	server := newReplayServer(t, nil, http.StatusServiceUnavailable)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	p := New("test", models.RetryPolicy{MaxAttempts: 10, InitialBackoffMs: 10000})
+	_, err := p.Do(ctx, http.DefaultClient, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	})
+	if err == nil || ctx.Err() == nil {
+		t.Fatalf("error %v", err)
+	}
+	if len(server.bodies) != 1 {
+		t.Errorf("sent %d attempts, want 1", len(server.bodies))
+	}
+}
@@ -0,0 +1,365 @@
+package saveData
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"example.com/tool/models"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// recordWriter encodes samples into one open export file.
+type recordWriter interface {
+	Write(data models.SentData) error
+	Flush() error // writes buffered samples through to the file
+	Close() error // completes the file, e.g. writes the parquet footer
+}
+
+// countingWriter counts the bytes written to the file.
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// exportFile is the open file of one device group.
+type exportFile struct {
+	path    string
+	window  int64 // start of the rotation window in ms
+	file    *os.File
+	counter *countingWriter
+	records recordWriter
+}
+
+// FileSink exports decoded samples to local files, one open file per device group.
+// Files are named <group>-<window start>-<seq>.<format> after the rotation window of their first sample,
+// a new file is started for every window and whenever a file grows past MaxBytes.
+type FileSink struct {
+	config  models.FileSink
+	columns []string // sorted
+	groupOf func(device string) string
+	files   map[string]*exportFile
+}
+
+// NewFileSink creates a file sink with the given measurement columns, see Columns; csv and parquet
+// files leave out any other measurement. groupOf returns the device group of a device path.
+func NewFileSink(config models.FileSink, columns []string, groupOf func(device string) string) (*FileSink, error) {
+	switch config.Format {
+	case "csv", "jsonl", "parquet":
+	default:
+		return nil, fmt.Errorf("unknown file sink format: %s", config.Format)
+	}
+
+	columns = append([]string(nil), columns...)
+	sort.Strings(columns)
+
+	return &FileSink{config: config, columns: columns, groupOf: groupOf, files: make(map[string]*exportFile)}, nil
+}
+
+// Columns returns the measurements of every profile and the extra ones, sorted and without duplicates.
+func Columns(profiles map[string]models.ConfigPoint, extra ...string) []string {
+	seen := make(map[string]bool)
+	var columns []string
+	add := func(measurement string) {
+		if !seen[measurement] {
+			seen[measurement] = true
+			columns = append(columns, measurement)
+		}
+	}
+	for _, profile := range profiles {
+		for measurement := range profile.ChannelSetting {
+			add(measurement)
+		}
+	}
+	for _, measurement := range extra {
+		add(measurement)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// Save appends the samples of batch to the files of their groups.
+func (s *FileSink) Save(batch models.SentDataByBatched) error {
+	window := int64(s.config.RotateSeconds) * 1000
+	touched := make(map[*exportFile]bool)
+
+	for i, device := range batch.Devices {
+		data := models.SentData{
+			Timestamps:       batch.Timestamps[i],
+			MeasurementsList: batch.MeasurementsList[i],
+			DataTypesList:    batch.DataTypesList[i],
+			ValuesList:       batch.ValuesList[i],
+			IsAligned:        batch.IsAligned,
+			Devices:          device,
+		}
+		group := s.groupOf(device)
+		// Late samples of the previous window stay in the current file, so files only move forward
+		start := data.Timestamps - data.Timestamps%window
+
+		f := s.files[group]
+		if f != nil && (start > f.window || (s.config.MaxBytes > 0 && f.counter.count >= s.config.MaxBytes)) {
+			if err := s.finish(f); err != nil {
+				return err
+			}
+			delete(s.files, group)
+			delete(touched, f)
+			f = nil
+		}
+		if f == nil {
+			var err error
+			if f, err = s.open(group, start); err != nil {
+				return err
+			}
+			s.files[group] = f
+		}
+
+		if err := f.records.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s: %v", f.path, err)
+		}
+		touched[f] = true
+
+		// Sizes are only known once written through, check them before the next sample.
+		// Parquet files are only written through per batch, one row group each.
+		if s.config.MaxBytes > 0 && s.config.Format != "parquet" {
+			if err := f.records.Flush(); err != nil {
+				return fmt.Errorf("failed to write %s: %v", f.path, err)
+			}
+		}
+	}
+
+	for f := range touched {
+		if err := f.records.Flush(); err != nil {
+			return fmt.Errorf("failed to write %s: %v", f.path, err)
+		}
+	}
+	return nil
+}
+
+// open creates the next file of group for the window starting at start.
+func (s *FileSink) open(group string, start int64) (*exportFile, error) {
+	dir := filepath.Join(s.config.Dir, group)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %v", err)
+	}
+
+	// Continue after the files a previous run left in the same window
+	stamp := time.UnixMilli(start).UTC().Format("20060102T150405Z")
+	var path string
+	for seq := 0; ; seq++ {
+		path = filepath.Join(dir, fmt.Sprintf("%s-%s-%03d.%s", group, stamp, seq, s.config.Format))
+		if !exists(path) && !exists(path+".gz") {
+			break
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export file: %v", err)
+	}
+	f := &exportFile{path: path, window: start, file: file, counter: &countingWriter{w: file}}
+
+	switch s.config.Format {
+	case "csv":
+		f.records, err = newCSVRecords(f.counter, s.columns)
+	case "jsonl":
+		f.records = newJSONLRecords(f.counter)
+	case "parquet":
+		f.records, err = newParquetRecords(f.counter, s.columns)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	return f, nil
+}
+
+// finish completes and closes f, and compresses it if configured.
+func (s *FileSink) finish(f *exportFile) error {
+	if err := f.records.Close(); err != nil {
+		f.file.Close()
+		return fmt.Errorf("failed to complete %s: %v", f.path, err)
+	}
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", f.path, err)
+	}
+
+	// Parquet pages are compressed already
+	if s.config.Compress && s.config.Format != "parquet" {
+		if err := gzipFile(f.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close completes every open file.
+func (s *FileSink) Close() error {
+	var firstErr error
+	for group, f := range s.files {
+		if err := s.finish(f); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.files, group)
+	}
+	return firstErr
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// gzipFile replaces path by path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to compress %s: %v", path, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return fmt.Errorf("failed to compress %s: %v", path, err)
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(path)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to compress %s: %v", path, err)
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to compress %s: %v", path, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to compress %s: %v", path, err)
+	}
+	return os.Remove(path)
+}
+
+// csvRecords writes one row per sample with a column per measurement of the profile.
+type csvRecords struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func newCSVRecords(w io.Writer, columns []string) (*csvRecords, error) {
+	r := &csvRecords{w: csv.NewWriter(w), columns: columns}
+	header := append([]string{"timestamp", "device"}, columns...)
+	return r, r.w.Write(header)
+}
+
+func (r *csvRecords) Write(data models.SentData) error {
+	values := make(map[string]float64, len(data.MeasurementsList))
+	for i, measurement := range data.MeasurementsList {
+		values[measurement] = data.ValuesList[i]
+	}
+
+	row := make([]string, 0, len(r.columns)+2)
+	row = append(row, strconv.FormatInt(data.Timestamps, 10), data.Devices)
+	for _, column := range r.columns {
+		if value, ok := values[column]; ok {
+			row = append(row, strconv.FormatFloat(value, 'f', -1, 64))
+		} else {
+			row = append(row, "")
+		}
+	}
+	return r.w.Write(row)
+}
+
+func (r *csvRecords) Flush() error {
+	r.w.Flush()
+	return r.w.Error()
+}
+
+func (r *csvRecords) Close() error {
+	return r.Flush()
+}
+
+// jsonlRecords writes every sample as one SentData JSON object per line.
+type jsonlRecords struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newJSONLRecords(w io.Writer) *jsonlRecords {
+	buffered := bufio.NewWriter(w)
+	return &jsonlRecords{w: buffered, encoder: json.NewEncoder(buffered)}
+}
+
+func (r *jsonlRecords) Write(data models.SentData) error {
+	return r.encoder.Encode(data)
+}
+
+func (r *jsonlRecords) Flush() error {
+	return r.w.Flush()
+}
+
+func (r *jsonlRecords) Close() error {
+	return r.w.Flush()
+}
+
+// parquetRecords writes samples to a parquet file with a timestamp, a device and an optional
+// double column per measurement of the profile. Every flush writes a row group.
+type parquetRecords struct {
+	w       *writer.CSVWriter
+	columns []string
+}
+
+func newParquetRecords(w io.Writer, columns []string) (*parquetRecords, error) {
+	schema := []string{
+		"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MILLIS",
+		"name=device, type=BYTE_ARRAY, convertedtype=UTF8",
+	}
+	for _, column := range columns {
+		schema = append(schema, fmt.Sprintf("name=%s, type=DOUBLE, repetitiontype=OPTIONAL", column))
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(schema, w, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetRecords{w: pw, columns: columns}, nil
+}
+
+func (r *parquetRecords) Write(data models.SentData) error {
+	values := make(map[string]float64, len(data.MeasurementsList))
+	for i, measurement := range data.MeasurementsList {
+		values[measurement] = data.ValuesList[i]
+	}
+
+	row := make([]interface{}, 0, len(r.columns)+2)
+	row = append(row, data.Timestamps, data.Devices)
+	for _, column := range r.columns {
+		if value, ok := values[column]; ok {
+			row = append(row, value)
+		} else {
+			row = append(row, nil)
+		}
+	}
+	return r.w.Write(row)
+}
+
+func (r *parquetRecords) Flush() error {
+	return r.w.Flush(true)
+}
+
+func (r *parquetRecords) Close() error {
+	return r.w.WriteStop()
+}
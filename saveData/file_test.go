@@ -0,0 +1,255 @@
+package saveData
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"example.com/tool/models"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestColumns(t *testing.T) {
+	profiles := map[string]models.ConfigPoint{
+		"default": {ChannelSetting: map[string]models.Point{"kw": {}, "pf": {}}},
+		"water":   {ChannelSetting: map[string]models.Point{"waterFlow": {}, "kw": {}}},
+	}
+	got := Columns(profiles, "state", "consecutive_failures")
+	want := []string{"consecutive_failures", "kw", "pf", "state", "waterFlow"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("columns %v, want %v", got, want)
+	}
+}
+
+func TestFileSinkCSVColumns(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(models.FileSink{Format: "csv", Dir: dir, RotateSeconds: 3600},
+		[]string{"waterFlow", "kw", "state"}, func(string) string { return "g1" })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := models.SentDataByBatched{
+		Timestamps:       []int64{3600000, 3601000},
+		MeasurementsList: [][]string{{"kw", "unknown"}, {"state"}},
+		DataTypesList:    [][]string{{"DOUBLE", "DOUBLE"}, {"INT32"}},
+		ValuesList:       [][]float64{{1.5, 9}, {2}},
+		IsAligned:        true,
+		Devices:          []string{"root.a.equipment1", "root.a.equipment1.status"},
+	}
+	if err := sink.Save(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "g1", "g1-19700101T010000Z-000.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"timestamp", "device", "kw", "state", "waterFlow"},
+		{"3600000", "root.a.equipment1", "1.5", "", ""},
+		{"3601000", "root.a.equipment1.status", "", "2", ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("wrote %v, want %v", rows, want)
+	}
+}
+
+// fileSamples are 2 samples per hour for 3 hours of two devices, written in one batch.
+func fileSamples() models.SentDataByBatched {
+	var batch models.SentDataByBatched
+	for hour := int64(0); hour < 3; hour++ {
+		for _, minute := range []int64{0, 30} {
+			for d, device := range []string{"root.a.equipment1", "root.a.equipment2"} {
+				batch.Timestamps = append(batch.Timestamps, (hour*3600+minute*60)*1000)
+				batch.MeasurementsList = append(batch.MeasurementsList, []string{"kw", "pf"})
+				batch.DataTypesList = append(batch.DataTypesList, []string{"DOUBLE", "DOUBLE"})
+				batch.ValuesList = append(batch.ValuesList, []float64{float64(hour*100 + minute + int64(d)), 0.5})
+				batch.Devices = append(batch.Devices, device)
+			}
+		}
+	}
+	batch.IsAligned = true
+	return batch
+}
+
+// writeFiles saves batch with a sink of config in one group and returns the files written, sorted.
+func writeFiles(t *testing.T, config models.FileSink, batch models.SentDataByBatched) []string {
+	t.Helper()
+	config.Dir = t.TempDir()
+	sink, err := NewFileSink(config, []string{"kw", "pf"}, func(string) string { return "g1" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Save(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(config.Dir, "g1", "*"))
+	sort.Strings(files)
+	return files
+}
+
+// readJSONL decodes the samples of a jsonl file, gzipped if its name ends in .gz.
+func readJSONL(t *testing.T, path string) []models.SentData {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	}
+
+	var samples []models.SentData
+	decoder := json.NewDecoder(r)
+	for {
+		var data models.SentData
+		if err := decoder.Decode(&data); err == io.EOF {
+			return samples
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, data)
+	}
+}
+
+func TestFileSinkRotatesCompressedJSONL(t *testing.T) {
+	batch := fileSamples()
+	files := writeFiles(t, models.FileSink{Format: "jsonl", RotateSeconds: 3600, Compress: true}, batch)
+
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	want := []string{"g1-19700101T000000Z-000.jsonl.gz", "g1-19700101T010000Z-000.jsonl.gz", "g1-19700101T020000Z-000.jsonl.gz"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("files %v, want %v", names, want)
+	}
+
+	// Every hour in its own file, together the samples of the batch in order
+	var samples []models.SentData
+	for i, file := range files {
+		read := readJSONL(t, file)
+		for _, data := range read {
+			if data.Timestamps/3600000 != int64(i) {
+				t.Errorf("%s holds a sample of %d", names[i], data.Timestamps)
+			}
+		}
+		samples = append(samples, read...)
+	}
+	if len(samples) != len(batch.Timestamps) {
+		t.Fatalf("read %d samples, want %d", len(samples), len(batch.Timestamps))
+	}
+	for i, data := range samples {
+		if data.Timestamps != batch.Timestamps[i] || data.Devices != batch.Devices[i] || !reflect.DeepEqual(data.ValuesList, batch.ValuesList[i]) ||
+			!reflect.DeepEqual(data.MeasurementsList, batch.MeasurementsList[i]) || !reflect.DeepEqual(data.DataTypesList, batch.DataTypesList[i]) {
+			t.Errorf("sample %d read back as %+v", i, data)
+		}
+	}
+}
+
+func TestFileSinkRotatesOnSize(t *testing.T) {
+	batch := fileSamples()
+	files := writeFiles(t, models.FileSink{Format: "csv", RotateSeconds: 86400, MaxBytes: 100}, batch)
+	if len(files) < 3 {
+		t.Fatalf("%d files of at most about 100 bytes", len(files))
+	}
+
+	rows := 0
+	for i, file := range files {
+		if want := fmt.Sprintf("g1-19700101T000000Z-%03d.csv", i); filepath.Base(file) != want {
+			t.Errorf("file %s, want %s", filepath.Base(file), want)
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if records[0][0] != "timestamp" {
+			t.Errorf("%s starts without a header: %v", file, records[0])
+		}
+		rows += len(records) - 1
+	}
+	if rows != len(batch.Timestamps) {
+		t.Errorf("%d rows, want %d", rows, len(batch.Timestamps))
+	}
+}
+
+func TestFileSinkParquetRoundTrip(t *testing.T) {
+	batch := fileSamples()
+	batch.MeasurementsList[1] = []string{"kw"} // pf missing for one sample
+	batch.ValuesList[1] = batch.ValuesList[1][:1]
+	files := writeFiles(t, models.FileSink{Format: "parquet", RotateSeconds: 86400, Compress: true}, batch)
+	if len(files) != 1 || filepath.Base(files[0]) != "g1-19700101T000000Z-000.parquet" {
+		t.Fatalf("files %v", files)
+	}
+
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := buffer.NewBufferFile(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(source, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	if rows := int(pr.GetNumRows()); rows != len(batch.Timestamps) {
+		t.Fatalf("%d rows, want %d", rows, len(batch.Timestamps))
+	}
+	rows, err := pr.ReadByNumber(len(batch.Timestamps))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, row := range rows {
+		encoded, _ := json.Marshal(row)
+		var got struct {
+			Timestamp int64
+			Device    string
+			Kw, Pf    *float64
+		}
+		if err := json.Unmarshal(encoded, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Timestamp != batch.Timestamps[i] || got.Device != batch.Devices[i] || got.Kw == nil || *got.Kw != batch.ValuesList[i][0] {
+			t.Errorf("row %d read back as %s", i, encoded)
+		}
+		if (got.Pf == nil) != (i == 1) {
+			t.Errorf("row %d pf %v", i, got.Pf)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package saveData
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"example.com/tool/cache"
+	"example.com/tool/connectivity"
+	"example.com/tool/models"
+	"example.com/tool/retry"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// InfluxSink writes decoded samples as line protocol to the /api/v2/write API of InfluxDB or VictoriaMetrics.
+type InfluxSink struct {
+	config   models.InfluxSink
+	writeURL string
+	retry    retry.Policy
+}
+
+// NewInfluxSink creates a sink for the database of config.
+func NewInfluxSink(config models.InfluxSink) (*InfluxSink, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("influx sink requires a url")
+	}
+
+	query := url.Values{}
+	query.Set("org", config.Org)
+	query.Set("bucket", config.Bucket)
+	query.Set("precision", "ms")
+
+	return &InfluxSink{
+		config:   config,
+		writeURL: strings.TrimSuffix(config.URL, "/") + "/api/v2/write?" + query.Encode(),
+		retry:    retry.New("influx", models.RetryPolicy{MaxAttempts: config.MaxAttempts, InitialBackoffMs: config.BackoffMs}),
+	}, nil
+}
+
+// LineProtocol converts batch into line protocol, one line per sample.
+// The measurement is the bindArea of the device, tagged with company and equipment, with one field
+// per measurement and a millisecond timestamp. NaN and infinite values are left out.
+// A status series shares the measurement and tags of its device, with the fields state and consecutive_failures.
+func LineProtocol(batch models.SentDataByBatched, company string) []byte {
+	var buf bytes.Buffer
+	for i, device := range batch.Devices {
+		device, _ = connectivity.DeviceOf(device)
+		bindArea := device
+		if j := strings.LastIndex(device, "."); j >= 0 {
+			bindArea = device[:j]
+		}
+
+		fields := 0
+		for j, measurement := range batch.MeasurementsList[i] {
+			value := batch.ValuesList[i][j]
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+
+			if fields == 0 {
+				buf.WriteString(measurementEscaper.Replace(bindArea))
+				if company != "" {
+					buf.WriteString(",company=")
+					buf.WriteString(tagEscaper.Replace(company))
+				}
+				buf.WriteString(",equipment=")
+				buf.WriteString(tagEscaper.Replace(cache.DeviceName(device)))
+				buf.WriteByte(' ')
+			} else {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(tagEscaper.Replace(measurement))
+			buf.WriteByte('=')
+			buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+			fields++
+		}
+
+		if fields > 0 {
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatInt(batch.Timestamps[i], 10))
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// Save writes batch, retrying with the retry policy of the sink. 4xx responses other than 429 are not retried.
+func (s *InfluxSink) Save(batch models.SentDataByBatched) error {
+	lines := LineProtocol(batch, s.config.Company)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var payload bytes.Buffer
+	writer := gzip.NewWriter(&payload)
+	if _, err := writer.Write(lines); err != nil {
+		return fmt.Errorf("failed to compress data: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress data: %v", err)
+	}
+
+	resp, err := s.retry.Do(context.Background(), httpClient, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", s.writeURL, bytes.NewReader(payload.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		req.Header.Set("Content-Encoding", "gzip")
+		if s.config.Token != "" {
+			req.Header.Set("Authorization", "Token "+s.config.Token)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write data to InfluxDB: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+func (s *InfluxSink) Close() error {
+	return nil
+}
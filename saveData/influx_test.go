@@ -0,0 +1,163 @@
+package saveData
+
+import (
+	"compress/gzip"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"example.com/tool/connectivity"
+	"example.com/tool/models"
+	"example.com/tool/retry"
+)
+
+func influxBatch() models.SentDataByBatched {
+	return models.SentDataByBatched{
+		Timestamps:       []int64{1700000000000, 1700000001000, 1700000002000},
+		MeasurementsList: [][]string{{"kw", "pf"}, {"kw"}, {"kw", "water temp"}},
+		DataTypesList:    [][]string{{"DOUBLE", "DOUBLE"}, {"DOUBLE"}, {"DOUBLE", "DOUBLE"}},
+		ValuesList:       [][]float64{{12.5, math.NaN()}, {math.Inf(1)}, {3, 1e21}},
+		IsAligned:        true,
+		Devices:          []string{"root.site A.floor1.equipment1", "root.site A.floor1.equipment2", "root.plant.equip,3"},
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	got := string(LineProtocol(influxBatch(), "ACME Corp"))
+	// NaN and infinite values are left out, a sample without any value is left out entirely
+	want := `root.site\ A.floor1,company=ACME\ Corp,equipment=equipment1 kw=12.5 1700000000000
+root.plant,company=ACME\ Corp,equipment=equip\,3 kw=3,water\ temp=1e+21 1700000002000
+`
+	if got != want {
+		t.Errorf("line protocol:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := string(LineProtocol(influxBatch(), "")); got[:len(`root.site\ A.floor1,equipment=`)] != `root.site\ A.floor1,equipment=` {
+		t.Errorf("company tag written without a company: %s", got)
+	}
+}
+
+func TestLineProtocolStatusSeries(t *testing.T) {
+	status := connectivity.Sample(models.StatusEvent{Device: "root.site A.floor1.equipment1", State: connectivity.Degraded, Failures: 2, Timestamp: 1700000003000})
+	batch := models.SentDataByBatched{
+		Timestamps:       []int64{status.Timestamps},
+		MeasurementsList: [][]string{status.MeasurementsList},
+		DataTypesList:    [][]string{status.DataTypesList},
+		ValuesList:       [][]float64{status.ValuesList},
+		Devices:          []string{status.Devices},
+	}
+	// Tagged like the samples of the device, so that its state can be joined on equipment
+	want := `root.site\ A.floor1,company=ACME,equipment=equipment1 state=1,consecutive_failures=2 1700000003000
+`
+	if got := string(LineProtocol(batch, "ACME")); got != want {
+		t.Errorf("line protocol:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// influxServer answers writes with statuses in turn, the last one repeated, and keeps the decoded bodies.
+type influxServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newInfluxServer(t *testing.T, statuses ...int) *influxServer {
+	s := &influxServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("body is not gzip: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(reader)
+
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, string(body))
+		status := s.statuses[min(len(s.requests), len(s.statuses))-1]
+		s.mu.Unlock()
+
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestInfluxSinkSave(t *testing.T) {
+	server := newInfluxServer(t, http.StatusNoContent)
+	sink, err := NewInfluxSink(models.InfluxSink{URL: server.URL + "/", Org: "my org", Bucket: "meters", Token: "secret", Company: "ACME Corp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Save(influxBatch()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.requests) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(server.requests))
+	}
+	r := server.requests[0]
+	if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("org") != "my org" || r.URL.Query().Get("bucket") != "meters" || r.URL.Query().Get("precision") != "ms" {
+		t.Errorf("wrote to %s", r.URL)
+	}
+	if r.Header.Get("Authorization") != "Token secret" || r.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("sent headers %v", r.Header)
+	}
+	if want := string(LineProtocol(influxBatch(), "ACME Corp")); server.bodies[0] != want {
+		t.Errorf("wrote %q, want %q", server.bodies[0], want)
+	}
+
+	// A batch without any value is not written
+	empty := models.SentDataByBatched{Timestamps: []int64{1}, MeasurementsList: [][]string{{"kw"}}, ValuesList: [][]float64{{math.NaN()}}, Devices: []string{"root.a.b"}}
+	if err := sink.Save(empty); err != nil || len(server.requests) != 1 {
+		t.Errorf("empty batch: %v, %d requests", err, len(server.requests))
+	}
+}
+
+func TestInfluxSinkRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		statuses []int
+		requests int
+		fails    bool
+		rejected bool
+	}{
+		{"rate limited then server error", []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusNoContent}, 3, false, false},
+		{"server error on every attempt", []int{http.StatusInternalServerError}, 3, true, false},
+		{"bad request", []int{http.StatusBadRequest}, 1, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newInfluxServer(t, tc.statuses...)
+			sink, err := NewInfluxSink(models.InfluxSink{URL: server.URL, MaxAttempts: 3, BackoffMs: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = sink.Save(influxBatch())
+			if (err != nil) != tc.fails {
+				t.Fatalf("save returned %v", err)
+			}
+			if retry.Rejected(err) != tc.rejected {
+				t.Errorf("rejected is %v for %v", retry.Rejected(err), err)
+			}
+			if len(server.requests) != tc.requests {
+				t.Errorf("sent %d requests, want %d", len(server.requests), tc.requests)
+			}
+			// Every attempt sends the whole batch again
+			for i, body := range server.bodies {
+				if body != server.bodies[0] || body == "" {
+					t.Errorf("attempt %d sent %q", i+1, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package saveData
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"example.com/tool/cache"
+	"example.com/tool/models"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// publishTimeout bounds the wait for the broker to accept a batch
+const publishTimeout = 10 * time.Second
+
+// MQTTSink publishes decoded samples to an MQTT broker.
+type MQTTSink struct {
+	config         models.MQTTSink
+	perMeasurement bool
+	client         mqtt.Client
+}
+
+// measurementMessage is the payload of a single value published to its own topic.
+type measurementMessage struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+	DataType  string  `json:"dataType"`
+}
+
+// NewMQTTSink connects to the broker of config.
+// The client keeps reconnecting in the background if the broker cannot be reached.
+func NewMQTTSink(config models.MQTTSink) (*MQTTSink, error) {
+	if config.Topic == "" {
+		return nil, fmt.Errorf("mqtt sink requires a topic template")
+	}
+	if config.QoS > 2 {
+		return nil, fmt.Errorf("invalid mqtt sink qos: %d", config.QoS)
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			log.Printf("MQTT sink connection lost: %v", err)
+		})
+
+	s := &MQTTSink{
+		config:         config,
+		perMeasurement: strings.Contains(config.Topic, "{measurement}"),
+		client:         mqtt.NewClient(options),
+	}
+
+	if token := s.client.Connect(); !token.WaitTimeout(5 * time.Second) {
+		log.Printf("MQTT sink broker %s not reachable yet, retrying in the background", config.Broker)
+	} else if token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker %s: %v", config.Broker, token.Error())
+	}
+
+	return s, nil
+}
+
+// topic expands the topic template for a device path such as "root.systex.Rich19.7F.Daisy.equipment1234"
+// and a measurement.
+func (s *MQTTSink) topic(device, measurement string) string {
+	bindArea := ""
+	if i := strings.LastIndex(device, "."); i >= 0 {
+		bindArea = strings.ReplaceAll(device[:i], ".", "/")
+	}
+	return strings.NewReplacer(
+		"{bindArea}", bindArea,
+		"{equipment}", cache.DeviceName(device),
+		"{measurement}", measurement,
+	).Replace(s.config.Topic)
+}
+
+// Save publishes every sample of batch and waits until the broker has accepted all of them.
+func (s *MQTTSink) Save(batch models.SentDataByBatched) error {
+	var tokens []mqtt.Token
+	publish := func(topic string, payload interface{}) error {
+		message, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal data: %v", err)
+		}
+		tokens = append(tokens, s.client.Publish(topic, s.config.QoS, s.config.Retained, message))
+		return nil
+	}
+
+	for i, device := range batch.Devices {
+		if !s.perMeasurement {
+			sample := models.SentData{
+				Timestamps:       batch.Timestamps[i],
+				MeasurementsList: batch.MeasurementsList[i],
+				DataTypesList:    batch.DataTypesList[i],
+				ValuesList:       batch.ValuesList[i],
+				IsAligned:        batch.IsAligned,
+				Devices:          device,
+			}
+			if err := publish(s.topic(device, ""), sample); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for j, measurement := range batch.MeasurementsList[i] {
+			message := measurementMessage{
+				Timestamp: batch.Timestamps[i],
+				Value:     batch.ValuesList[i][j],
+				DataType:  batch.DataTypesList[i][j],
+			}
+			if err := publish(s.topic(device, measurement), message); err != nil {
+				return err
+			}
+		}
+	}
+
+	deadline := time.Now().Add(publishTimeout)
+	for _, token := range tokens {
+		if !token.WaitTimeout(time.Until(deadline)) {
+			return fmt.Errorf("timed out publishing to MQTT broker %s", s.config.Broker)
+		}
+		if err := token.Error(); err != nil {
+			return fmt.Errorf("failed to publish to MQTT broker %s: %v", s.config.Broker, err)
+		}
+	}
+	return nil
+}
+
+// Close disconnects from the broker after pending messages are sent.
+func (s *MQTTSink) Close() error {
+	s.client.Disconnect(1000)
+	return nil
+}
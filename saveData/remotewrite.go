@@ -0,0 +1,281 @@
+package saveData
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"example.com/tool/cache"
+	"example.com/tool/connectivity"
+	"example.com/tool/models"
+	"example.com/tool/retry"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Metric types of the remote-write metadata
+const (
+	metricTypeCounter = 1
+	metricTypeGauge   = 2
+)
+
+type promLabel struct {
+	name, value string
+}
+
+type promSample struct {
+	value     float64
+	timestamp int64
+}
+
+// promSeries is one time series of a remote-write request.
+type promSeries struct {
+	key     string
+	labels  []promLabel // sorted by name
+	samples []promSample
+	counter bool
+}
+
+// RemoteWriteSink pushes decoded samples to a Prometheus remote-write receiver.
+// Every measurement of a device is a series labelled with device, area and company.
+type RemoteWriteSink struct {
+	config   models.RemoteWriteSink
+	counters map[string]bool
+	retry    retry.Policy
+}
+
+// NewRemoteWriteSink creates a sink for the receiver of config. Shards and MaxSamplesPerSend must be
+// set, ReadConfig defaults them.
+func NewRemoteWriteSink(config models.RemoteWriteSink) (*RemoteWriteSink, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("remote write sink requires a url")
+	}
+	if config.Shards <= 0 {
+		return nil, fmt.Errorf("remote write sink requires at least one shard")
+	}
+	if config.MaxSamplesPerSend <= 0 {
+		return nil, fmt.Errorf("remote write sink requires a positive maxSamplesPerSend")
+	}
+
+	counters := make(map[string]bool, len(config.Counters))
+	for _, measurement := range config.Counters {
+		counters[measurement] = true
+	}
+	policy := retry.New("remote-write", models.RetryPolicy{MaxAttempts: config.MaxAttempts, InitialBackoffMs: config.BackoffMs})
+	return &RemoteWriteSink{config: config, counters: counters, retry: policy}, nil
+}
+
+// metricName turns a measurement into a valid Prometheus metric name. Counters get the _total suffix.
+func metricName(measurement string, counter bool) string {
+	name := []byte(measurement)
+	for i, c := range name {
+		valid := c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			name[i] = '_'
+		}
+	}
+	if counter && !strings.HasSuffix(string(name), "_total") {
+		return string(name) + "_total"
+	}
+	return string(name)
+}
+
+// series groups the values of batch by series, each in timestamp order. NaN and infinite values are left out.
+func (s *RemoteWriteSink) series(batch models.SentDataByBatched) []*promSeries {
+	bySeries := make(map[string]*promSeries)
+	var list []*promSeries
+
+	for i, device := range batch.Devices {
+		// state and consecutive_failures of a status series are labelled as metrics of its device
+		device, _ = connectivity.DeviceOf(device)
+		area := ""
+		if j := strings.LastIndex(device, "."); j >= 0 {
+			area = device[:j]
+		}
+		for j, measurement := range batch.MeasurementsList[i] {
+			value := batch.ValuesList[i][j]
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+
+			counter := s.counters[measurement]
+			labels := []promLabel{{"__name__", metricName(measurement, counter)}}
+			for _, label := range []promLabel{{"area", area}, {"company", s.config.Company}, {"device", cache.DeviceName(device)}} {
+				if label.value != "" { // empty labels must not be sent
+					labels = append(labels, label)
+				}
+			}
+			key := labels[0].value + "|" + device
+			series, ok := bySeries[key]
+			if !ok {
+				series = &promSeries{key: key, labels: labels, counter: counter}
+				bySeries[key] = series
+				list = append(list, series)
+			}
+			series.samples = append(series.samples, promSample{value: value, timestamp: batch.Timestamps[i]})
+		}
+	}
+
+	for _, series := range list {
+		sort.SliceStable(series.samples, func(a, b int) bool { return series.samples[a].timestamp < series.samples[b].timestamp })
+	}
+	return list
+}
+
+// Save shards the series of batch by their labels and sends the shards concurrently, each in requests
+// of at most MaxSamplesPerSend samples. Since every series always lands in the same shard and batches
+// are saved one after another, the samples of a series arrive in order.
+func (s *RemoteWriteSink) Save(batch models.SentDataByBatched) error {
+	shards := make([][]*promSeries, s.config.Shards)
+	for _, series := range s.series(batch) {
+		hash := fnv.New32a()
+		hash.Write([]byte(series.key))
+		shard := hash.Sum32() % uint32(len(shards))
+		shards[shard] = append(shards[shard], series)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(shards))
+	for i, shard := range shards {
+		if len(shard) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, shard []*promSeries) {
+			defer wg.Done()
+			errs[i] = s.sendShard(shard)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// sendShard sends the series of one shard in requests of at most MaxSamplesPerSend samples.
+func (s *RemoteWriteSink) sendShard(shard []*promSeries) error {
+	var pending []*promSeries
+	count := 0
+	flush := func() error {
+		if count == 0 {
+			return nil
+		}
+		err := s.send(encodeWriteRequest(pending))
+		pending, count = nil, 0
+		return err
+	}
+
+	for _, series := range shard {
+		samples := series.samples
+		for len(samples) > 0 {
+			room := s.config.MaxSamplesPerSend - count
+			if room > len(samples) {
+				room = len(samples)
+			}
+			part := *series
+			part.samples = samples[:room]
+			pending = append(pending, &part)
+			count += room
+			samples = samples[room:]
+
+			if count >= s.config.MaxSamplesPerSend {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return flush()
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest with the series and the metadata of their metric families.
+func encodeWriteRequest(series []*promSeries) []byte {
+	var buf []byte
+	families := make(map[string]bool)
+
+	for _, s := range series {
+		var ts []byte
+		for _, label := range s.labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label.name)
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+		for _, sample := range s.samples {
+			var p []byte
+			p = protowire.AppendTag(p, 1, protowire.Fixed64Type)
+			p = protowire.AppendFixed64(p, math.Float64bits(sample.value))
+			p = protowire.AppendTag(p, 2, protowire.VarintType)
+			p = protowire.AppendVarint(p, uint64(sample.timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, p)
+		}
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+		families[s.labels[0].value] = s.counter
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metricType := uint64(metricTypeGauge)
+		if families[name] {
+			metricType = metricTypeCounter
+		}
+		var m []byte
+		m = protowire.AppendTag(m, 1, protowire.VarintType)
+		m = protowire.AppendVarint(m, metricType)
+		m = protowire.AppendTag(m, 2, protowire.BytesType)
+		m = protowire.AppendString(m, name)
+		buf = protowire.AppendTag(buf, 3, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, m)
+	}
+
+	return buf
+}
+
+// send posts one write request, retrying 5xx and 429 responses with the retry policy of the sink.
+// Other 4xx responses mean the receiver rejects the data, which is then dropped.
+func (s *RemoteWriteSink) send(request []byte) error {
+	payload := snappy.Encode(nil, request)
+
+	resp, err := s.retry.Do(context.Background(), httpClient, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", s.config.URL, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		for key, value := range s.config.Headers {
+			req.Header.Set(key, value)
+		}
+		return req, nil
+	})
+	if retry.Rejected(err) {
+		return fmt.Errorf("remote write rejected, dropping the samples: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remote write: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+func (s *RemoteWriteSink) Close() error {
+	return nil
+}
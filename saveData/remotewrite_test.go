@@ -0,0 +1,318 @@
+package saveData
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"example.com/tool/connectivity"
+	"example.com/tool/models"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// writeRequestDescriptor describes prometheus.WriteRequest of the remote-write protocol 1.0.
+func writeRequestDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: kind.Enum(), Label: label.Enum()}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	const (
+		optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		message  = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("remote.proto"),
+		Package: proto.String("prometheus"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("MetricType"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("COUNTER"), Number: proto.Int32(1)},
+				{Name: proto.String("GAUGE"), Number: proto.Int32(2)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("WriteRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("timeseries", 1, message, repeated, ".prometheus.TimeSeries"),
+				field("metadata", 3, message, repeated, ".prometheus.MetricMetadata"),
+			}},
+			{Name: proto.String("TimeSeries"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("labels", 1, message, repeated, ".prometheus.Label"),
+				field("samples", 2, message, repeated, ".prometheus.Sample"),
+			}},
+			{Name: proto.String("Label"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+			}},
+			{Name: proto.String("Sample"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("value", 1, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, optional, ""),
+				field("timestamp", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+			}},
+			{Name: proto.String("MetricMetadata"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("type", 1, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional, ".prometheus.MetricType"),
+				field("metric_family_name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+			}},
+		},
+	}
+	descriptor, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return descriptor.Messages().ByName("WriteRequest")
+}
+
+// writtenSeries is a decoded time series: its labels as name=value and its samples as value@timestamp.
+type writtenSeries struct {
+	labels  string
+	samples []string
+}
+
+// writeRequest is a decoded remote-write request.
+type writeRequest struct {
+	series   []writtenSeries
+	metadata map[string]string // metric family name to type
+}
+
+// decodeWriteRequest decodes payload as a prometheus.WriteRequest and fails on fields outside the schema.
+func decodeWriteRequest(t *testing.T, descriptor protoreflect.MessageDescriptor, payload []byte) writeRequest {
+	t.Helper()
+	message := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(payload, message); err != nil {
+		t.Fatalf("payload is not a WriteRequest: %v", err)
+	}
+
+	var unknown func(m protoreflect.Message)
+	unknown = func(m protoreflect.Message) {
+		if len(m.GetUnknown()) > 0 {
+			t.Errorf("%s holds fields outside the schema", m.Descriptor().FullName())
+		}
+		m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			if fd.Kind() == protoreflect.MessageKind && fd.IsList() {
+				for i := 0; i < v.List().Len(); i++ {
+					unknown(v.List().Get(i).Message())
+				}
+			}
+			return true
+		})
+	}
+	unknown(message)
+
+	fields := descriptor.Fields()
+	request := writeRequest{metadata: make(map[string]string)}
+	timeseries := message.Get(fields.ByName("timeseries")).List()
+	for i := 0; i < timeseries.Len(); i++ {
+		ts := timeseries.Get(i).Message()
+		tsFields := ts.Descriptor().Fields()
+		var series writtenSeries
+		labels := ts.Get(tsFields.ByName("labels")).List()
+		var pairs []string
+		for j := 0; j < labels.Len(); j++ {
+			label := labels.Get(j).Message()
+			labelFields := label.Descriptor().Fields()
+			pairs = append(pairs, label.Get(labelFields.ByName("name")).String()+"="+label.Get(labelFields.ByName("value")).String())
+		}
+		series.labels = strings.Join(pairs, ",")
+		samples := ts.Get(tsFields.ByName("samples")).List()
+		for j := 0; j < samples.Len(); j++ {
+			sample := samples.Get(j).Message()
+			sampleFields := sample.Descriptor().Fields()
+			series.samples = append(series.samples, fmt.Sprintf("%g@%d", sample.Get(sampleFields.ByName("value")).Float(), sample.Get(sampleFields.ByName("timestamp")).Int()))
+		}
+		request.series = append(request.series, series)
+	}
+	metadata := message.Get(fields.ByName("metadata")).List()
+	for i := 0; i < metadata.Len(); i++ {
+		m := metadata.Get(i).Message()
+		mFields := m.Descriptor().Fields()
+		kind := mFields.ByName("type")
+		request.metadata[m.Get(mFields.ByName("metric_family_name")).String()] = string(kind.Enum().Values().ByNumber(m.Get(kind).Enum()).Name())
+	}
+	return request
+}
+
+// remoteWriteServer keeps the decoded requests it receives.
+type remoteWriteServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []writeRequest
+}
+
+func newRemoteWriteServer(t *testing.T) *remoteWriteServer {
+	descriptor := writeRequestDescriptor(t)
+	s := &remoteWriteServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("Content-Encoding") != "snappy" ||
+			r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("headers %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		payload, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Errorf("body is not snappy: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		request := decodeWriteRequest(t, descriptor, payload)
+		s.mu.Lock()
+		s.requests = append(s.requests, request)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestNewRemoteWriteSinkValidatesConfig(t *testing.T) {
+	for _, config := range []models.RemoteWriteSink{
+		{Shards: 1, MaxSamplesPerSend: 1},
+		{URL: "http://127.0.0.1:9090/api/v1/write", MaxSamplesPerSend: 1},
+		{URL: "http://127.0.0.1:9090/api/v1/write", Shards: 1, MaxSamplesPerSend: -1},
+	} {
+		if _, err := NewRemoteWriteSink(config); err == nil {
+			t.Errorf("config %+v accepted", config)
+		}
+	}
+}
+
+func TestRemoteWriteShardsAndSplits(t *testing.T) {
+	server := newRemoteWriteServer(t)
+	const shards, maxSamples = 3, 4
+	sink, err := NewRemoteWriteSink(models.RemoteWriteSink{
+		URL: server.URL, Headers: map[string]string{"Authorization": "Bearer secret"}, Company: "ACME",
+		Counters: []string{"kwh"}, Shards: shards, MaxSamplesPerSend: maxSamples, MaxAttempts: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8 devices with kw and kwh, 3 samples each, queued newest first
+	var batch models.SentDataByBatched
+	for second := int64(3); second >= 1; second-- {
+		for d := 1; d <= 8; d++ {
+			batch.Timestamps = append(batch.Timestamps, second*1000)
+			batch.MeasurementsList = append(batch.MeasurementsList, []string{"kw", "kwh"})
+			batch.DataTypesList = append(batch.DataTypesList, []string{"DOUBLE", "DOUBLE"})
+			batch.ValuesList = append(batch.ValuesList, []float64{float64(d), float64(second)})
+			batch.Devices = append(batch.Devices, fmt.Sprintf("root.site.equipment%d", d))
+		}
+	}
+	if err := sink.Save(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	shardOf := func(metric string, d int) uint32 {
+		hash := fnv.New32a()
+		hash.Write([]byte(fmt.Sprintf("%s|root.site.equipment%d", metric, d)))
+		return hash.Sum32() % shards
+	}
+	want := make(map[string][]string)
+	wantShard := make(map[string]uint32)
+	for d := 1; d <= 8; d++ {
+		kw := fmt.Sprintf("__name__=kw,area=root.site,company=ACME,device=equipment%d", d)
+		kwh := fmt.Sprintf("__name__=kwh_total,area=root.site,company=ACME,device=equipment%d", d)
+		want[kw] = []string{fmt.Sprintf("%d@1000", d), fmt.Sprintf("%d@2000", d), fmt.Sprintf("%d@3000", d)}
+		want[kwh] = []string{"1@1000", "2@2000", "3@3000"}
+		wantShard[kw], wantShard[kwh] = shardOf("kw", d), shardOf("kwh_total", d)
+	}
+
+	got := make(map[string][]string)
+	usedShards := make(map[uint32]bool)
+	for _, request := range server.requests {
+		count := 0
+		requestShards := make(map[uint32]bool)
+		for _, series := range request.series {
+			count += len(series.samples)
+			got[series.labels] = append(got[series.labels], series.samples...)
+			requestShards[wantShard[series.labels]] = true
+			usedShards[wantShard[series.labels]] = true
+
+			name := strings.TrimPrefix(strings.Split(series.labels, ",")[0], "__name__=")
+			wantType := "GAUGE"
+			if name == "kwh_total" {
+				wantType = "COUNTER"
+			}
+			if request.metadata[name] != wantType {
+				t.Errorf("metadata of %s: %q, want %s", name, request.metadata[name], wantType)
+			}
+		}
+		if count > maxSamples {
+			t.Errorf("request of %d samples, at most %d allowed", count, maxSamples)
+		}
+		if len(requestShards) != 1 {
+			t.Errorf("request mixes the series of shards %v", requestShards)
+		}
+	}
+
+	// A series split across requests of its shard still arrives in order
+	for labels := range got {
+		if !sort.SliceIsSorted(got[labels], func(i, j int) bool {
+			return strings.Split(got[labels][i], "@")[1] < strings.Split(got[labels][j], "@")[1]
+		}) {
+			t.Errorf("%s out of order: %v", labels, got[labels])
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("written series\n%v\nwant\n%v", got, want)
+	}
+	if len(usedShards) < 2 {
+		t.Errorf("16 series all sent through shards %v", usedShards)
+	}
+	if minRequests := (48 + maxSamples - 1) / maxSamples; len(server.requests) < minRequests {
+		t.Errorf("%d requests for 48 samples", len(server.requests))
+	}
+}
+
+func TestRemoteWriteStatusSeries(t *testing.T) {
+	server := newRemoteWriteServer(t)
+	sink, err := NewRemoteWriteSink(models.RemoteWriteSink{
+		URL: server.URL, Headers: map[string]string{"Authorization": "Bearer secret"}, Shards: 1, MaxSamplesPerSend: 10, MaxAttempts: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := connectivity.Sample(models.StatusEvent{Device: "root.site.equipment1", State: connectivity.Offline, Failures: 4, Timestamp: 2000})
+	batch := models.SentDataByBatched{
+		Timestamps:       []int64{1000, status.Timestamps},
+		MeasurementsList: [][]string{{"kw"}, status.MeasurementsList},
+		DataTypesList:    [][]string{{"DOUBLE"}, status.DataTypesList},
+		ValuesList:       [][]float64{{12.5}, status.ValuesList},
+		Devices:          []string{"root.site.equipment1", status.Devices},
+	}
+	if err := sink.Save(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string][]string)
+	for _, request := range server.requests {
+		for _, series := range request.series {
+			got[series.labels] = append(got[series.labels], series.samples...)
+		}
+	}
+	// The status of a device joins its other series on area and device
+	want := map[string][]string{
+		"__name__=kw,area=root.site,device=equipment1":                   {"12.5@1000"},
+		"__name__=state,area=root.site,device=equipment1":                {"2@2000"},
+		"__name__=consecutive_failures,area=root.site,device=equipment1": {"4@2000"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("written series\n%v\nwant\n%v", got, want)
+	}
+}
@@ -5,13 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"sync/atomic"
 	"time"
 
 	"example.com/tool/models"
-	"github.com/gammazero/workerpool"
+	"example.com/tool/retry"
 )
 
 // Custom HTTP client with increased timeout and connection pooling
@@ -27,84 +27,68 @@ var httpClient = &http.Client{
 	},
 }
 
-// SaveData sends the SentData to the database API with retry logic and increased timeout
+// savePolicy retries the requests to the database REST API, see Configure.
+var savePolicy = retry.New("saveData", models.RetryPolicy{})
+
+// Configure applies the save settings of config. It must be called before saving starts.
+func Configure(config models.Config) {
+	savePolicy = retry.New("saveData", config.Retry)
+}
+
+// SaveData sends the SentData to the database API, retrying as configured by Configure.
 func SaveData(data models.SentDataByBatched, dbAPIURL string) error {
 	payload, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal data: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", dbAPIURL, bytes.NewBuffer(payload))
+	resp, err := savePolicy.Do(context.Background(), httpClient, func(ctx context.Context) (*http.Request, error) {
+		return newDBRequest(ctx, dbAPIURL, payload)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create new request: %v", err)
+		return fmt.Errorf("failed to send data to DB: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Basic cm9vdDpyb290")
-	req.ContentLength = int64(len(payload)) // Ensure Content-Length is set correctly
-
-	// Implementing retry logic
-	const maxRetries = 2
-	for i := 0; i < maxRetries; i++ {
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			log.Printf("failed to send data to DB (attempt %d/%d): %v", i+1, maxRetries, err)
-			time.Sleep(2 * time.Second) // Exponential backoff can be implemented here
-			continue
-		}
-
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusOK {
-			return nil // Success
-		}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
 
-		log.Printf("failed to save data to DB, status: %v (attempt %d/%d)", resp.Status, i+1, maxRetries)
-		time.Sleep(10 * time.Millisecond) // Exponential backoff can be implemented here
+// newDBRequest builds a POST of payload to the database REST API.
+func newDBRequest(ctx context.Context, url string, payload []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
 	}
-
-	return fmt.Errorf("failed to send data to DB after %d attempts: %w", maxRetries, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Basic cm9vdDpyb290")
+	return req, nil
 }
 
-// AggregateAndSaveData continuously reads from the messageQueue and aggregates the data.
-// Once the number of items reaches the batchSize, it sends the data to the database API.
-func AggregateAndSaveData(ctx context.Context, messageQueue <-chan models.SentData, dbAPIURL string, batchSize int, wp *workerpool.WorkerPool,
-	apiSaveCount *int32) {
-	var batch models.SentDataByBatched
-
-	for {
-		select {
-		case <-ctx.Done():
-			// 時間結束時，送出最後一次請求
-			if len(batch.Timestamps) > 0 {
-				if err := SaveData(batch, dbAPIURL); err != nil {
-					fmt.Printf("failed to save batch data: %v\n", err)
-				}
-			}
-			return
+// ExecuteNonQuery runs a statement such as DELETE through the nonQuery endpoint of the database REST API.
+func ExecuteNonQuery(nonQueryURL, sql string) error {
+	payload, err := json.Marshal(map[string]string{"sql": sql})
+	if err != nil {
+		return fmt.Errorf("failed to marshal statement: %v", err)
+	}
 
-		case data := <-messageQueue:
-			wp.Submit(func() {
-				batch.Timestamps = append(batch.Timestamps, data.Timestamps)
-				batch.MeasurementsList = append(batch.MeasurementsList, data.MeasurementsList)
-				batch.DataTypesList = append(batch.DataTypesList, data.DataTypesList)
-				batch.ValuesList = append(batch.ValuesList, data.ValuesList)
-				batch.IsAligned = data.IsAligned
-				batch.Devices = append(batch.Devices, data.Devices)
-
-				if len(batch.Timestamps) >= batchSize {
-					if err := SaveData(batch, dbAPIURL); err != nil {
-						fmt.Printf("failed to save batch data: %v\n", err)
-					}
-					batch = models.SentDataByBatched{} // Reset batch
-
-					atomic.AddInt32(apiSaveCount, int32(batchSize)) // Increment the counter
-				}
-			})
+	resp, err := savePolicy.Do(context.Background(), httpClient, func(ctx context.Context) (*http.Request, error) {
+		return newDBRequest(ctx, nonQueryURL, payload)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute %q: %w", sql, err)
+	}
+	defer resp.Body.Close()
 
-			// Add a sleep interval to prevent resource exhaustion
-			time.Sleep(10 * time.Millisecond)
-		}
+	// The REST API reports failed statements in the code of the body as well
+	var result struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if result.Code != 0 && result.Code != 200 {
+		return fmt.Errorf("failed to execute %q, code: %d %s", sql, result.Code, result.Message)
 	}
+	return nil
 }
 
 // AggregateAndSaveData continuously reads from the messageQueue and aggregates the data.
@@ -142,72 +126,3 @@ func AggregateAndSaveDataByGoRoutine(ctx context.Context, messageQueue <-chan mo
 		}
 	}
 }
-
-func AggregateAndSaveDataByGoRoutineNoCount(ctx context.Context, messageQueue <-chan models.SentData, dbAPIURL string, batchSize int) {
-	var batch models.SentDataByBatched
-
-	for {
-		select {
-		case <-ctx.Done():
-			// 時間結束時，送出最後一次請求
-			if len(batch.Timestamps) > 0 {
-				if err := SaveData(batch, dbAPIURL); err != nil {
-					fmt.Printf("failed to save batch data: %v\n", err)
-				}
-			}
-			return
-
-		case data := <-messageQueue:
-			batch.Timestamps = append(batch.Timestamps, data.Timestamps)
-			batch.MeasurementsList = append(batch.MeasurementsList, data.MeasurementsList)
-			batch.DataTypesList = append(batch.DataTypesList, data.DataTypesList)
-			batch.ValuesList = append(batch.ValuesList, data.ValuesList)
-			batch.IsAligned = data.IsAligned
-			batch.Devices = append(batch.Devices, data.Devices)
-
-			if len(batch.Timestamps) >= batchSize {
-				if err := SaveData(batch, dbAPIURL); err != nil {
-					fmt.Printf("failed to save batch data: %v\n", err)
-				}
-				batch = models.SentDataByBatched{} // Reset batch
-			}
-		}
-	}
-}
-
-func AggregateAndSaveDataNoCount(ctx context.Context, messageQueue <-chan models.SentData, dbAPIURL string, batchSize int, wp *workerpool.WorkerPool) {
-	var batch models.SentDataByBatched
-
-	for {
-		select {
-		case <-ctx.Done():
-			// 時間結束時，送出最後一次請求
-			if len(batch.Timestamps) > 0 {
-				if err := SaveData(batch, dbAPIURL); err != nil {
-					fmt.Printf("failed to save batch data: %v\n", err)
-				}
-			}
-			return
-
-		case data := <-messageQueue:
-			wp.Submit(func() {
-				batch.Timestamps = append(batch.Timestamps, data.Timestamps)
-				batch.MeasurementsList = append(batch.MeasurementsList, data.MeasurementsList)
-				batch.DataTypesList = append(batch.DataTypesList, data.DataTypesList)
-				batch.ValuesList = append(batch.ValuesList, data.ValuesList)
-				batch.IsAligned = data.IsAligned
-				batch.Devices = append(batch.Devices, data.Devices)
-
-				if len(batch.Timestamps) >= batchSize {
-					if err := SaveData(batch, dbAPIURL); err != nil {
-						fmt.Printf("failed to save batch data: %v\n", err)
-					}
-					batch = models.SentDataByBatched{} // Reset batch
-				}
-			})
-
-			// Add a sleep interval to prevent resource exhaustion
-			time.Sleep(1 * time.Millisecond)
-		}
-	}
-}
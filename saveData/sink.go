@@ -0,0 +1,294 @@
+package saveData
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/tool/breaker"
+	"example.com/tool/health"
+	"example.com/tool/metrics"
+	"example.com/tool/models"
+	"example.com/tool/retry"
+)
+
+// Sink stores batches of decoded samples.
+type Sink interface {
+	Save(batch models.SentDataByBatched) error
+	Close() error
+}
+
+// RESTSink saves batches through the database REST API.
+type RESTSink struct {
+	URL string
+}
+
+func (s RESTSink) Save(batch models.SentDataByBatched) error {
+	return SaveData(batch, s.URL)
+}
+
+func (s RESTSink) Close() error {
+	return nil
+}
+
+// BreakerSink saves to a sink through its circuit breaker. While the breaker is open, batches go to
+// divert at once, or are dropped without one, instead of waiting for the sink to time out.
+type BreakerSink struct {
+	sink    Sink
+	breaker *breaker.Breaker
+	divert  func(batch models.SentDataByBatched) error
+	spill   *SinkSpill
+}
+
+// NewBreakerSink wraps sink with breaker. divert may be nil.
+func NewBreakerSink(sink Sink, breaker *breaker.Breaker, divert func(batch models.SentDataByBatched) error) *BreakerSink {
+	return &BreakerSink{sink: sink, breaker: breaker, divert: divert}
+}
+
+// NewSpillingBreakerSink wraps sink with breaker and diverts to spill. The spilled batches are saved
+// again, in order and before any new batch, once the breaker lets a save through.
+func NewSpillingBreakerSink(sink Sink, breaker *breaker.Breaker, spill *SinkSpill) *BreakerSink {
+	return &BreakerSink{sink: sink, breaker: breaker, divert: spill.Divert, spill: spill}
+}
+
+// Save saves batch to the sink unless the breaker is open. Batches the sink rejects as invalid do not
+// count against the sink and are not diverted, sending them again would fail the same way.
+// Diverted batches still return an error, so the save is reported as failed.
+func (s *BreakerSink) Save(batch models.SentDataByBatched) error {
+	if !s.breaker.Allow() {
+		return s.fallback(batch, breaker.ErrOpen)
+	}
+
+	// Spilled batches go first, a new batch queues up behind them while they cannot be saved
+	var err error
+	if s.spill != nil {
+		err = s.spill.Replay(s.sink.Save)
+	}
+	if err == nil {
+		err = s.sink.Save(batch)
+	}
+	if err == nil || retry.Rejected(err) {
+		if s.breaker.Success() {
+			log.Printf("sink %s recovered", s.breaker.Name())
+		}
+		return err
+	}
+	if s.breaker.Failure(err) {
+		log.Printf("sink %s keeps failing, sending one probe batch per probe interval until it recovers: %v", s.breaker.Name(), err)
+	}
+	return s.fallback(batch, err)
+}
+
+// fallback diverts batch after the save failed with err.
+func (s *BreakerSink) fallback(batch models.SentDataByBatched, err error) error {
+	if s.divert == nil {
+		return err
+	}
+	if divertErr := s.divert(batch); divertErr != nil {
+		return fmt.Errorf("%v, and diverting failed: %v", err, divertErr)
+	}
+	return fmt.Errorf("%v, diverted %d samples", err, len(batch.Timestamps))
+}
+
+func (s *BreakerSink) Close() error {
+	return s.sink.Close()
+}
+
+// BatchPolicy decides when the aggregators save a batch: once it holds Size samples, once its oldest
+// sample has waited MaxAge, or before its JSON payload would grow past MaxBytes.
+type BatchPolicy struct {
+	Size     int
+	MaxAge   time.Duration // 0 only saves on size
+	MaxBytes int           // 0 is unlimited
+}
+
+// batchOverhead is the JSON size of an empty models.SentDataByBatched.
+const batchOverhead = len(`{"timestamps":[],"measurements_list":[],"data_types_list":[],"values_list":[],"is_aligned":false,"devices":[]}`)
+
+// sampleBytes estimates what data adds to the JSON payload of a batch, separators included.
+func sampleBytes(data models.SentData) int {
+	size := len(strconv.FormatInt(data.Timestamps, 10)) + 5
+	for _, field := range []interface{}{data.MeasurementsList, data.DataTypesList, data.ValuesList, data.Devices} {
+		encoded, _ := json.Marshal(field)
+		size += len(encoded)
+	}
+	return size
+}
+
+// AggregateAndSaveToSink reads from messageQueue and saves the samples to sink in batches following policy,
+// until ctx is done or the queue is closed. It sends a heartbeat to monitor as saverName every second,
+// even while the queue is empty, and reports the outcome of every save as sinkName.
+// Every save is counted in metrics.BatchFlushes under sinkName with the reason of the flush.
+func AggregateAndSaveToSink(ctx context.Context, messageQueue <-chan models.SentData, sink Sink, policy BatchPolicy, monitor *health.Monitor, sinkName, saverName string) {
+	var batch models.SentDataByBatched
+	size := batchOverhead
+
+	heartbeat := time.NewTicker(1 * time.Second)
+	defer heartbeat.Stop()
+	monitor.Beat(saverName)
+
+	// maxAge fires once the oldest sample of the batch has waited policy.MaxAge
+	var maxAge *time.Timer
+	var expired <-chan time.Time
+	defer func() {
+		if maxAge != nil {
+			maxAge.Stop()
+		}
+	}()
+
+	save := func(reason string) {
+		if maxAge != nil {
+			maxAge.Stop()
+			maxAge, expired = nil, nil
+		}
+		if err := sink.Save(batch); err != nil {
+			fmt.Printf("failed to save batch data: %v\n", err)
+			monitor.Failure(sinkName, err)
+		} else {
+			monitor.Success(sinkName)
+		}
+		metrics.BatchFlushes.WithLabelValues(sinkName, reason).Inc()
+		metrics.BatchSamples.WithLabelValues(sinkName).Observe(float64(len(batch.Timestamps)))
+		metrics.BatchBytes.WithLabelValues(sinkName).Observe(float64(size))
+
+		batch = models.SentDataByBatched{} // Reset batch
+		size = batchOverhead
+		monitor.Beat(saverName)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// 時間結束時，送出最後一次請求
+			if len(batch.Timestamps) > 0 {
+				save("shutdown")
+			}
+			return
+
+		case <-heartbeat.C:
+			monitor.Beat(saverName)
+
+		case <-expired:
+			save("age")
+
+		case data, ok := <-messageQueue:
+			if !ok {
+				// The queue was closed, save what is left
+				if len(batch.Timestamps) > 0 {
+					save("shutdown")
+				}
+				return
+			}
+
+			// Save first if the sample would take the payload over the limit,
+			// a single sample larger than the limit is still sent on its own
+			added := sampleBytes(data)
+			if policy.MaxBytes > 0 && len(batch.Timestamps) > 0 && size+added > policy.MaxBytes {
+				save("bytes")
+			}
+
+			batch.Timestamps = append(batch.Timestamps, data.Timestamps)
+			batch.MeasurementsList = append(batch.MeasurementsList, data.MeasurementsList)
+			batch.DataTypesList = append(batch.DataTypesList, data.DataTypesList)
+			batch.ValuesList = append(batch.ValuesList, data.ValuesList)
+			batch.IsAligned = data.IsAligned
+			batch.Devices = append(batch.Devices, data.Devices)
+			size += added
+
+			if len(batch.Timestamps) == 1 && policy.MaxAge > 0 {
+				maxAge = time.NewTimer(policy.MaxAge)
+				expired = maxAge.C
+			}
+
+			if len(batch.Timestamps) >= policy.Size {
+				save("size")
+			}
+		}
+	}
+}
+
+// SaveByDevice saves the samples of messageQueue to sink with workers savers, each aggregating its own batch,
+// until the queue is closed. Samples are routed by device, so the samples of one device are always saved by
+// the same saver and in the order they were queued. The savers keep draining the queue once ctx is done, so
+// that nothing queued before shutdown is lost, and save their last batches once it is closed. The savers
+// send heartbeats to monitor as "saver/<n>" and report their saves as "sink". SaveByDevice returns once
+// every saver has returned.
+func SaveByDevice(ctx context.Context, messageQueue <-chan models.SentData, sink Sink, workers int, policy BatchPolicy, monitor *health.Monitor) {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	var wg sync.WaitGroup
+	shards := make([]chan models.SentData, workers)
+	for i := range shards {
+		shards[i] = make(chan models.SentData, policy.Size)
+		wg.Add(1)
+		go func(id int, shard <-chan models.SentData) {
+			defer wg.Done()
+			AggregateAndSaveToSink(ctx, shard, sink, policy, monitor, "sink", fmt.Sprintf("saver/%d", id))
+		}(i+1, shards[i])
+	}
+
+	for data := range messageQueue {
+		hash := fnv.New32a()
+		hash.Write([]byte(data.Devices))
+		shards[hash.Sum32()%uint32(workers)] <- data
+	}
+	for _, shard := range shards {
+		close(shard)
+	}
+	wg.Wait()
+}
+
+// Tap feeds an additional sink from the dispatcher, next to the savers of the message queue.
+// Samples are dropped while its buffer is full, so a slow sink never holds back the pipeline.
+type Tap struct {
+	name    string
+	sink    Sink
+	queue   chan models.SentData
+	dropped uint64
+}
+
+// NewTap creates a tap holding up to buffer samples for sink.
+func NewTap(name string, sink Sink, buffer int) *Tap {
+	return &Tap{name: name, sink: sink, queue: make(chan models.SentData, buffer)}
+}
+
+// Observe queues data for the sink without blocking.
+func (t *Tap) Observe(data models.SentData) {
+	select {
+	case t.queue <- data:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// Close is called by the dispatcher once the pipeline is drained, Run then saves what is left.
+func (t *Tap) Close() {
+	close(t.queue)
+}
+
+// Dropped returns the number of samples dropped because the buffer was full.
+func (t *Tap) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Run saves the queued samples in batches following policy until the tap is closed, then closes the sink.
+// Cancelling ctx does not stop it, the dispatcher closes the tap once the pipeline is drained, so the
+// samples still queued at shutdown are saved too. Saves are reported to monitor as "sink/<name>".
+func (t *Tap) Run(ctx context.Context, policy BatchPolicy, monitor *health.Monitor) {
+	AggregateAndSaveToSink(context.WithoutCancel(ctx), t.queue, t.sink, policy, monitor, "sink/"+t.name, "saver/"+t.name)
+	if dropped := t.Dropped(); dropped > 0 {
+		fmt.Printf("sink %s dropped %d samples\n", t.name, dropped)
+	}
+	if err := t.sink.Close(); err != nil {
+		fmt.Printf("failed to close sink %s: %v\n", t.name, err)
+	}
+}
@@ -0,0 +1,261 @@
+package saveData
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/tool/metrics"
+	"example.com/tool/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// memorySink keeps the samples of every saved batch.
+type memorySink struct {
+	mu      sync.Mutex
+	samples []models.SentData
+	closed  bool
+}
+
+func (s *memorySink) Save(batch models.SentDataByBatched) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range batch.Timestamps {
+		s.samples = append(s.samples, models.SentData{
+			Timestamps:       batch.Timestamps[i],
+			MeasurementsList: batch.MeasurementsList[i],
+			DataTypesList:    batch.DataTypesList[i],
+			ValuesList:       batch.ValuesList[i],
+			IsAligned:        batch.IsAligned,
+			Devices:          batch.Devices[i],
+		})
+	}
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *memorySink) saved() []models.SentData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.SentData(nil), s.samples...)
+}
+
+func sample(device string, timestamp int64) models.SentData {
+	return models.SentData{
+		Timestamps:       timestamp,
+		MeasurementsList: []string{"kw"},
+		DataTypesList:    []string{"DOUBLE"},
+		ValuesList:       []float64{float64(timestamp)},
+		IsAligned:        true,
+		Devices:          device,
+	}
+}
+
+func TestTapDrainsAfterCancel(t *testing.T) {
+	sink := &memorySink{}
+	tap := NewTap("test", sink, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tap.Run(ctx, BatchPolicy{Size: 10}, nil)
+	}()
+
+	for i := 0; i < 25; i++ {
+		tap.Observe(sample("root.test.equipment1", int64(i)))
+	}
+	// Shutdown cancels ctx before the dispatcher closes the tap
+	cancel()
+	for i := 25; i < 50; i++ {
+		tap.Observe(sample("root.test.equipment1", int64(i)))
+	}
+	tap.Close()
+	<-done
+
+	saved := sink.saved()
+	if len(saved) != 50 {
+		t.Fatalf("saved %d samples, want 50", len(saved))
+	}
+	for i, data := range saved {
+		if data.Timestamps != int64(i) {
+			t.Fatalf("sample %d has timestamp %d", i, data.Timestamps)
+		}
+	}
+	if !sink.closed {
+		t.Error("sink not closed")
+	}
+}
+
+func TestSaveByDevice(t *testing.T) {
+	const devices, perDevice, producers = 64, 500, 4
+
+	sink := &memorySink{}
+	messageQueue := make(chan models.SentData, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		SaveByDevice(context.Background(), messageQueue, sink, 8, BatchPolicy{Size: 16, MaxAge: time.Millisecond}, nil)
+	}()
+
+	// Every producer owns a quarter of the devices and queues their samples as fast as it can
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for seq := 0; seq < perDevice; seq++ {
+				for d := p; d < devices; d += producers {
+					messageQueue <- sample(fmt.Sprintf("root.test.equipment%d", d), int64(seq))
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+	close(messageQueue)
+	<-done
+
+	saved := sink.saved()
+	if len(saved) != devices*perDevice {
+		t.Errorf("saved %d samples, want %d", len(saved), devices*perDevice)
+	}
+	// Every device is saved in order, without gaps or duplicates
+	next := make(map[string]int64)
+	for _, data := range saved {
+		if data.Timestamps != next[data.Devices] {
+			t.Fatalf("%s: saved sample %d, want %d", data.Devices, data.Timestamps, next[data.Devices])
+		}
+		next[data.Devices]++
+	}
+	if len(next) != devices {
+		t.Errorf("saved %d devices, want %d", len(next), devices)
+	}
+}
+
+func TestSaveByDeviceDrainsAfterCancel(t *testing.T) {
+	sink := &memorySink{}
+	messageQueue := make(chan models.SentData, 1000)
+	for i := 0; i < 500; i++ {
+		messageQueue <- sample(fmt.Sprintf("root.test.equipment%d", i%10), int64(i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		SaveByDevice(ctx, messageQueue, sink, 4, BatchPolicy{Size: 16}, nil)
+	}()
+
+	// Shutdown cancels ctx, then polls finishing late and the spill refill still queue samples
+	// until the dispatcher closes the queue
+	cancel()
+	for i := 500; i < 1000; i++ {
+		messageQueue <- sample(fmt.Sprintf("root.test.equipment%d", i%10), int64(i))
+	}
+	close(messageQueue)
+	<-done
+
+	if saved := sink.saved(); len(saved) != 1000 {
+		t.Errorf("saved %d samples, want 1000", len(saved))
+	}
+}
+
+// batchSink keeps every saved batch.
+type batchSink struct {
+	mu      sync.Mutex
+	batches []models.SentDataByBatched
+}
+
+func (s *batchSink) Save(batch models.SentDataByBatched) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *batchSink) Close() error {
+	return nil
+}
+
+func (s *batchSink) saved() []models.SentDataByBatched {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.SentDataByBatched(nil), s.batches...)
+}
+
+func TestAggregateFlushesLoneSampleOnAge(t *testing.T) {
+	sink := &batchSink{}
+	queue := make(chan models.SentData)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		AggregateAndSaveToSink(context.Background(), queue, sink, BatchPolicy{Size: 100, MaxAge: 50 * time.Millisecond}, nil, "test-age", "saver/test-age")
+	}()
+
+	started := time.Now()
+	queue <- sample("root.test.equipment1", 1)
+	for len(sink.saved()) == 0 && time.Since(started) < 2*time.Second {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Errorf("saved after %v, before MaxAge", elapsed)
+	}
+	if batches := sink.saved(); len(batches) != 1 || len(batches[0].Timestamps) != 1 {
+		t.Fatalf("saved %+v", batches)
+	}
+	close(queue)
+	<-done
+
+	if flushes := testutil.ToFloat64(metrics.BatchFlushes.WithLabelValues("test-age", "age")); flushes != 1 {
+		t.Errorf("%v flushes with reason age", flushes)
+	}
+	if flushes := testutil.ToFloat64(metrics.BatchFlushes.WithLabelValues("test-age", "shutdown")); flushes != 0 {
+		t.Errorf("%v flushes with reason shutdown for an empty batch", flushes)
+	}
+}
+
+func TestAggregateCutsBatchesBeforeMaxBytes(t *testing.T) {
+	data := sample("root.test.equipment1", 1000000)
+	maxBytes := batchOverhead + 3*sampleBytes(data) + sampleBytes(data)/2 // room for 3 samples
+
+	sink := &batchSink{}
+	queue := make(chan models.SentData, 10)
+	for i := 0; i < 10; i++ {
+		queue <- sample("root.test.equipment1", int64(1000000+i))
+	}
+	close(queue)
+	AggregateAndSaveToSink(context.Background(), queue, sink, BatchPolicy{Size: 100, MaxBytes: maxBytes}, nil, "test-bytes", "saver/test-bytes")
+
+	var sizes []int
+	total := 0
+	for _, batch := range sink.saved() {
+		payload, err := json.Marshal(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payload) > maxBytes {
+			t.Errorf("payload of %d bytes over the limit of %d", len(payload), maxBytes)
+		}
+		sizes = append(sizes, len(batch.Timestamps))
+		total += len(batch.Timestamps)
+	}
+	if !reflect.DeepEqual(sizes, []int{3, 3, 3, 1}) || total != 10 {
+		t.Errorf("batches of %v samples", sizes)
+	}
+	if flushes := testutil.ToFloat64(metrics.BatchFlushes.WithLabelValues("test-bytes", "bytes")); flushes != 3 {
+		t.Errorf("%v flushes with reason bytes", flushes)
+	}
+	if flushes := testutil.ToFloat64(metrics.BatchFlushes.WithLabelValues("test-bytes", "shutdown")); flushes != 1 {
+		t.Errorf("%v flushes with reason shutdown", flushes)
+	}
+}
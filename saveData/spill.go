@@ -0,0 +1,165 @@
+package saveData
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"example.com/tool/models"
+	"example.com/tool/retry"
+)
+
+// SinkSpill keeps the batches a sink could not take in a JSON lines file, one batch per line, until
+// they are replayed into the sink. Batches still in the file at shutdown are replayed after the next start.
+type SinkSpill struct {
+	path string
+
+	mu      sync.Mutex
+	pending int
+}
+
+// SinkSpillPath returns the spill file of the sink name in dir, e.g. spill/sink-file-csv.jsonl.
+func SinkSpillPath(dir, name string) string {
+	return filepath.Join(dir, "sink-"+strings.NewReplacer("/", "-", "\\", "-").Replace(name)+".jsonl")
+}
+
+// OpenSinkSpill opens the spill file at path, creating its directory if needed, and counts the
+// batches it already holds.
+func OpenSinkSpill(path string) (*SinkSpill, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %v", err)
+	}
+	// A temporary file left by a crash while replaying is incomplete, the spill file is still whole
+	stale, _ := filepath.Glob(path + ".*.tmp")
+	for _, name := range stale {
+		os.Remove(name)
+	}
+
+	s := &SinkSpill{path: path}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill file: %v", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			s.pending++
+		}
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spill file: %v", err)
+		}
+	}
+}
+
+// Pending returns the number of batches in the spill file.
+func (s *SinkSpill) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Divert appends batch to the spill file.
+func (s *SinkSpill) Divert(batch models.SentDataByBatched) error {
+	line, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open spill file: %v", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write spill file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write spill file: %v", err)
+	}
+	s.pending++
+	return nil
+}
+
+// Replay saves the spilled batches with save in the order they were diverted and removes the file
+// once all of them are saved. Batches save rejects as invalid are dropped, sending them again would
+// fail the same way. On any other error Replay stops, keeps the batches from the failed one on and
+// returns the error.
+func (s *SinkSpill) Replay(save func(batch models.SentDataByBatched) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == 0 {
+		return nil
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open spill file: %v", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("failed to read spill file: %v", readErr)
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var batch models.SentDataByBatched
+			if err := json.Unmarshal(line, &batch); err != nil {
+				log.Printf("dropping unreadable batch of spill file %s: %v", s.path, err)
+			} else if err := save(batch); err != nil && !retry.Rejected(err) {
+				if keepErr := s.keep(line, reader); keepErr != nil {
+					return fmt.Errorf("%v, and keeping the spill file failed: %v", err, keepErr)
+				}
+				return err
+			}
+			s.pending--
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	s.pending = 0
+	if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("failed to remove spill file: %v", err)
+	}
+	return nil
+}
+
+// keep replaces the spill file with first and the rest of reader. The caller must hold mu.
+func (s *SinkSpill) keep(first []byte, rest io.Reader) error {
+	temp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = temp.Write(first); err == nil {
+		_, err = io.Copy(temp, rest)
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), s.path)
+}
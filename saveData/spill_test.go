@@ -0,0 +1,161 @@
+package saveData
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"example.com/tool/breaker"
+	"example.com/tool/models"
+	"example.com/tool/retry"
+)
+
+func batchOf(timestamps ...int64) models.SentDataByBatched {
+	var batch models.SentDataByBatched
+	for _, timestamp := range timestamps {
+		data := sample("root.test.equipment1", timestamp)
+		batch.Timestamps = append(batch.Timestamps, data.Timestamps)
+		batch.MeasurementsList = append(batch.MeasurementsList, data.MeasurementsList)
+		batch.DataTypesList = append(batch.DataTypesList, data.DataTypesList)
+		batch.ValuesList = append(batch.ValuesList, data.ValuesList)
+		batch.IsAligned = data.IsAligned
+		batch.Devices = append(batch.Devices, data.Devices)
+	}
+	return batch
+}
+
+func timestamps(samples []models.SentData) []int64 {
+	var got []int64
+	for _, data := range samples {
+		got = append(got, data.Timestamps)
+	}
+	return got
+}
+
+// flakySink fails every save with err while err is set, and otherwise saves to memorySink.
+type flakySink struct {
+	memorySink
+	err error
+}
+
+func (s *flakySink) Save(batch models.SentDataByBatched) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.memorySink.Save(batch)
+}
+
+func TestSinkSpillPath(t *testing.T) {
+	if got := SinkSpillPath("spill", "file/csv"); got != filepath.Join("spill", "sink-file-csv.jsonl") {
+		t.Errorf("path %s", got)
+	}
+}
+
+func TestSinkSpillReplayKeepsOrderAcrossRestarts(t *testing.T) {
+	path := SinkSpillPath(t.TempDir(), "influx")
+	spill, err := OpenSinkSpill(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 4; i++ {
+		if err := spill.Divert(batchOf(2*i, 2*i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The sink fails on the third batch, the first two are saved and the rest is kept
+	sink := &memorySink{}
+	down := errors.New("influx down")
+	saved := 0
+	err = spill.Replay(func(batch models.SentDataByBatched) error {
+		if saved == 2 {
+			return down
+		}
+		saved++
+		return sink.Save(batch)
+	})
+	if err != down {
+		t.Fatalf("replay returned %v", err)
+	}
+	if spill.Pending() != 2 {
+		t.Fatalf("%d batches pending, want 2", spill.Pending())
+	}
+
+	// The next start finds what is left
+	spill, err = OpenSinkSpill(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spill.Pending() != 2 {
+		t.Fatalf("reopened with %d batches pending, want 2", spill.Pending())
+	}
+	if err := spill.Replay(sink.Save); err != nil {
+		t.Fatal(err)
+	}
+	if got := timestamps(sink.saved()); !reflect.DeepEqual(got, []int64{0, 1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("saved %v", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("replayed spill file kept: %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 0 {
+		t.Errorf("spill directory holds %v", entries)
+	}
+}
+
+func TestSinkSpillReplayDropsRejectedBatches(t *testing.T) {
+	spill, err := OpenSinkSpill(SinkSpillPath(t.TempDir(), "mqtt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spill.Divert(batchOf(1))
+	spill.Divert(batchOf(2))
+
+	var attempts int
+	err = spill.Replay(func(batch models.SentDataByBatched) error {
+		attempts++
+		if batch.Timestamps[0] == 1 {
+			return &retry.StatusError{StatusCode: 400, Status: "400 Bad Request"}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 || spill.Pending() != 0 {
+		t.Errorf("replay returned %v after %d attempts with %d pending", err, attempts, spill.Pending())
+	}
+}
+
+func TestSpillingBreakerSinkDivertsWhileOpen(t *testing.T) {
+	spill, err := OpenSinkSpill(SinkSpillPath(t.TempDir(), "influx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &flakySink{err: errors.New("influx down")}
+	b := breaker.NewSet("sink", models.BreakerConfig{FailureThreshold: 1, ProbeIntervalMs: 60000}).Get("influx")
+	s := NewSpillingBreakerSink(sink, b, spill)
+
+	// The failed save opens the breaker, later batches are spilled at once
+	for i := int64(0); i < 3; i++ {
+		if err := s.Save(batchOf(i)); err == nil {
+			t.Fatalf("save of batch %d succeeded with the sink down", i)
+		}
+	}
+	if spill.Pending() != 3 {
+		t.Fatalf("%d batches spilled, want 3", spill.Pending())
+	}
+
+	// Back up, the spilled batches are saved before the new one
+	sink.err = nil
+	b.Reset()
+	if err := s.Save(batchOf(3)); err != nil {
+		t.Fatal(err)
+	}
+	if got := timestamps(sink.saved()); !reflect.DeepEqual(got, []int64{0, 1, 2, 3}) {
+		t.Errorf("saved %v", got)
+	}
+	if spill.Pending() != 0 {
+		t.Errorf("%d batches still spilled", spill.Pending())
+	}
+}
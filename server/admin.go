@@ -0,0 +1,100 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"example.com/tool/models"
+	"example.com/tool/registry"
+	"github.com/gin-gonic/gin"
+)
+
+// requireToken rejects requests without a known admin bearer token and stores the operator name as "actor".
+// Every configured token is compared in constant time.
+func (s *Server) requireToken(c *gin.Context) {
+	header := c.GetHeader("Authorization")
+	token, found := strings.CutPrefix(header, "Bearer ")
+	actor, ok := "", false
+	if found && token != "" {
+		for known, operator := range s.Config.AdminTokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+				actor, ok = operator, true
+			}
+		}
+	}
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing admin token"})
+		return
+	}
+	c.Set("actor", actor)
+}
+
+// requireStoreSource rejects registry changes unless the scheduler polls the devices of the registry,
+// with the index ranges they would be stored but never take effect.
+func (s *Server) requireStoreSource(c *gin.Context) {
+	if s.Config.DeviceSource != "store" {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": `registry changes need "deviceSource": "store", devices are polled by index range`})
+	}
+}
+
+// listRegistry returns every registered device, enabled or not.
+func (s *Server) listRegistry(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"devices": s.Registry.Devices()})
+}
+
+// createDevice registers a new device.
+func (s *Server) createDevice(c *gin.Context) {
+	var device models.Device
+	if err := c.ShouldBindJSON(&device); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := s.Registry.Create(device, c.GetString("actor"))
+	if err != nil {
+		registryError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// updateDevice enables, disables, moves or re-profiles a device.
+func (s *Server) updateDevice(c *gin.Context) {
+	var patch models.DevicePatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := s.Registry.Update(c.Param("name"), patch, c.GetString("actor"))
+	if err != nil {
+		registryError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// deleteDevice removes a device from the registry.
+func (s *Server) deleteDevice(c *gin.Context) {
+	if err := s.Registry.Delete(c.Param("name"), c.GetString("actor")); err != nil {
+		registryError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// registryError maps registry errors to HTTP status codes.
+func registryError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, registry.ErrExists):
+		status = http.StatusConflict
+	case errors.Is(err, registry.ErrInvalid):
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/tool/breaker"
+	"example.com/tool/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireToken(t *testing.T) {
+	s := &Server{
+		Config:   models.Config{AdminTokens: map[string]string{"secret": "alice"}},
+		Breakers: []*breaker.Set{breaker.NewSet("device", models.BreakerConfig{})},
+	}
+	router := s.Router()
+
+	for _, tc := range []struct {
+		header string
+		want   int
+	}{
+		{"Bearer secret", http.StatusOK},
+		{"secret", http.StatusUnauthorized}, // the Bearer prefix is required
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer ", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/breakers", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("Authorization %q: status %d, want %d", tc.header, w.Code, tc.want)
+		}
+	}
+}
+
+func TestRequireStoreSource(t *testing.T) {
+	s := &Server{Config: models.Config{DeviceSource: "ranges"}}
+
+	w := httptest.NewRecorder()
+	c, _ := newTestContext(w)
+	s.requireStoreSource(c)
+	if w.Code != http.StatusConflict || !c.IsAborted() {
+		t.Fatalf("registry change with index ranges: status %d, aborted %v", w.Code, c.IsAborted())
+	}
+
+	s.Config.DeviceSource = "store"
+	w = httptest.NewRecorder()
+	c, _ = newTestContext(w)
+	s.requireStoreSource(c)
+	if c.IsAborted() {
+		t.Fatal("registry change with the store as device source was refused")
+	}
+}
+
+func newTestContext(w *httptest.ResponseRecorder) (*gin.Context, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	c, engine := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/devices", nil)
+	return c, engine
+}
@@ -0,0 +1,13 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// activeAlarms returns the active-alarm table.
+func (s *Server) activeAlarms(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"serverTime": time.Now(), "alarms": s.Alarms.Active()})
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"example.com/tool/breaker"
+	"example.com/tool/models"
+	"github.com/gin-gonic/gin"
+)
+
+// listBreakers returns the state of the device and sink breakers, only the open ones with ?open=true.
+func (s *Server) listBreakers(c *gin.Context) {
+	openOnly := c.Query("open") == "true"
+	breakers := []models.BreakerStatus{}
+	for _, set := range s.Breakers {
+		breakers = append(breakers, set.Snapshot(openOnly)...)
+	}
+	c.JSON(http.StatusOK, gin.H{"breakers": breakers})
+}
+
+// resetBreaker closes the breaker of ?name= among the breakers of :kind, e.g. once a device was repaired.
+func (s *Server) resetBreaker(c *gin.Context) {
+	var set *breaker.Set
+	for _, candidate := range s.Breakers {
+		if candidate.Kind() == c.Param("kind") {
+			set = candidate
+		}
+	}
+	if set == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown breaker kind: " + c.Param("kind")})
+		return
+	}
+
+	name := c.Query("name")
+	b, ok := set.Lookup(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no breaker for " + name})
+		return
+	}
+	b.Reset()
+	log.Printf("%s breaker of %s reset by %s", set.Kind(), name, c.GetString("actor"))
+	c.JSON(http.StatusOK, b.Status())
+}
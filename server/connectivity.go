@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listStatus returns the connectivity state of every device, only of those in ?state= if given.
+func (s *Server) listStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"serverTime": time.Now(), "devices": s.Connectivity.Snapshot(c.Query("state"))})
+}
+
+// deviceStatus returns the connectivity state of a single device.
+func (s *Server) deviceStatus(c *gin.Context) {
+	status, ok := s.Connectivity.Get(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
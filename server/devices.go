@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"example.com/tool/models"
+	"github.com/gin-gonic/gin"
+)
+
+// listDevices returns every cached device, optionally filtered by the device-path prefix given in ?prefix=.
+func (s *Server) listDevices(c *gin.Context) {
+	entries := s.Latest.List(c.Query("prefix"))
+
+	devices := make([]models.DeviceSummary, 0, len(entries))
+	for _, entry := range entries {
+		devices = append(devices, summarize(entry))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"serverTime": time.Now(), "devices": devices})
+}
+
+// latestDevice returns every measurement of the last sample of a device.
+func (s *Server) latestDevice(c *gin.Context) {
+	entry, ok := s.Latest.Get(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	latest := models.DeviceLatest{
+		DeviceSummary: summarize(entry),
+		Values:        make(map[string]float64, len(entry.Data.MeasurementsList)),
+	}
+	for i, measurement := range entry.Data.MeasurementsList {
+		latest.Values[measurement] = entry.Data.ValuesList[i]
+	}
+
+	c.JSON(http.StatusOK, latest)
+}
+
+// latestPoint returns a single measurement of the last sample of a device.
+func (s *Server) latestPoint(c *gin.Context) {
+	entry, ok := s.Latest.Get(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	measurement := c.Param("measurement")
+	for i, name := range entry.Data.MeasurementsList {
+		if name == measurement {
+			c.JSON(http.StatusOK, models.PointLatest{
+				DeviceSummary: summarize(entry),
+				Measurement:   measurement,
+				Value:         entry.Data.ValuesList[i],
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "measurement not found"})
+}
+
+func summarize(entry models.LatestEntry) models.DeviceSummary {
+	return models.DeviceSummary{
+		Name:       entry.Name,
+		Device:     entry.Data.Devices,
+		Timestamp:  entry.Data.Timestamps,
+		Time:       time.UnixMilli(entry.Data.Timestamps),
+		ReceivedAt: entry.ReceivedAt,
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/tool/models"
+	"github.com/gin-gonic/gin"
+)
+
+// readinessComponents must all be healthy before the collector reports ready.
+var readinessComponents = []string{"config", "sink"}
+
+// healthz reports liveness: every scheduler and saver goroutine must have sent a recent heartbeat.
+func (s *Server) healthz(c *gin.Context) {
+	stall := time.Duration(s.Config.StallSeconds) * time.Second
+	response := models.HealthResponse{
+		Status:     "ok",
+		CheckedAt:  time.Now(),
+		Components: make(map[string]models.ComponentStatus),
+	}
+
+	for name, status := range s.Monitor.Snapshot(stall) {
+		if status.LastBeat == nil {
+			continue
+		}
+		response.Components[name] = status
+		if !status.Healthy {
+			response.Status = "stalled"
+		}
+	}
+
+	c.JSON(statusCode(response.Status == "ok"), response)
+}
+
+// readyz reports readiness: config loaded, sink reachable and queue below its high-water mark.
+func (s *Server) readyz(c *gin.Context) {
+	stall := time.Duration(s.Config.StallSeconds) * time.Second
+	snapshot := s.Monitor.Snapshot(stall)
+	response := models.HealthResponse{
+		Status:     "ready",
+		CheckedAt:  time.Now(),
+		Components: make(map[string]models.ComponentStatus),
+		Queue:      s.queueStatus(),
+	}
+
+	var notReady []string
+	for _, name := range readinessComponents {
+		status := snapshot[name]
+		response.Components[name] = status
+		if !status.Healthy {
+			notReady = append(notReady, name)
+		}
+	}
+	if !response.Queue.Healthy {
+		notReady = append(notReady, "queue")
+	}
+
+	if len(notReady) > 0 {
+		response.Status = "not ready: " + strings.Join(notReady, ", ")
+	}
+
+	c.JSON(statusCode(len(notReady) == 0), response)
+}
+
+// queueStatus reports the message queue fill level against the configured high-water mark.
+func (s *Server) queueStatus() *models.QueueStatus {
+	status := &models.QueueStatus{
+		Length:    len(s.Queue),
+		Capacity:  cap(s.Queue),
+		HighWater: s.Config.QueueHighWater,
+	}
+	status.Healthy = status.Length < status.HighWater
+	return status
+}
+
+func statusCode(healthy bool) int {
+	if healthy {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
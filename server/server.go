@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"example.com/tool/alarm"
+	"example.com/tool/breaker"
+	"example.com/tool/cache"
+	"example.com/tool/connectivity"
+	"example.com/tool/health"
+	"example.com/tool/metrics"
+	"example.com/tool/models"
+	"example.com/tool/registry"
+	"example.com/tool/stream"
+	"github.com/gin-gonic/gin"
+)
+
+// Server exposes the collector's HTTP endpoints.
+// Optional components left nil do not get their routes registered.
+type Server struct {
+	Config       models.Config
+	Monitor      *health.Monitor
+	Queue        chan models.SentData
+	Latest       *cache.Latest
+	Hub          *stream.Hub
+	Alarms       *alarm.Engine
+	Registry     *registry.Registry
+	Breakers     []*breaker.Set
+	Connectivity *connectivity.Tracker
+}
+
+// Router builds the gin engine with all routes of the collector.
+func (s *Server) Router() *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	router.GET("/healthz", s.healthz)
+	router.GET("/readyz", s.readyz)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	if s.Latest != nil {
+		router.GET("/devices", s.listDevices)
+		router.GET("/devices/:name/latest", s.latestDevice)
+		router.GET("/devices/:name/points/:measurement", s.latestPoint)
+	}
+
+	if s.Hub != nil {
+		router.GET("/stream/sse", s.streamSSE)
+		router.GET("/stream/ws", s.streamWebSocket)
+	}
+
+	if s.Alarms != nil {
+		router.GET("/alarms/active", s.activeAlarms)
+	}
+
+	if s.Connectivity != nil {
+		router.GET("/status", s.listStatus)
+		router.GET("/status/:name", s.deviceStatus)
+	}
+
+	// The admin API is only served when tokens are configured
+	if len(s.Config.AdminTokens) > 0 {
+		admin := router.Group("/", s.requireToken)
+		if s.Registry != nil {
+			admin.GET("/registry/devices", s.listRegistry)
+			admin.POST("/devices", s.requireStoreSource, s.createDevice)
+			admin.PATCH("/devices/:name", s.requireStoreSource, s.updateDevice)
+			admin.DELETE("/devices/:name", s.requireStoreSource, s.deleteDevice)
+		}
+		if len(s.Breakers) > 0 {
+			admin.GET("/breakers", s.listBreakers)
+			admin.POST("/breakers/:kind/reset", s.resetBreaker)
+		}
+	}
+
+	return router
+}
+
+// Run serves HTTP on the configured address until ctx is done.
+func (s *Server) Run(ctx context.Context) {
+	srv := &http.Server{
+		Addr:    s.Config.HttpListen,
+		Handler: s.Router(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("HTTP server listening on %s", s.Config.HttpListen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("HTTP server stopped: %v", err)
+	}
+}
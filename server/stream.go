@@ -0,0 +1,115 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/tool/models"
+	"example.com/tool/stream"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// writeTimeout bounds a single write to a live-stream client; slower clients are disconnected.
+const writeTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// subscribe creates a subscription from the ?devices=, ?measurements= and ?interval= query parameters.
+func (s *Server) subscribe(c *gin.Context) (*stream.Subscription, bool) {
+	var interval time.Duration
+	if value := c.Query("interval"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid interval: " + err.Error()})
+			return nil, false
+		}
+		interval = parsed
+	}
+
+	filter := models.StreamFilter{
+		Devices:      splitList(c.Query("devices")),
+		Measurements: splitList(c.Query("measurements")),
+	}
+	return s.Hub.Subscribe(filter, interval), true
+}
+
+// streamSSE streams matching samples as Server-Sent Events.
+func (s *Server) streamSSE(c *gin.Context) {
+	sub, ok := s.subscribe(c)
+	if !ok {
+		return
+	}
+	defer s.Hub.Unsubscribe(sub)
+
+	// Send the headers right away so that clients see the stream open before the first sample
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case sample, ok := <-sub.Samples():
+			if !ok {
+				return false
+			}
+			c.SSEvent("sample", sample)
+			return true
+		}
+	})
+}
+
+// streamWebSocket streams matching samples as JSON WebSocket messages.
+func (s *Server) streamWebSocket(c *gin.Context) {
+	sub, ok := s.subscribe(c)
+	if !ok {
+		return
+	}
+	defer s.Hub.Unsubscribe(sub)
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Read and discard client messages so that close frames are noticed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case sample, ok := <-sub.Samples():
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteJSON(sample); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
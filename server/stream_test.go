@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/tool/models"
+	"example.com/tool/stream"
+	"github.com/gorilla/websocket"
+)
+
+func newStreamServer(t *testing.T) (*stream.Hub, *httptest.Server) {
+	t.Helper()
+	hub := stream.NewHub(10)
+	server := httptest.NewServer((&Server{Hub: hub}).Router())
+	t.Cleanup(server.Close)
+	return hub, server
+}
+
+func streamSample(device string, timestamp int64) models.SentData {
+	return models.SentData{
+		Timestamps:       timestamp,
+		MeasurementsList: []string{"kw", "pf"},
+		DataTypesList:    []string{"DOUBLE", "DOUBLE"},
+		ValuesList:       []float64{1.5, 0.9},
+		IsAligned:        true,
+		Devices:          device,
+	}
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	hub, server := newStreamServer(t)
+
+	// The subscription exists once the connection is upgraded
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/stream/ws?devices=equipment1&measurements=kw"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	hub.Observe(streamSample("root.test.equipment2", 1000))
+	hub.Observe(streamSample("root.test.equipment1", 2000))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var sample models.StreamSample
+	if err := conn.ReadJSON(&sample); err != nil {
+		t.Fatal(err)
+	}
+	if sample.Device != "root.test.equipment1" || sample.Timestamp != 2000 || len(sample.Values) != 1 || sample.Values["kw"] != 1.5 {
+		t.Errorf("sample %+v", sample)
+	}
+}
+
+func TestStreamSSE(t *testing.T) {
+	hub, server := newStreamServer(t)
+
+	resp, err := http.Get(server.URL + "/stream/sse?devices=root.test.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	hub.Observe(streamSample("root.other.equipment1", 1000))
+	hub.Observe(streamSample("root.test.equipment1", 2000))
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var event string
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before the first sample")
+			}
+			if value, ok := strings.CutPrefix(line, "event:"); ok {
+				event = value
+				continue
+			}
+			data, ok := strings.CutPrefix(line, "data:")
+			if !ok {
+				continue
+			}
+			var sample models.StreamSample
+			if err := json.Unmarshal([]byte(data), &sample); err != nil {
+				t.Fatal(err)
+			}
+			if event != "sample" || sample.Device != "root.test.equipment1" || len(sample.Values) != 2 {
+				t.Errorf("event %q, sample %+v", event, sample)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("no sample streamed")
+		}
+	}
+}
+
+func TestStreamRejectsInvalidInterval(t *testing.T) {
+	_, server := newStreamServer(t)
+
+	resp, err := http.Get(server.URL + "/stream/sse?interval=often")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %d", resp.StatusCode)
+	}
+}
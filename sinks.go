@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/tool/breaker"
+	"example.com/tool/cache"
+	"example.com/tool/connectivity"
+	"example.com/tool/health"
+	"example.com/tool/models"
+	"example.com/tool/pipeline"
+	"example.com/tool/registry"
+	"example.com/tool/saveData"
+)
+
+// startSinks starts the sinks of config that run next to the database and returns them as
+// dispatcher observers. wait blocks until every sink has saved what it was handed and is closed.
+// Each sink saves through its breaker. While it is open, batches are diverted to the spill file of the
+// sink in config.SpillDir and saved again once the sink is back.
+func startSinks(ctx context.Context, config *models.Config, points models.ConfigPoint, profiles map[string]models.ConfigPoint, groupOf func(device string) string, monitor *health.Monitor, breakers *breaker.Set) (observers []pipeline.Observer, wait func()) {
+	var wg sync.WaitGroup
+	start := func(name string, sink saveData.Sink, buffer, batchSize int) {
+		spill, err := saveData.OpenSinkSpill(saveData.SinkSpillPath(config.SpillDir, name))
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		if pending := spill.Pending(); pending > 0 {
+			log.Printf("sink %s has %d spilled batches, saving them with the next batch", name, pending)
+		}
+		tap := saveData.NewTap(name, saveData.NewSpillingBreakerSink(sink, breakers.Get(name), spill), buffer)
+		observers = append(observers, tap)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tap.Run(ctx, batchPolicy(config, batchSize), monitor)
+		}()
+	}
+
+	if config.MQTTSink != nil {
+		sink, err := saveData.NewMQTTSink(*config.MQTTSink)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		start("mqtt", sink, config.MQTTSink.Buffer, config.MQTTSink.BatchSize)
+	}
+	if config.InfluxSink != nil {
+		if config.InfluxSink.Company == "" {
+			config.InfluxSink.Company = points.CommonSetting.Company
+		}
+		sink, err := saveData.NewInfluxSink(*config.InfluxSink)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		start("influx", sink, config.InfluxSink.Buffer, config.InfluxSink.BatchSize)
+	}
+	if config.RemoteWriteSink != nil {
+		if config.RemoteWriteSink.Company == "" {
+			config.RemoteWriteSink.Company = points.CommonSetting.Company
+		}
+		sink, err := saveData.NewRemoteWriteSink(*config.RemoteWriteSink)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		start("remote-write", sink, config.RemoteWriteSink.Buffer, config.RemoteWriteSink.BatchSize)
+	}
+	columns := saveData.Columns(profiles, connectivity.Measurements...)
+	for _, fileSink := range config.FileSinks {
+		sink, err := saveData.NewFileSink(fileSink, columns, groupOf)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		start("file/"+fileSink.Format, sink, fileSink.Buffer, fileSink.BatchSize)
+	}
+
+	return observers, wg.Wait
+}
+
+// dbSink is the sink of the database REST API at url. While its breaker is open, batches are
+// diverted to the spill file of backpressure. Spilled samples are only queued again when the breaker is
+// closed or due for a probe, so without new samples they are the probe.
+func dbSink(url string, backpressure *pipeline.Backpressure, breakers *breaker.Set) saveData.Sink {
+	dbBreaker := breakers.Get("db")
+	backpressure.HoldRefill(dbBreaker.Blocked)
+	return saveData.NewBreakerSink(saveData.RESTSink{URL: url}, dbBreaker, backpressure.Divert)
+}
+
+// batchPolicy saves batches of size samples, and smaller ones once their oldest sample waited flushIntervalMs.
+func batchPolicy(config *models.Config, size int) saveData.BatchPolicy {
+	return saveData.BatchPolicy{Size: size, MaxAge: time.Duration(config.FlushIntervalMs) * time.Millisecond}
+}
+
+// deviceGroup returns a function mapping a device path to its device group: the registry group of the
+// device with the store as device source, the index range of equipment<N> otherwise. Status series
+// belong to the group of the device they describe.
+func deviceGroup(source string, devices *registry.Registry) func(device string) string {
+	return func(device string) string {
+		device, _ = connectivity.DeviceOf(device)
+		name := cache.DeviceName(device)
+		if source == "store" && devices != nil {
+			if registered, ok := devices.Get(name); ok {
+				return registered.Group
+			}
+			return "unregistered"
+		}
+
+		index, err := strconv.Atoi(strings.TrimPrefix(name, "equipment"))
+		if err != nil || index < 1 {
+			return "other"
+		}
+		return strconv.Itoa((index-1)/1000 + 1)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestDeviceGroup(t *testing.T) {
+	groupOf := deviceGroup("ranges", nil)
+	for device, want := range map[string]string{
+		"root.test.equipment1":           "1",
+		"root.test.equipment1000":        "1",
+		"root.test.equipment1001":        "2",
+		"root.test.equipment1001.status": "2",
+		"root.test.pump":                 "other",
+	} {
+		if got := groupOf(device); got != want {
+			t.Errorf("group of %s: %s, want %s", device, got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"example.com/tool/models"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// Store is the relational metadata store holding the device registry, point profiles,
+// alarm definitions, alarm history and run history.
+type Store struct {
+	db *gorm.DB
+}
+
+// Open connects to the database and migrates the schema. dialect is "mysql" or "sqlite".
+func Open(dialect, dsn string) (*Store, error) {
+	var dialector gorm.Dialector
+	switch dialect {
+	case "mysql":
+		dialector = mysql.Open(dsn)
+	case "sqlite":
+		dialector = sqlite.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unknown store dialect: %s", dialect)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Warn)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %v", err)
+	}
+
+	err = db.AutoMigrate(
+		&models.Device{},
+		&models.PointProfile{},
+		&models.AlarmDefinition{},
+		&models.AlarmEventRecord{},
+		&models.RunHistory{},
+		&models.AuditLog{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate store schema: %v", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Devices returns every enabled device.
+func (s *Store) Devices() ([]models.Device, error) {
+	var devices []models.Device
+	if err := s.db.Where("enabled = ?", true).Order("id").Find(&devices).Error; err != nil {
+		return nil, fmt.Errorf("failed to load devices: %v", err)
+	}
+	return devices, nil
+}
+
+// CountDevices returns the number of registered devices, enabled or not.
+func (s *Store) CountDevices() (int64, error) {
+	var count int64
+	if err := s.db.Model(&models.Device{}).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count devices: %v", err)
+	}
+	return count, nil
+}
+
+// SeedRange registers equipment<start> to equipment<end> on port as an enabled group,
+// the way devices used to be addressed by index ranges. Existing devices are left alone.
+func (s *Store) SeedRange(group string, port, start, end int, profile string) error {
+	devices := make([]models.Device, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		devices = append(devices, models.Device{
+			Name:    fmt.Sprintf("equipment%d", i),
+			Group:   group,
+			Profile: profile,
+			Port:    port,
+			Enabled: true,
+		})
+	}
+
+	err := s.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(devices, 500).Error
+	if err != nil {
+		return fmt.Errorf("failed to seed devices: %v", err)
+	}
+	return nil
+}
+
+// AllDevices returns every registered device, enabled or not.
+func (s *Store) AllDevices() ([]models.Device, error) {
+	var devices []models.Device
+	if err := s.db.Order("id").Find(&devices).Error; err != nil {
+		return nil, fmt.Errorf("failed to load devices: %v", err)
+	}
+	return devices, nil
+}
+
+// SaveDevice creates device, or updates it if it already has an ID, and records the change in the audit log.
+func (s *Store) SaveDevice(device *models.Device, actor, action string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(device).Error; err != nil {
+			return fmt.Errorf("failed to save device %s: %v", device.Name, err)
+		}
+		return audit(tx, actor, action, device.Name, device)
+	})
+}
+
+// DeleteDevice removes device and records the change in the audit log.
+func (s *Store) DeleteDevice(device models.Device, actor string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Device{}, device.ID).Error; err != nil {
+			return fmt.Errorf("failed to delete device %s: %v", device.Name, err)
+		}
+		return audit(tx, actor, "delete", device.Name, device)
+	})
+}
+
+// audit appends an entry to the audit log.
+func audit(tx *gorm.DB, actor, action, device string, detail interface{}) error {
+	payload, err := json.Marshal(detail)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit detail: %v", err)
+	}
+	entry := models.AuditLog{Actor: actor, Action: action, Device: device, Detail: string(payload)}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
+
+// Profiles returns every point profile by name.
+func (s *Store) Profiles() (map[string]models.ConfigPoint, error) {
+	var rows []models.PointProfile
+	if err := s.db.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load point profiles: %v", err)
+	}
+
+	profiles := make(map[string]models.ConfigPoint, len(rows))
+	for _, row := range rows {
+		var points models.ConfigPoint
+		if err := json.Unmarshal([]byte(row.Definition), &points); err != nil {
+			return nil, fmt.Errorf("failed to parse point profile %s: %v", row.Name, err)
+		}
+		profiles[row.Name] = points
+	}
+	return profiles, nil
+}
+
+// SaveProfile creates or replaces the named point profile.
+func (s *Store) SaveProfile(name string, points models.ConfigPoint) error {
+	definition, err := json.Marshal(points)
+	if err != nil {
+		return fmt.Errorf("failed to marshal point profile %s: %v", name, err)
+	}
+
+	profile := models.PointProfile{Name: name}
+	err = s.db.Where(models.PointProfile{Name: name}).
+		Assign(models.PointProfile{Definition: string(definition)}).
+		FirstOrCreate(&profile).Error
+	if err != nil {
+		return fmt.Errorf("failed to save point profile %s: %v", name, err)
+	}
+	return nil
+}
+
+// AlarmRules returns the enabled alarm definitions keyed by measurement name.
+func (s *Store) AlarmRules() (map[string][]models.AlarmRule, error) {
+	var rows []models.AlarmDefinition
+	if err := s.db.Where("enabled = ?", true).Order("id").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load alarm definitions: %v", err)
+	}
+
+	rules := make(map[string][]models.AlarmRule)
+	for _, row := range rows {
+		rules[row.Measurement] = append(rules[row.Measurement], models.AlarmRule{
+			Name:      row.Name,
+			Devices:   row.Devices,
+			Type:      row.Type,
+			Condition: row.Condition,
+			Threshold: row.Threshold,
+			Deadband:  row.Deadband,
+			DelayOn:   row.DelayOn,
+			DelayOff:  row.DelayOff,
+			Severity:  row.Severity,
+		})
+	}
+	return rules, nil
+}
+
+// RecordAlarmEvent appends event to the alarm history.
+func (s *Store) RecordAlarmEvent(event models.AlarmEvent) error {
+	record := models.AlarmEventRecord{
+		Rule:        event.Rule,
+		Measurement: event.Measurement,
+		Device:      event.Device,
+		Severity:    event.Severity,
+		State:       event.State,
+		Value:       event.Value,
+		Threshold:   event.Threshold,
+		Timestamp:   event.Timestamp,
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		return fmt.Errorf("failed to record alarm event: %v", err)
+	}
+	return nil
+}
+
+// StartRun records the start of a collector run.
+func (s *Store) StartRun(devices int) (*models.RunHistory, error) {
+	run := &models.RunHistory{StartedAt: time.Now(), Devices: devices, Status: "running"}
+	if err := s.db.Create(run).Error; err != nil {
+		return nil, fmt.Errorf("failed to record run: %v", err)
+	}
+	return run, nil
+}
+
+// FinishRun records the end of a collector run.
+func (s *Store) FinishRun(run *models.RunHistory, status string) error {
+	now := time.Now()
+	run.FinishedAt = &now
+	run.Status = status
+	if err := s.db.Save(run).Error; err != nil {
+		return fmt.Errorf("failed to record run: %v", err)
+	}
+	return nil
+}
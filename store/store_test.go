@@ -0,0 +1,158 @@
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"example.com/tool/models"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open("sqlite", filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if db, err := s.db.DB(); err == nil {
+			db.Close()
+		}
+	})
+	return s
+}
+
+func TestOpenRejectsUnknownDialect(t *testing.T) {
+	if _, err := Open("postgres", ""); err == nil {
+		t.Error("unknown dialect accepted")
+	}
+}
+
+func TestRecordAlarmEvent(t *testing.T) {
+	s := openTestStore(t)
+	events := []models.AlarmEvent{
+		{Rule: "high", Measurement: "kw", Device: "root.test.equipment1", Severity: "critical", State: "raise", Value: 150, Threshold: 100, Timestamp: 1000},
+		{Rule: "high", Measurement: "kw", Device: "root.test.equipment1", Severity: "critical", State: "clear", Value: 50, Threshold: 100, Timestamp: 2000},
+	}
+	for _, event := range events {
+		if err := s.RecordAlarmEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var records []models.AlarmEventRecord
+	if err := s.db.Order("id").Find(&records).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(events) {
+		t.Fatalf("%d alarm events recorded, want %d", len(records), len(events))
+	}
+	for i, record := range records {
+		event := events[i]
+		if record.Rule != event.Rule || record.Measurement != event.Measurement || record.Device != event.Device || record.Severity != event.Severity ||
+			record.State != event.State || record.Value != event.Value || record.Threshold != event.Threshold || record.Timestamp != event.Timestamp ||
+			record.CreatedAt.IsZero() {
+			t.Errorf("record %+v, want %+v", record, event)
+		}
+	}
+}
+
+func TestSaveAndDeleteDeviceAreAudited(t *testing.T) {
+	s := openTestStore(t)
+
+	device := models.Device{Name: "equipment1", Group: "a", Profile: "default", Port: 3001, Enabled: true}
+	if err := s.SaveDevice(&device, "alice", "create"); err != nil {
+		t.Fatal(err)
+	}
+	if device.ID == 0 {
+		t.Fatal("no ID assigned to the new device")
+	}
+	device.Enabled = false
+	if err := s.SaveDevice(&device, "bob", "update"); err != nil {
+		t.Fatal(err)
+	}
+	// A second device of the same name violates the unique index, and nothing is audited
+	duplicate := models.Device{Name: "equipment1", Port: 3002}
+	if err := s.SaveDevice(&duplicate, "alice", "create"); err == nil {
+		t.Error("duplicate device saved")
+	}
+
+	all, err := s.AllDevices()
+	if err != nil || len(all) != 1 || all[0].Enabled {
+		t.Fatalf("devices %+v: %v", all, err)
+	}
+	if enabled, err := s.Devices(); err != nil || len(enabled) != 0 {
+		t.Errorf("enabled devices %+v: %v", enabled, err)
+	}
+
+	if err := s.DeleteDevice(device, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := s.CountDevices(); err != nil || count != 0 {
+		t.Errorf("%d devices after delete: %v", count, err)
+	}
+
+	var entries []models.AuditLog
+	if err := s.db.Order("id").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Actor+" "+entry.Action+" "+entry.Device)
+	}
+	want := []string{"alice create equipment1", "bob update equipment1", "alice delete equipment1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("audit log %v, want %v", got, want)
+	}
+}
+
+func TestSeedRangeKeepsExistingDevices(t *testing.T) {
+	s := openTestStore(t)
+	existing := models.Device{Name: "equipment2", Group: "manual", Profile: "custom", Port: 4000}
+	if err := s.SaveDevice(&existing, "alice", "create"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SeedRange("a", 3001, 1, 3, "default"); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := s.AllDevices()
+	if err != nil || len(devices) != 3 {
+		t.Fatalf("devices %+v: %v", devices, err)
+	}
+	for _, device := range devices {
+		if device.Name == "equipment2" && (device.Group != "manual" || device.Port != 4000 || device.Enabled) {
+			t.Errorf("existing device changed to %+v", device)
+		}
+	}
+}
+
+func TestProfilesAndAlarmRules(t *testing.T) {
+	s := openTestStore(t)
+	points := models.ConfigPoint{CommonSetting: models.CommonSetting{Company: "test", Frequency: 5}}
+	if err := s.SaveProfile("default", points); err != nil {
+		t.Fatal(err)
+	}
+	points.CommonSetting.Frequency = 10
+	if err := s.SaveProfile("default", points); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := s.Profiles()
+	if err != nil || len(profiles) != 1 || profiles["default"].CommonSetting.Frequency != 10 {
+		t.Errorf("profiles %+v: %v", profiles, err)
+	}
+
+	for _, definition := range []models.AlarmDefinition{
+		{Measurement: "kw", Name: "high", Condition: ">", Threshold: 100, Enabled: true},
+		{Measurement: "kw", Name: "off", Condition: ">", Threshold: 200},
+		{Measurement: "pf", Name: "low", Condition: "<", Threshold: 0.8, Enabled: true},
+	} {
+		if err := s.db.Create(&definition).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules, err := s.AlarmRules()
+	if err != nil || len(rules["kw"]) != 1 || rules["kw"][0].Name != "high" || len(rules["pf"]) != 1 {
+		t.Errorf("alarm rules %+v: %v", rules, err)
+	}
+}
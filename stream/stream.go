@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/tool/models"
+)
+
+// Subscription receives the decoded samples matching its filter.
+// A subscription never blocks the pipeline: when its buffer is full the oldest sample is dropped.
+type Subscription struct {
+	filter   models.StreamFilter
+	interval int64            // minimum milliseconds between two samples of the same device
+	lastSent map[string]int64 // device path → timestamp of the last delivered sample
+	samples  chan models.StreamSample
+	dropped  atomic.Uint64
+}
+
+// Samples returns the channel the subscription's samples are delivered on.
+// It is closed when the subscription is removed from its hub.
+func (s *Subscription) Samples() <-chan models.StreamSample {
+	return s.samples
+}
+
+// Dropped returns the number of samples discarded because the client was too slow.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Hub fans decoded samples out to live-stream subscriptions.
+type Hub struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	buffer int
+}
+
+// NewHub creates a hub whose subscriptions buffer up to buffer samples each.
+func NewHub(buffer int) *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{}), buffer: buffer}
+}
+
+// Subscribe registers a new subscription. Samples of the same device are delivered at most once per interval.
+func (h *Hub) Subscribe(filter models.StreamFilter, interval time.Duration) *Subscription {
+	sub := &Subscription{
+		filter:   filter,
+		interval: interval.Milliseconds(),
+		lastSent: make(map[string]int64),
+		samples:  make(chan models.StreamSample, h.buffer),
+	}
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub
+}
+
+// Unsubscribe removes the subscription and closes its sample channel.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.samples)
+	}
+}
+
+// Observe delivers data to every matching subscription.
+func (h *Hub) Observe(data models.SentData) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subs {
+		if sample, ok := sub.match(data); ok {
+			sub.deliver(sample)
+		}
+	}
+}
+
+// match applies the subscription's filter and throttle to data.
+func (s *Subscription) match(data models.SentData) (models.StreamSample, bool) {
+	name := data.Devices[strings.LastIndex(data.Devices, ".")+1:]
+	if !matchAny(s.filter.Devices, name) && !matchAny(s.filter.Devices, data.Devices) {
+		return models.StreamSample{}, false
+	}
+
+	if last, ok := s.lastSent[data.Devices]; ok && data.Timestamps-last < s.interval {
+		return models.StreamSample{}, false
+	}
+
+	values := make(map[string]float64)
+	for i, measurement := range data.MeasurementsList {
+		if matchAny(s.filter.Measurements, measurement) {
+			values[measurement] = data.ValuesList[i]
+		}
+	}
+	if len(values) == 0 {
+		return models.StreamSample{}, false
+	}
+
+	s.lastSent[data.Devices] = data.Timestamps
+	return models.StreamSample{
+		Name:      name,
+		Device:    data.Devices,
+		Timestamp: data.Timestamps,
+		Values:    values,
+	}, true
+}
+
+// deliver queues sample without blocking, discarding the oldest queued sample if the buffer is full.
+func (s *Subscription) deliver(sample models.StreamSample) {
+	sample.Dropped = s.dropped.Load()
+	select {
+	case s.samples <- sample:
+		return
+	default:
+	}
+
+	select {
+	case <-s.samples:
+		s.dropped.Add(1)
+	default:
+	}
+
+	select {
+	case s.samples <- sample:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// matchAny reports whether name matches one of the glob patterns. An empty list matches everything.
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
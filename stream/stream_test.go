@@ -0,0 +1,124 @@
+package stream
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"example.com/tool/models"
+)
+
+func sample(device string, timestamp int64) models.SentData {
+	return models.SentData{
+		Timestamps:       timestamp,
+		MeasurementsList: []string{"kw", "pf"},
+		DataTypesList:    []string{"DOUBLE", "DOUBLE"},
+		ValuesList:       []float64{float64(timestamp), 0.9},
+		IsAligned:        true,
+		Devices:          device,
+	}
+}
+
+// received returns every sample queued on sub so far.
+func received(sub *Subscription) []models.StreamSample {
+	var got []models.StreamSample
+	for {
+		select {
+		case s := <-sub.Samples():
+			got = append(got, s)
+		default:
+			return got
+		}
+	}
+}
+
+func TestSubscribeAndUnsubscribe(t *testing.T) {
+	hub := NewHub(10)
+	sub := hub.Subscribe(models.StreamFilter{}, 0)
+
+	hub.Observe(sample("root.test.equipment1", 1000))
+	want := []models.StreamSample{{Name: "equipment1", Device: "root.test.equipment1", Timestamp: 1000, Values: map[string]float64{"kw": 1000, "pf": 0.9}}}
+	if got := received(sub); !reflect.DeepEqual(got, want) {
+		t.Errorf("samples %+v, want %+v", got, want)
+	}
+
+	hub.Unsubscribe(sub)
+	hub.Unsubscribe(sub)
+	hub.Observe(sample("root.test.equipment1", 2000))
+	if _, ok := <-sub.Samples(); ok {
+		t.Error("sample delivered after Unsubscribe")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	hub := NewHub(10)
+	byName := hub.Subscribe(models.StreamFilter{Devices: []string{"equipment1*"}, Measurements: []string{"kw"}}, 0)
+	byPath := hub.Subscribe(models.StreamFilter{Devices: []string{"root.other.*"}}, 0)
+	none := hub.Subscribe(models.StreamFilter{Measurements: []string{"voltage"}}, 0)
+
+	hub.Observe(sample("root.test.equipment12", 1000))
+	hub.Observe(sample("root.test.equipment2", 1000))
+	hub.Observe(sample("root.other.equipment3", 1000))
+
+	if got := received(byName); len(got) != 1 || got[0].Name != "equipment12" || !reflect.DeepEqual(got[0].Values, map[string]float64{"kw": 1000}) {
+		t.Errorf("samples by name %+v", got)
+	}
+	if got := received(byPath); len(got) != 1 || got[0].Device != "root.other.equipment3" || len(got[0].Values) != 2 {
+		t.Errorf("samples by path %+v", got)
+	}
+	// A sample without any matching measurement is not delivered at all
+	if got := received(none); len(got) != 0 {
+		t.Errorf("samples without matching measurements %+v", got)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	hub := NewHub(10)
+	sub := hub.Subscribe(models.StreamFilter{}, time.Second)
+
+	for _, timestamp := range []int64{1000, 1500, 2000, 2999, 3000} {
+		hub.Observe(sample("root.test.equipment1", timestamp))
+	}
+	hub.Observe(sample("root.test.equipment2", 1500))
+
+	var got []int64
+	for _, s := range received(sub) {
+		got = append(got, s.Timestamp)
+	}
+	if !reflect.DeepEqual(got, []int64{1000, 2000, 3000, 1500}) {
+		t.Errorf("timestamps %v", got)
+	}
+}
+
+func TestSlowSubscriberDropsOldest(t *testing.T) {
+	hub := NewHub(2)
+	slow := hub.Subscribe(models.StreamFilter{}, 0)
+	fast := hub.Subscribe(models.StreamFilter{}, 0)
+
+	// Observe never blocks, however far behind the slow subscriber is
+	done := make(chan struct{})
+	var fastGot []models.StreamSample
+	go func() {
+		defer close(done)
+		for timestamp := int64(1); timestamp <= 5; timestamp++ {
+			hub.Observe(sample("root.test.equipment1", timestamp))
+			fastGot = append(fastGot, received(fast)...)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Observe blocked on a full subscription")
+	}
+
+	got := received(slow)
+	if len(got) != 2 || got[0].Timestamp != 4 || got[1].Timestamp != 5 {
+		t.Fatalf("slow subscriber kept %+v, want the 2 newest samples", got)
+	}
+	if slow.Dropped() != 3 || got[1].Dropped != 2 {
+		t.Errorf("dropped %d, last sample reports %d", slow.Dropped(), got[1].Dropped)
+	}
+	if len(fastGot) != 5 || fast.Dropped() != 0 {
+		t.Errorf("fast subscriber got %d samples and dropped %d", len(fastGot), fast.Dropped())
+	}
+}